@@ -0,0 +1,149 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
+)
+
+const usage = `compactstorage deletes the storage slots set to zero that earlier versions of the EVM chaincode
+kept in the world state, for the contracts whose addresses are given as arguments. The slots are
+listed with listStorage queries, a page at a time, and the slots set to zero are deleted by a
+compactStorage transaction per page. The user must be an admin of the instance.
+
+	Usage: compactstorage <address>...
+
+	It uses the environment variables of Fab3 to communicate with the Fabric network:
+	  FABPROXY_CONFIG - Path to a compatible Fabric SDK Go config file
+	  FABPROXY_USER - User identity being used (Matches the users names in the crypto-config directory specified in the config)
+	  FABPROXY_ORG - Organization of the specified user
+	  FABPROXY_CHANNEL - Channel the EVM chaincode is instantiated on
+	  FABPROXY_CCID - ID of the EVM Chaincode deployed in your fabric network
+	`
+
+// zeroValue is the hex encoded value of a storage slot set to zero, as
+// listStorage returns it.
+var zeroValue = strings.Repeat("00", 32)
+
+// storagePage is a page of the storage of a contract returned by listStorage.
+type storagePage struct {
+	Storage []struct {
+		Slot  string `json:"slot"`
+		Value string `json:"value"`
+	} `json:"storage"`
+	Bookmark string `json:"bookmark"`
+}
+
+// compactionResult is the result of a compactStorage transaction.
+type compactionResult struct {
+	Removed int `json:"removed"`
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, "%s\n", usage)
+		os.Exit(1)
+	}
+
+	cfg := grabEnvVar("FABPROXY_CONFIG")
+	org := grabEnvVar("FABPROXY_ORG")
+	user := grabEnvVar("FABPROXY_USER")
+	ch := grabEnvVar("FABPROXY_CHANNEL")
+	ccid := grabEnvVar("FABPROXY_CCID")
+
+	sdk, err := fabsdk.New(config.FromFile(cfg))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create Fabric SDK Client: %s\n", err)
+		os.Exit(1)
+	}
+	defer sdk.Close()
+
+	client, err := channel.New(sdk.ChannelContext(ch, fabsdk.WithUser(user), fabsdk.WithOrg(org)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create Fabric SDK Channel Client: %s\n", err)
+		os.Exit(1)
+	}
+
+	for _, address := range os.Args[1:] {
+		removed, err := compact(client, ccid, address)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to compact the storage of %s: %s\n", address, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: %d slots removed\n", address, removed)
+	}
+}
+
+// compact deletes the storage slots of the contract at address set to zero
+// and returns the number of slots deleted.
+func compact(client *channel.Client, ccid, address string) (int, error) {
+	removed := 0
+	bookmark := ""
+
+	for {
+		// Paginated queries cannot be run by a transaction that writes, the
+		// slots are listed by a query first
+		resp, err := client.Query(channel.Request{
+			ChaincodeID: ccid,
+			Fcn:         "listStorage",
+			Args:        [][]byte{[]byte(address), []byte(bookmark)},
+		})
+		if err != nil {
+			return removed, fmt.Errorf("failed to list storage: %s", err)
+		}
+
+		var page storagePage
+		if err = json.Unmarshal(resp.Payload, &page); err != nil {
+			return removed, fmt.Errorf("failed to unmarshal storage: %s", err)
+		}
+
+		var zeros []string
+		for _, slot := range page.Storage {
+			if slot.Value == zeroValue {
+				zeros = append(zeros, slot.Slot)
+			}
+		}
+
+		if len(zeros) != 0 {
+			resp, err = client.Execute(channel.Request{
+				ChaincodeID: ccid,
+				Fcn:         "compactStorage",
+				Args:        [][]byte{[]byte(address), []byte(strings.Join(zeros, ","))},
+			})
+			if err != nil {
+				return removed, fmt.Errorf("failed to compact storage: %s", err)
+			}
+
+			var result compactionResult
+			if err = json.Unmarshal(resp.Payload, &result); err != nil {
+				return removed, fmt.Errorf("failed to unmarshal result: %s", err)
+			}
+			removed += result.Removed
+		}
+
+		if page.Bookmark == "" {
+			return removed, nil
+		}
+		bookmark = page.Bookmark
+	}
+}
+
+func grabEnvVar(varName string) string {
+	envVar := os.Getenv(varName)
+	if envVar == "" {
+		fmt.Fprintf(os.Stderr, "compactstorage requires the environment variable %s to be set\n\n%s\n\n", varName, usage)
+		os.Exit(1)
+	}
+	return envVar
+}
@@ -0,0 +1,153 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package envelope
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// Function is the chaincode function name of an invocation carrying an
+	// envelope. It cannot be mistaken for the hex encoded callee address of
+	// the legacy form.
+	Function = "evm"
+	// Version is the version of the envelope this package produces and
+	// understands.
+	Version = 1
+)
+
+// Functions are the functions of the EVM chaincode, invoked by name instead of
+// the address of a contract. The EVM chaincode dispatches them, and clients
+// such as fab3 tell them apart from contract calls, from this list.
+var Functions = []string{
+	"account", "genesis", "listInstances", "advanceBlock", "digestState",
+	"query", "trace", "at", "instance",
+	"getCode", "setGasLimit", "getBalance", "getNonce", "getPermissions",
+	"dumpAccount", "importState", "mint", "setACL", "getStorageAt",
+	"removeOrphanedStorage", "upgradeCode", "listStorage", "migrateStorage",
+	"compactStorage", "exportState", "createInstance", "scanCollectionStorage",
+	"removeOrphanedCollectionStorage", "migrateCollectionStorage",
+}
+
+// IsFunction reports whether arg, the first argument of an invocation, is the
+// name of a function of the EVM chaincode rather than a contract address.
+func IsFunction(arg string) bool {
+	for _, f := range Functions {
+		if f == arg {
+			return true
+		}
+	}
+	return false
+}
+
+// Envelope describes a contract deployment or invocation of the EVM
+// chaincode. It is sent JSON encoded as the only argument following
+// Function.
+//
+// Addresses and data are hex encoded, quantities are hex encoded integers,
+// both with an optional `0x` prefix, the way Ethereum json-rpc encodes them.
+type Envelope struct {
+	Version int `json:"version"`
+	// To is the address of the contract to call. It is empty, or the zero
+	// address, when deploying a contract.
+	To    string `json:"to,omitempty"`
+	Data  string `json:"data,omitempty"`
+	Value string `json:"value,omitempty"`
+	Gas   string `json:"gas,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+	// Salt makes the address of a contract being deployed depend on the
+	// deployer, the salt and the deploy code only, the way CREATE2 does.
+	Salt string `json:"salt,omitempty"`
+	// Collection is the private data collection holding the storage of a
+	// contract being deployed.
+	Collection string `json:"collection,omitempty"`
+	// ACL is the access control list of a contract being deployed. It is
+	// passed as is to the chaincode.
+	ACL json.RawMessage `json:"acl,omitempty"`
+	// Flags are options of the invocation. The chaincode rejects flags it
+	// does not know.
+	Flags []string `json:"flags,omitempty"`
+}
+
+// New returns an Envelope of the current version.
+func New() *Envelope {
+	return &Envelope{Version: Version}
+}
+
+// Unmarshal decodes a JSON encoded envelope of a supported version.
+func Unmarshal(doc []byte) (*Envelope, error) {
+	env := &Envelope{}
+	if err := json.Unmarshal(doc, env); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal envelope: %s", err)
+	}
+
+	if env.Version != Version {
+		return nil, fmt.Errorf("unsupported envelope version %d", env.Version)
+	}
+
+	return env, nil
+}
+
+// FromArgs decodes the arguments of a contract deployment or invocation. It
+// understands the envelope, as well as the legacy positional form
+// '<callee address>, <input data>[, <gas limit>]'.
+func FromArgs(args [][]byte) (*Envelope, error) {
+	if len(args) > 0 && string(args[0]) == Function {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("expects an envelope as only argument, got %d args", len(args)-1)
+		}
+		return Unmarshal(args[1])
+	}
+
+	if len(args) != 2 && len(args) != 3 {
+		return nil, fmt.Errorf("expects 2 or 3 args, got %d", len(args))
+	}
+
+	env := New()
+	env.To = string(args[0])
+	env.Data = string(args[1])
+	if len(args) == 3 {
+		env.Gas = string(args[2])
+	}
+
+	return env, nil
+}
+
+// Args encodes the envelope as the arguments of a chaincode invocation.
+func (e *Envelope) Args() ([][]byte, error) {
+	doc, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal envelope: %s", err)
+	}
+
+	return [][]byte{[]byte(Function), doc}, nil
+}
+
+// ParseData decodes hex encoded data, empty data decodes to nil.
+func ParseData(data string) ([]byte, error) {
+	return hex.DecodeString(Strip0x(data))
+}
+
+// ParseQuantity decodes a hex encoded quantity, an empty quantity is 0.
+func ParseQuantity(quantity string) (uint64, error) {
+	quantity = Strip0x(quantity)
+	if quantity == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(quantity, 16, 64)
+}
+
+// Strip0x removes the `0x` prefix of hex encoded values.
+func Strip0x(s string) string {
+	if len(s) >= 2 && s[0:2] == "0x" {
+		return s[2:]
+	}
+	return s
+}
@@ -0,0 +1,19 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package envelope_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Envelope Suite")
+}
@@ -0,0 +1,88 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package envelope_test
+
+import (
+	"github.com/hyperledger/fabric-chaincode-evm/envelope"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Envelope", func() {
+	Describe("FromArgs", func() {
+		It("decodes the envelope form", func() {
+			env := envelope.New()
+			env.To = "0x1234"
+			env.Data = "0xabcd"
+			env.Value = "0x10"
+			env.Flags = []string{"some-flag"}
+
+			args, err := env.Args()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(args).To(HaveLen(2))
+			Expect(string(args[0])).To(Equal(envelope.Function))
+
+			decoded, err := envelope.FromArgs(args)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decoded).To(Equal(env))
+		})
+
+		It("decodes the legacy form", func() {
+			env, err := envelope.FromArgs([][]byte{[]byte("1234"), []byte("abcd")})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(env).To(Equal(&envelope.Envelope{Version: envelope.Version, To: "1234", Data: "abcd"}))
+		})
+
+		It("decodes the legacy form with a gas limit", func() {
+			env, err := envelope.FromArgs([][]byte{[]byte("1234"), []byte("abcd"), []byte("ff")})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(env.Gas).To(Equal("ff"))
+		})
+
+		Context("when the envelope version is not supported", func() {
+			It("returns an error", func() {
+				_, err := envelope.FromArgs([][]byte{[]byte(envelope.Function), []byte(`{"version":2}`)})
+				Expect(err).To(MatchError("unsupported envelope version 2"))
+			})
+		})
+
+		Context("when the number of args is wrong", func() {
+			It("returns an error", func() {
+				_, err := envelope.FromArgs([][]byte{[]byte("1234")})
+				Expect(err).To(MatchError(ContainSubstring("expects 2 or 3 args")))
+
+				_, err = envelope.FromArgs([][]byte{[]byte(envelope.Function), []byte(`{"version":1}`), []byte("extra")})
+				Expect(err).To(MatchError(ContainSubstring("expects an envelope as only argument")))
+			})
+		})
+	})
+
+	Describe("IsFunction", func() {
+		It("recognizes the functions of the chaincode", func() {
+			for _, f := range envelope.Functions {
+				Expect(envelope.IsFunction(f)).To(BeTrue())
+			}
+		})
+
+		It("does not mistake addresses and envelopes for functions", func() {
+			Expect(envelope.IsFunction("0000000000000000000000000000000000000000")).To(BeFalse())
+			Expect(envelope.IsFunction(envelope.Function)).To(BeFalse())
+		})
+	})
+
+	Describe("ParseQuantity", func() {
+		It("decodes hex quantities", func() {
+			Expect(envelope.ParseQuantity("0x1f")).To(Equal(uint64(31)))
+			Expect(envelope.ParseQuantity("1f")).To(Equal(uint64(31)))
+			Expect(envelope.ParseQuantity("")).To(Equal(uint64(0)))
+
+			_, err := envelope.ParseQuantity("0xzz")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,129 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// unpackString decodes the ABI encoded string whose offset is stored in the
+// word at position pos of data.
+func unpackString(data []byte, pos uint64) (string, error) {
+	b, err := unpackBytes(data, pos)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// unpackBytes decodes the ABI encoded dynamic byte array whose offset is
+// stored in the word at position pos of data.
+func unpackBytes(data []byte, pos uint64) ([]byte, error) {
+	offset, err := unpackUint(data, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := unpackUint(data, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	start := offset + 32
+	if size > uint64(len(data)) || start+size > uint64(len(data)) {
+		return nil, fmt.Errorf("abi: data of length %d out of bounds at offset %d", size, start)
+	}
+
+	return data[start : start+size], nil
+}
+
+// unpackUint reads the word at offset of data as an unsigned integer that is
+// small enough to be used as a size or an offset.
+func unpackUint(data []byte, offset uint64) (uint64, error) {
+	if offset > uint64(len(data)) || offset+32 > uint64(len(data)) {
+		return 0, fmt.Errorf("abi: word at offset %d out of bounds", offset)
+	}
+
+	word := data[offset : offset+32]
+	for _, b := range word[:24] {
+		if b != 0 {
+			return 0, fmt.Errorf("abi: value at offset %d too large", offset)
+		}
+	}
+
+	return binary.BigEndian.Uint64(word[24:]), nil
+}
+
+// unpackBytesArray decodes the ABI encoded array of dynamic byte arrays whose
+// offset is stored in the word at position pos of data.
+func unpackBytesArray(data []byte, pos uint64) ([][]byte, error) {
+	offset, err := unpackUint(data, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := unpackUint(data, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	// The offsets of the items are relative to the first item
+	content := data[offset+32:]
+	if length > uint64(len(content))/32 {
+		return nil, fmt.Errorf("abi: array of length %d out of bounds at offset %d", length, offset)
+	}
+
+	items := make([][]byte, length)
+	for i := range items {
+		items[i], err = unpackBytes(content, uint64(i)*32)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return items, nil
+}
+
+// packUint encodes v as an ABI word.
+func packUint(v uint64) []byte {
+	word := make([]byte, 32)
+	binary.BigEndian.PutUint64(word[24:], v)
+	return word
+}
+
+// packBool encodes b as an ABI word.
+func packBool(b bool) []byte {
+	if b {
+		return packUint(1)
+	}
+	return packUint(0)
+}
+
+// packInt encodes v as an ABI word, in two's complement.
+func packInt(v int64) []byte {
+	word := packUint(uint64(v))
+	if v < 0 {
+		for i := range word[:24] {
+			word[i] = 0xff
+		}
+	}
+	return word
+}
+
+// packBytes encodes the tail of a dynamic byte array, its length followed by
+// its content padded to a multiple of 32 bytes.
+func packBytes(b []byte) []byte {
+	padded := make([]byte, (len(b)+31)/32*32)
+	copy(padded, b)
+	return append(packUint(uint64(len(b))), padded...)
+}
+
+// packString encodes a string as the only return value of a function.
+func packString(s string) []byte {
+	return append(packUint(32), packBytes([]byte(s))...)
+}
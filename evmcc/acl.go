@@ -0,0 +1,201 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/burrow/acm"
+	"github.com/hyperledger/burrow/acm/state"
+	"github.com/hyperledger/burrow/crypto"
+	"github.com/hyperledger/burrow/execution/errors"
+	"github.com/hyperledger/burrow/execution/evm"
+	"github.com/hyperledger/fabric-chaincode-evm/evmerrors"
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// parseACL decodes a JSON encoded access control list. A null document
+// removes the ACL of a contract.
+func parseACL(doc []byte) (*statemanager.ACL, error) {
+	var acl *statemanager.ACL
+	if err := json.Unmarshal(doc, &acl); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal acl: %s", err)
+	}
+	return acl, nil
+}
+
+// checkACL fails unless the creator of the transaction satisfies the
+// restrictions of acl.
+func checkACL(stub shim.ChaincodeStubInterface, acl *statemanager.ACL) error {
+	if acl == nil {
+		return nil
+	}
+
+	if len(acl.MSPIDs) != 0 {
+		mspID, err := cid.GetMSPID(stub)
+		if err != nil {
+			return fmt.Errorf("failed to get MSP ID: %s", err)
+		}
+
+		if !contains(acl.MSPIDs, mspID) {
+			return fmt.Errorf("MSP %s is not allowed", mspID)
+		}
+	}
+
+	if len(acl.OUs) != 0 {
+		cert, err := cid.GetX509Certificate(stub)
+		if err != nil {
+			return fmt.Errorf("failed to get certificate: %s", err)
+		}
+
+		allowed := false
+		for _, ou := range cert.Subject.OrganizationalUnit {
+			if contains(acl.OUs, ou) {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return fmt.Errorf("organizational units %q are not allowed", cert.Subject.OrganizationalUnit)
+		}
+	}
+
+	for name, expected := range acl.Attributes {
+		value, found, err := cid.GetAttributeValue(stub, name)
+		if err != nil {
+			return fmt.Errorf("failed to get attribute %s: %s", name, err)
+		}
+
+		if !found || value != expected {
+			return fmt.Errorf("attribute %s=%s is required", name, expected)
+		}
+	}
+
+	return nil
+}
+
+// setACL replaces the access control list of a contract. It is restricted to
+// the admins of the instance.
+func (evmcc *EvmChaincode) setACL(state statemanager.StateManager, stub shim.ChaincodeStubInterface, cfg *Config, address, doc []byte) pb.Response {
+	if err := checkAdmin(stub, cfg); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	addr, err := parseAddress(address)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if len(state.GetCode(addr)) == 0 {
+		return errorResponse(evmerrors.CodeNotFound, "no contract at %s", addr)
+	}
+
+	acl, err := parseACL(doc)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	metadata, err := state.GetMetadata(addr)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to get metadata: %s", err.Error()))
+	}
+
+	if metadata == nil {
+		metadata = &statemanager.ContractMetadata{}
+	}
+	metadata.ACL = acl
+
+	if err = state.SetMetadata(addr, metadata); err != nil {
+		return shim.Error(fmt.Sprintf("failed to set acl: %s", err.Error()))
+	}
+
+	return shim.Success(nil)
+}
+
+// aclState checks the ACL of every contract the vm calls, so that a contract
+// cannot be used as a proxy to call a restricted contract on behalf of a
+// creator its ACL denies. The vm creates the frame of a call, then looks up
+// the code of the callee to run it, which is where the ACL is checked. Other
+// reads of the code, e.g. by EXTCODESIZE, are not calls and are not checked.
+// A denial fails the transaction rather than the call alone, so that the
+// calling contract cannot recover from it.
+type aclState struct {
+	statemanager.StateManager
+	root    statemanager.StateManager
+	stub    shim.ChaincodeStubInterface
+	allowed map[crypto.Address]bool
+	// calling is shared by the frames, it is set from the creation of the
+	// frame of a call until the code of the callee is looked up.
+	calling *bool
+}
+
+func newACLState(root statemanager.StateManager, stub shim.ChaincodeStubInterface) *aclState {
+	return &aclState{StateManager: root, root: root, stub: stub, allowed: make(map[crypto.Address]bool), calling: new(bool)}
+}
+
+func (s *aclState) NewCache(cacheOptions ...state.CacheOption) evm.Interface {
+	*s.calling = true
+	return &aclState{
+		StateManager: s.StateManager.NewCache(cacheOptions...).(statemanager.StateManager),
+		root:         s.root,
+		stub:         s.stub,
+		allowed:      s.allowed,
+		calling:      s.calling,
+	}
+}
+
+// CreateAccount is called by the vm when a frame deploys a contract, whose
+// code is not looked up, or sends value to a new account, which has no ACL.
+func (s *aclState) CreateAccount(address crypto.Address) {
+	*s.calling = false
+	s.StateManager.CreateAccount(address)
+}
+
+func (s *aclState) GetCode(address crypto.Address) acm.Bytecode {
+	if *s.calling {
+		*s.calling = false
+		if err := s.check(address); err != nil {
+			s.root.PushError(err)
+			return nil
+		}
+	}
+	return s.StateManager.GetCode(address)
+}
+
+func (s *aclState) check(address crypto.Address) error {
+	if s.allowed[address] {
+		return nil
+	}
+
+	metadata, err := s.StateManager.GetMetadata(address)
+	if err != nil {
+		return fmt.Errorf("failed to get contract metadata: %s", err)
+	}
+
+	if metadata != nil {
+		if err = checkACL(s.stub, metadata.ACL); err != nil {
+			return errors.ErrorCodef(errors.ErrorCodePermissionDenied, "access denied to contract %s: %s", address, err)
+		}
+	}
+
+	s.allowed[address] = true
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/burrow/crypto"
+	keccak "github.com/hyperledger/burrow/execution/evm/sha3"
+	"github.com/hyperledger/fabric-chaincode-evm/envelope"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// AddressSchemeSHA3 derives addresses from the SHA3-256 hash of the PKIX
+	// encoded public key of the creator. It is the default scheme.
+	AddressSchemeSHA3 = "sha3"
+	// AddressSchemeKeccak derives addresses the way Ethereum does, from the
+	// Keccak-256 hash of the uncompressed ECDSA public key of the creator.
+	AddressSchemeKeccak = "keccak"
+	// AddressSchemeAttribute takes the address of the creator from the
+	// AddressAttribute of its certificate, as issued by the Fabric CA.
+	AddressSchemeAttribute = "attribute"
+	// AddressSchemeMSP derives addresses from the Keccak-256 hash of the MSP
+	// ID and the certificate subject of the creator, so that an identity
+	// keeps its address when its keys are renewed.
+	AddressSchemeMSP = "msp"
+)
+
+// AddressAttribute is the certificate attribute holding the hex encoded
+// address of the creator with the AddressSchemeAttribute scheme.
+const AddressAttribute = "eth.address"
+
+// addressScheme derives the address of the creator of a transaction from its
+// MSP ID and certificate.
+type addressScheme func(stub shim.ChaincodeStubInterface, mspID string, cert *x509.Certificate) (crypto.Address, error)
+
+var addressSchemes = map[string]addressScheme{
+	AddressSchemeSHA3:      sha3Address,
+	AddressSchemeKeccak:    keccakAddress,
+	AddressSchemeAttribute: attributeAddress,
+	AddressSchemeMSP:       mspAddress,
+}
+
+// getCallerAddress returns the address of the creator of the transaction,
+// derived with the address scheme of the instance.
+func getCallerAddress(stub shim.ChaincodeStubInterface, cfg *Config) (crypto.Address, error) {
+	scheme, ok := addressSchemes[cfg.AddressScheme]
+	if !ok {
+		return crypto.ZeroAddress, fmt.Errorf("unknown address scheme %q", cfg.AddressScheme)
+	}
+
+	creatorBytes, err := stub.GetCreator()
+	if err != nil {
+		return crypto.ZeroAddress, fmt.Errorf("failed to get creator: %s", err)
+	}
+
+	si := &msp.SerializedIdentity{}
+	if err = proto.Unmarshal(creatorBytes, si); err != nil {
+		return crypto.ZeroAddress, fmt.Errorf("failed to unmarshal serialized identity: %s", err)
+	}
+
+	bl, _ := pem.Decode(si.IdBytes)
+	if bl == nil {
+		return crypto.ZeroAddress, fmt.Errorf("identity is not a PEM encoded certificate")
+	}
+
+	cert, err := x509.ParseCertificate(bl.Bytes)
+	if err != nil {
+		return crypto.ZeroAddress, fmt.Errorf("failed to parse certificate: %s", err)
+	}
+
+	callerAddr, err := scheme(stub, si.Mspid, cert)
+	if err != nil {
+		return crypto.ZeroAddress, fmt.Errorf("fail to convert identity to address: %s", err)
+	}
+
+	return callerAddr, nil
+}
+
+func sha3Address(stub shim.ChaincodeStubInterface, mspID string, cert *x509.Certificate) (crypto.Address, error) {
+	pubkeyBytes, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return crypto.ZeroAddress, fmt.Errorf("unable to marshal public key: %s", err)
+	}
+
+	return crypto.AddressFromWord256(sha3.Sum256(pubkeyBytes)), nil
+}
+
+func keccakAddress(stub shim.ChaincodeStubInterface, mspID string, cert *x509.Certificate) (crypto.Address, error) {
+	pubkey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return crypto.ZeroAddress, fmt.Errorf("keccak addresses require an ECDSA public key, got %T", cert.PublicKey)
+	}
+
+	// The uncompressed point without its 0x04 prefix
+	pubkeyBytes := elliptic.Marshal(pubkey.Curve, pubkey.X, pubkey.Y)[1:]
+
+	return crypto.AddressFromBytes(keccak.Sha3(pubkeyBytes)[12:])
+}
+
+func attributeAddress(stub shim.ChaincodeStubInterface, mspID string, cert *x509.Certificate) (crypto.Address, error) {
+	value, found, err := cid.GetAttributeValue(stub, AddressAttribute)
+	if err != nil {
+		return crypto.ZeroAddress, fmt.Errorf("failed to get attribute %s: %s", AddressAttribute, err)
+	}
+
+	if !found {
+		return crypto.ZeroAddress, fmt.Errorf("certificate has no %s attribute", AddressAttribute)
+	}
+
+	addr, err := crypto.AddressFromHexString(envelope.Strip0x(value))
+	if err != nil {
+		return crypto.ZeroAddress, fmt.Errorf("invalid %s attribute %s: %s", AddressAttribute, value, err)
+	}
+
+	return addr, nil
+}
+
+func mspAddress(stub shim.ChaincodeStubInterface, mspID string, cert *x509.Certificate) (crypto.Address, error) {
+	// A zero byte, which MSP IDs do not contain, separates the MSP ID from
+	// the DER encoded subject
+	data := append([]byte(mspID), 0)
+	data = append(data, cert.RawSubject...)
+
+	return crypto.AddressFromBytes(keccak.Sha3(data)[12:])
+}
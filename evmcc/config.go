@@ -0,0 +1,245 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const (
+	// configKey is the world state key of the configuration of the chaincode
+	// instance. It cannot collide with the hex encoded account and storage
+	// keys written by the statemanager.
+	configKey = "evmcc.config"
+	// blockNumberKey holds the block number maintained by the chaincode when
+	// block numbers are counted.
+	blockNumberKey = "evmcc.blocknumber"
+)
+
+const (
+	// BlockNumberTimestamp derives block numbers from the transaction
+	// timestamp. Every endorser computes the same number for a proposal and
+	// no key is written, so concurrent transactions do not conflict.
+	BlockNumberTimestamp = "timestamp"
+	// BlockNumberCounter reads a counter stored in the ledger, advanced by
+	// the admins. Transactions only read it, so they do not conflict with
+	// each other, only with the transaction advancing the block.
+	BlockNumberCounter = "counter"
+)
+
+// DefaultGasLimit is the gas given to a transaction unless the instance is
+// configured with another limit.
+const DefaultGasLimit uint64 = 10000000000
+
+// Config is the configuration of an instance of the EVM chaincode. It is
+// provided as the argument of Init, in JSON, and stored in the ledger.
+type Config struct {
+	// Admins are the MSP IDs allowed to run the admin functions.
+	Admins []string `json:"admins,omitempty"`
+	// GasLimit is the maximum gas a transaction can use. Transactions
+	// requesting less gas are limited to what they asked for.
+	GasLimit uint64      `json:"gasLimit"`
+	Block    BlockConfig `json:"block"`
+	// Balances enables native value transfers. Balances can only be created
+	// by the admins, by minting.
+	Balances bool `json:"balances,omitempty"`
+	// AddressScheme is how the addresses of transaction creators are derived
+	// from their identity. It defaults to AddressSchemeSHA3.
+	AddressScheme string `json:"addressScheme,omitempty"`
+	// AccountEncoding is how accounts are written to the world state,
+	// statemanager.AccountEncodingProto, the default, or
+	// statemanager.AccountEncodingJSON to query them with CouchDB.
+	AccountEncoding string `json:"accountEncoding,omitempty"`
+}
+
+// BlockConfig controls the values of the BLOCKHASH, NUMBER and TIMESTAMP
+// opcodes.
+type BlockConfig struct {
+	// Number is either BlockNumberTimestamp, the default, or
+	// BlockNumberCounter.
+	Number string `json:"number,omitempty"`
+	// Interval is the number of seconds of a block when block numbers are
+	// derived from the transaction timestamp. It defaults to 1.
+	Interval int64 `json:"interval,omitempty"`
+	// Epoch is the unix time of block 0 when block numbers are derived from
+	// the transaction timestamp.
+	Epoch int64 `json:"epoch,omitempty"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		GasLimit: DefaultGasLimit,
+		Block: BlockConfig{
+			Number:   BlockNumberTimestamp,
+			Interval: 1,
+		},
+		AddressScheme:   AddressSchemeSHA3,
+		AccountEncoding: statemanager.AccountEncodingProto,
+	}
+}
+
+func (c *Config) validate() error {
+	if c.GasLimit == 0 {
+		return fmt.Errorf("gas limit must be positive")
+	}
+
+	switch c.Block.Number {
+	case BlockNumberTimestamp, BlockNumberCounter:
+	default:
+		return fmt.Errorf("unknown block number source %q", c.Block.Number)
+	}
+
+	if _, ok := addressSchemes[c.AddressScheme]; !ok {
+		return fmt.Errorf("unknown address scheme %q", c.AddressScheme)
+	}
+
+	switch c.AccountEncoding {
+	case statemanager.AccountEncodingProto, statemanager.AccountEncodingJSON:
+	default:
+		return fmt.Errorf("unknown account encoding %q", c.AccountEncoding)
+	}
+
+	if c.Block.Interval <= 0 {
+		return fmt.Errorf("block interval must be positive, got %d", c.Block.Interval)
+	}
+
+	if c.Block.Epoch < 0 {
+		return fmt.Errorf("block epoch must not be negative, got %d", c.Block.Epoch)
+	}
+
+	return nil
+}
+
+// stateOptions returns the options of the statemanager of the instance.
+func (c *Config) stateOptions() []statemanager.Option {
+	return []statemanager.Option{statemanager.WithAccountEncoding(c.AccountEncoding)}
+}
+
+// parseConfig decodes a configuration document. Fields that are not set keep
+// their default value.
+func parseConfig(doc []byte) (*Config, error) {
+	cfg := defaultConfig()
+	if err := json.Unmarshal(doc, cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %s", err)
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %s", err)
+	}
+
+	return cfg, nil
+}
+
+// getConfig returns the configuration of the chaincode instance, or the
+// default configuration if none was provided at Init.
+func getConfig(stub shim.ChaincodeStubInterface) (*Config, error) {
+	doc, err := stub.GetState(configKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config: %s", err)
+	}
+
+	if len(doc) == 0 {
+		return defaultConfig(), nil
+	}
+
+	return parseConfig(doc)
+}
+
+// initConfig stores the default configuration when the instance has none.
+func initConfig(stub shim.ChaincodeStubInterface) error {
+	doc, err := stub.GetState(configKey)
+	if err != nil {
+		return fmt.Errorf("failed to get config: %s", err)
+	}
+
+	if len(doc) != 0 {
+		return nil
+	}
+
+	return putConfig(stub, defaultConfig())
+}
+
+// checkUpgrade returns an error if cfg changes the settings the instance
+// cannot change once instantiated: the address scheme, which the addresses
+// of the creators and the accounts they own derive from, and the account
+// encoding, which the CouchDB indexes and queries rely on.
+func checkUpgrade(stub shim.ChaincodeStubInterface, cfg *Config) error {
+	doc, err := stub.GetState(configKey)
+	if err != nil {
+		return fmt.Errorf("failed to get config: %s", err)
+	}
+
+	if len(doc) == 0 {
+		return nil
+	}
+
+	current, err := parseConfig(doc)
+	if err != nil {
+		return err
+	}
+
+	if cfg.AddressScheme != current.AddressScheme {
+		return fmt.Errorf("the address scheme of the instance cannot be changed from %q to %q", current.AddressScheme, cfg.AddressScheme)
+	}
+
+	if cfg.AccountEncoding != current.AccountEncoding {
+		return fmt.Errorf("the account encoding of the instance cannot be changed from %q to %q", current.AccountEncoding, cfg.AccountEncoding)
+	}
+
+	return nil
+}
+
+func putConfig(stub shim.ChaincodeStubInterface, cfg *Config) error {
+	doc, err := json.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %s", err)
+	}
+
+	return stub.PutState(configKey, doc)
+}
+
+// currentBlockNumber returns the last block number counted in the ledger, 0
+// until the first block is advanced.
+func currentBlockNumber(stub shim.ChaincodeStubInterface) (uint64, error) {
+	val, err := stub.GetState(blockNumberKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get block number: %s", err)
+	}
+
+	if len(val) == 0 {
+		return 0, nil
+	}
+
+	number, err := strconv.ParseUint(string(val), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse block number: %s", err)
+	}
+
+	return number, nil
+}
+
+// nextBlockNumber increments and returns the block number counted in the
+// ledger.
+func nextBlockNumber(stub shim.ChaincodeStubInterface) (uint64, error) {
+	number, err := currentBlockNumber(stub)
+	if err != nil {
+		return 0, err
+	}
+
+	number++
+
+	if err = stub.PutState(blockNumberKey, []byte(strconv.FormatUint(number, 10))); err != nil {
+		return 0, fmt.Errorf("failed to store block number: %s", err)
+	}
+
+	return number, nil
+}
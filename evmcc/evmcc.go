@@ -7,24 +7,27 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
-	"crypto/x509"
 	"encoding/hex"
-	"encoding/pem"
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/burrow/acm"
 	"github.com/hyperledger/burrow/binary"
 	"github.com/hyperledger/burrow/crypto"
 	"github.com/hyperledger/burrow/execution/evm"
+	keccak "github.com/hyperledger/burrow/execution/evm/sha3"
 	"github.com/hyperledger/burrow/logging"
+	"github.com/hyperledger/fabric-chaincode-evm/envelope"
 	evm_event "github.com/hyperledger/fabric-chaincode-evm/event"
+	"github.com/hyperledger/fabric-chaincode-evm/evmerrors"
 	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
 	"github.com/hyperledger/fabric/common/flogging"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/hyperledger/fabric/protos/msp"
 	pb "github.com/hyperledger/fabric/protos/peer"
-	"golang.org/x/crypto/sha3"
 )
 
 var logger = flogging.MustGetLogger("evmcc")
@@ -32,95 +35,365 @@ var evmLogger = logging.NewNoopLogger()
 
 type EvmChaincode struct{}
 
+// Init optionally takes the genesis of the chaincode instance as a JSON
+// document: its configuration and the accounts it starts with. Without
+// arguments the current configuration is left as is, so that upgrading the
+// chaincode does not reset it, and the default configuration is stored when
+// there is none, so that the settings an upgrade cannot change are kept.
 func (evmcc *EvmChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
-	logger.Debugf("Init evmcc, it's no-op")
+	args := stub.GetArgs()
+	if len(args) > 0 && string(args[0]) == "init" {
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		logger.Debugf("Init evmcc without genesis")
+		if err := initConfig(stub); err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(nil)
+	}
+
+	if len(args) != 1 {
+		return shim.Error(fmt.Sprintf("expects at most 1 arg, got %d", len(args)))
+	}
+
+	genesis, err := parseGenesis(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err = checkUpgrade(stub, &genesis.Config); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Any error aborts the transaction, so that no account is created
+	if err = applyGenesis(stub, genesis); err != nil {
+		return shim.Error(fmt.Sprintf("failed to apply genesis: %s", err.Error()))
+	}
+
+	if err = putConfig(stub, &genesis.Config); err != nil {
+		return shim.Error(fmt.Sprintf("failed to store config: %s", err.Error()))
+	}
+
 	return shim.Success(nil)
 }
 
 func (evmcc *EvmChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
-	// We always expect 2 args: 'callee address, input data' or ' getCode ,  contract address'
+	// We expect 2 args: 'callee address, input data' or ' getCode ,  contract address'
+	// The gas limit of a contract call can be given as an optional third arg.
+	// Contract calls can also be sent as a versioned envelope: 'evm, envelope'
+	// Prefixed with 'query', a contract call runs on a read-only state.
+	// Prefixed with 'trace', it also returns the trace of its execution.
+	// Prefixed with 'at' and a point in time, the inspection functions read
+	// the state as it was then.
+	// Prefixed with 'instance' and its id, any of them runs on the state of
+	// that instance rather than on the default one.
 	args := stub.GetArgs()
 
-	state := statemanager.NewStateManager(stub)
+	if len(args) > 2 && string(args[0]) == "instance" {
+		return evmcc.instance(stub, args[1], args[2:])
+	}
+
+	return evmcc.invoke(stub, args)
+}
+
+func (evmcc *EvmChaincode) invoke(stub shim.ChaincodeStubInterface, args [][]byte) pb.Response {
+	if len(args) > 2 && string(args[0]) == "at" {
+		return evmcc.historical(stub, args[1], args[2:])
+	}
 
-	if len(args) == 1 {
-		if string(args[0]) == "account" {
-			return evmcc.account(state, stub)
+	var readonly bool
+	var t *tracer
+	if len(args) > 1 {
+		switch string(args[0]) {
+		case "trace":
+			t = newTracer()
+			fallthrough
+		case "query":
+			readonly = true
+			args = args[1:]
 		}
 	}
 
-	if len(args) != 2 {
-		return shim.Error(fmt.Sprintf("expects 2 args, got %d : %s", len(args), string(args[0])))
+	if len(args) == 1 && !readonly {
+		switch string(args[0]) {
+		case "account":
+			return evmcc.account(stub)
+		case "genesis":
+			return evmcc.genesis(stub)
+		case "listInstances":
+			return evmcc.listInstances(stub)
+		case "advanceBlock":
+			return evmcc.advanceBlock(stub)
+		case "digestState":
+			return evmcc.digestState(stub)
+		}
 	}
 
-	if string(args[0]) == "getCode" {
-		return evmcc.getCode(state, stub, args[1])
+	if len(args) < 2 || len(args) > 4 {
+		return errorResponse(evmerrors.CodeDecoding, "expects 2 to 4 args, got %d : %s", len(args), string(args[0]))
 	}
 
-	c, err := hex.DecodeString(string(args[0]))
+	cfg, err := getConfig(stub)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("failed to decode callee address from %s: %s", string(args[0]), err.Error()))
+		return shim.Error(err.Error())
 	}
 
-	calleeAddr, err := crypto.AddressFromBytes(c)
+	state := statemanager.NewStateManager(stub, cfg.stateOptions()...)
+	if t != nil {
+		// A trace runs the call the way a transaction does, on a frame that
+		// is never synced, so that its writes are discarded with it
+		state = state.NewCache().(statemanager.StateManager)
+	} else if readonly {
+		state = statemanager.NewReadOnlyStateManager(stub, cfg.stateOptions()...)
+	}
+
+	if len(args) == 2 && !readonly {
+		switch string(args[0]) {
+		case "getCode":
+			return evmcc.getCode(state, stub, args[1])
+		case "setGasLimit":
+			return evmcc.setGasLimit(stub, cfg, args[1])
+		case "getBalance":
+			return evmcc.getBalance(state, args[1])
+		case "getNonce":
+			return evmcc.getNonce(state, args[1])
+		case "getPermissions":
+			return evmcc.getPermissions(state, args[1])
+		case "dumpAccount":
+			return evmcc.dumpAccount(state, args[1])
+		case "importState":
+			return evmcc.importState(state, stub, cfg, args[1])
+		}
+	}
+
+	if len(args) == 3 && !readonly {
+		switch string(args[0]) {
+		case "mint":
+			return evmcc.mint(state, stub, cfg, args[1], args[2])
+		case "setACL":
+			return evmcc.setACL(state, stub, cfg, args[1], args[2])
+		case "getStorageAt":
+			return evmcc.getStorageAt(state, args[1], args[2])
+		case "removeOrphanedStorage":
+			return evmcc.removeOrphanedStorage(stub, cfg, args[1], args[2])
+		case "upgradeCode":
+			return evmcc.upgradeCode(state, stub, cfg, args[1], args[2])
+		case "listStorage":
+			return evmcc.listStorage(state, stub, args[1], args[2])
+		case "migrateStorage":
+			return evmcc.migrateStorage(stub, cfg, args[1], args[2])
+		case "compactStorage":
+			return evmcc.compactStorage(state, stub, cfg, args[1], args[2])
+		case "exportState":
+			return evmcc.exportState(state, args[1], args[2])
+		case "createInstance":
+			return evmcc.createInstance(stub, cfg, args[1], args[2])
+		case "removeOrphanedCollectionStorage":
+			return evmcc.removeOrphanedCollectionStorage(stub, cfg, args[1], args[2])
+		case "migrateCollectionStorage":
+			return evmcc.migrateCollectionStorage(stub, cfg, args[1], args[2])
+		}
+	}
+
+	if len(args) == 4 && !readonly {
+		switch string(args[0]) {
+		case "scanCollectionStorage":
+			return evmcc.scanCollectionStorage(stub, cfg, args[1], args[2], args[3])
+		}
+	}
+
+	// A function given the wrong arguments is not a contract call
+	if envelope.IsFunction(string(args[0])) {
+		if readonly {
+			return errorResponse(evmerrors.CodeInvalidRequest, "%s cannot be run by a query", args[0])
+		}
+		return errorResponse(evmerrors.CodeDecoding, "%s does not take %d args", args[0], len(args)-1)
+	}
+
+	env, err := envelope.FromArgs(args)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("failed to get callee address: %s", err.Error()))
+		return errorResponse(evmerrors.CodeDecoding, "%s", err.Error())
+	}
+
+	if len(env.Flags) != 0 {
+		return errorResponse(evmerrors.CodeDecoding, "unknown flags %q", env.Flags)
+	}
+
+	c, err := envelope.ParseData(env.To)
+	if err != nil {
+		return errorResponse(evmerrors.CodeInvalidAddress, "failed to decode callee address from %s: %s", env.To, err.Error())
+	}
+
+	calleeAddr := crypto.ZeroAddress
+	if len(c) != 0 {
+		calleeAddr, err = crypto.AddressFromBytes(c)
+		if err != nil {
+			return errorResponse(evmerrors.CodeInvalidAddress, "failed to get callee address: %s", err.Error())
+		}
+	}
+
+	if t != nil && calleeAddr == crypto.ZeroAddress {
+		return errorResponse(evmerrors.CodeInvalidRequest, "contracts cannot be deployed by a trace")
+	}
+
+	if readonly && calleeAddr == crypto.ZeroAddress {
+		return errorResponse(evmerrors.CodeInvalidRequest, "contracts cannot be deployed by a query")
+	}
+
+	if env.Collection != "" && calleeAddr != crypto.ZeroAddress {
+		return errorResponse(evmerrors.CodeInvalidRequest, "a collection can only be given when deploying a contract")
+	}
+
+	if env.Salt != "" && calleeAddr != crypto.ZeroAddress {
+		return errorResponse(evmerrors.CodeInvalidRequest, "a salt can only be given when deploying a contract")
+	}
+
+	var acl *statemanager.ACL
+	if len(env.ACL) != 0 {
+		if calleeAddr != crypto.ZeroAddress {
+			return errorResponse(evmerrors.CodeInvalidRequest, "an acl can only be given when deploying a contract")
+		}
+
+		if err = checkAdmin(stub, cfg); err != nil {
+			return shim.Error(err.Error())
+		}
+
+		if acl, err = parseACL(env.ACL); err != nil {
+			return shim.Error(err.Error())
+		}
 	}
 
 	// get caller account from creator public key
-	callerAddr, err := getCallerAddress(stub)
+	callerAddr, err := getCallerAddress(stub, cfg)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("failed to get caller address: %s", err.Error()))
 	}
 
-	if state.Exists(callerAddr) == false {
+	if !readonly && state.Exists(callerAddr) == false {
 		state.CreateAccount(callerAddr)
 	}
 
 	callerAcct := acm.Account{Address: callerAddr}
 
-	// get input bytes from args[1]
-	input, err := hex.DecodeString(string(args[1]))
+	input, err := envelope.ParseData(env.Data)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("failed to decode input bytes: %s", err.Error()))
+		return errorResponse(evmerrors.CodeDecoding, "failed to decode input bytes: %s", err.Error())
+	}
+
+	value, err := envelope.ParseQuantity(env.Value)
+	if err != nil {
+		return errorResponse(evmerrors.CodeDecoding, "failed to decode value: %s", err.Error())
+	}
+
+	if value != 0 && !cfg.Balances {
+		return errorResponse(evmerrors.CodeInvalidRequest, "value transfers are not enabled on this instance")
+	}
+
+	if value != 0 && readonly {
+		return errorResponse(evmerrors.CodeInvalidRequest, "value cannot be sent by a query")
+	}
+
+	if env.Nonce != "" {
+		nonce, err := envelope.ParseQuantity(env.Nonce)
+		if err != nil {
+			return errorResponse(evmerrors.CodeDecoding, "failed to decode nonce: %s", err.Error())
+		}
+
+		// The sequence of an account only counts the contracts it deployed
+		if sequence := state.GetSequence(callerAddr); nonce != sequence {
+			return errorResponse(evmerrors.CodeInvalidRequest, "nonce %d does not match the sequence %d of %s", nonce, sequence, callerAddr)
+		}
 	}
 
-	//var gas uint64 = 100000
-	var gas uint64 = 10000000000
+	gasLimit := cfg.GasLimit
+	if env.Gas != "" {
+		requested, err := envelope.ParseQuantity(env.Gas)
+		if err != nil {
+			return errorResponse(evmerrors.CodeDecoding, "failed to decode gas limit: %s", err.Error())
+		}
 
-	vm := evm.NewVM(newParams(), callerAddr, nil, evmLogger)
+		if requested < gasLimit {
+			gasLimit = requested
+		}
+	}
+	gas := gasLimit
+
+	params, err := newParams(stub, cfg)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to get block context: %s", err.Error()))
+	}
+
+	vm := evm.NewVM(params, callerAddr, nil, evmLogger)
+	if t != nil {
+		vm = evm.NewVM(params, callerAddr, nil, t.logger(), evm.DebugOpcodes)
+	}
 
 	evmgr := evm_event.NewEventManager(stub)
 
+	// The ACLs of the contracts called by other contracts are checked by the
+	// state, the one of the callee of the transaction is checked below.
+	var vmState statemanager.StateManager = newACLState(state, stub)
+
 	if calleeAddr == crypto.ZeroAddress {
 		logger.Debugf("Deploy contract")
 
 		contractAddr := crypto.NewContractAddress(callerAddr, state.GetSequence(callerAddr))
+		if env.Salt != "" {
+			salt, err := envelope.ParseData(env.Salt)
+			if err != nil || len(salt) > binary.Word256Length {
+				return errorResponse(evmerrors.CodeDecoding, "invalid salt %s", env.Salt)
+			}
+
+			contractAddr = saltedContractAddress(callerAddr, binary.LeftPadWord256(salt), input)
+		}
 
 		state.IncSequence(callerAddr)
 
-		state.CreateAccount(contractAddr)
+		state.CreateContractAccount(contractAddr)
 
 		if state.Error() != nil {
 			return shim.Error(fmt.Sprintf("failed to create account: %s", state.Error()))
 		}
 
+		// The metadata is set before running the constructor, so that the
+		// storage it writes is kept in the collection of the contract.
+		// Instances storing accounts in JSON also record the deployer, so
+		// that contracts can be queried by deployer.
+		recordDeployer := cfg.AccountEncoding == statemanager.AccountEncodingJSON
+		if env.Collection != "" || acl != nil || recordDeployer {
+			metadata := &statemanager.ContractMetadata{Collection: env.Collection, ACL: acl}
+			if recordDeployer {
+				metadata.Deployer = hex.EncodeToString(callerAddr.Bytes())
+			}
+			if err = state.SetMetadata(contractAddr, metadata); err != nil {
+				return shim.Error(fmt.Sprintf("failed to set contract metadata: %s", err.Error()))
+			}
+		}
+
 		contractAcct, err := state.GetAccount(contractAddr)
 
 		if err != nil {
 			return shim.Error(fmt.Sprintf("failed to get account: %s", err.Error()))
 		}
 
-		rtCode, err := vm.Call(state, evmgr,
+		// The vm transfers the value to the callee
+		rtCode, err := vm.Call(vmState, evmgr,
 			callerAcct.Address,
 			contractAcct.Address,
 			input,
 			input,
-			0,
+			value,
 			&gas)
 
+		// A call denied by an ACL fails the state rather than the vm
+		if err == nil {
+			err = state.Error()
+		}
+
 		if err != nil {
-			return shim.Error(fmt.Sprintf("failed to deploy code: %s", err.Error()))
+			return executionError("failed to deploy code", rtCode, err)
 		}
 
 		if rtCode == nil {
@@ -133,118 +406,363 @@ func (evmcc *EvmChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 		}
 
 		// return encoded hex bytes for human-readability
-		return shim.Success([]byte(hex.EncodeToString(contractAddr.Bytes())))
+		return success([]byte(hex.EncodeToString(contractAddr.Bytes())), gasLimit-gas)
 	} else {
 		logger.Debugf("Invoke contract at %x", calleeAddr.Bytes())
 
+		metadata, err := state.GetMetadata(calleeAddr)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("failed to get contract metadata: %s", err.Error()))
+		}
+
+		if metadata != nil {
+			if err = checkACL(stub, metadata.ACL); err != nil {
+				return errorResponse(evmerrors.CodePermissionDenied, "access denied to contract %s: %s", calleeAddr, err.Error())
+			}
+		}
+
+		// Value can be sent to any address, the account receiving it is
+		// created if needed
+		if value != 0 && !state.Exists(calleeAddr) {
+			state.CreateAccount(calleeAddr)
+		}
+
 		calleeCode := state.GetCode(calleeAddr)
 
-		if calleeCode == nil {
-			return shim.Error(fmt.Sprintf("failed to retrieve contract code: %s", err.Error()))
+		if calleeCode == nil && value == 0 {
+			if err = state.Error(); err != nil {
+				return shim.Error(fmt.Sprintf("failed to retrieve contract code: %s", err.Error()))
+			}
+			return errorResponse(evmerrors.CodeNotFound, "no contract at %s", calleeAddr)
+		}
+
+		if t != nil {
+			vmState = t.wrap(vmState)
 		}
 
-		output, err := vm.Call(state, evmgr, callerAcct.Address,
-			calleeAddr, calleeCode.Bytes(), input, 0, &gas)
+		output, err := vm.Call(vmState, evmgr, callerAcct.Address,
+			calleeAddr, calleeCode.Bytes(), input, value, &gas)
+
+		// A failed execution is part of its trace
+		if t != nil {
+			if err == nil {
+				err = state.Error()
+			}
+			return t.finish(output, gasLimit-gas, err)
+		}
 
 		if err != nil {
-			return shim.Error(fmt.Sprintf("failed to execute contract: %s", err.Error()))
+			return executionError("failed to execute contract", output, err)
+		}
+
+		if err = state.Error(); err != nil {
+			return executionError("failed to execute contract", output, err)
+		}
+
+		// Queries leave no trace in the ledger, events included
+		if readonly {
+			return success(output, gasLimit-gas)
 		}
 
 		// Passing the function hash of the method that has triggered the event
 		// The function hash is the first 8 bytes of the Input argument
-		er := evmgr.Flush(string(args[1][0:8]))
+		er := evmgr.Flush(functionHash(env.Data))
 		if er != nil {
 			return shim.Error(fmt.Sprintf("error in Flush: %s", er.Error()))
 		}
 
-		return shim.Success(output)
+		return success(output, gasLimit-gas)
+	}
+}
+
+// functionHash returns the hex encoded function selector of the input data.
+func functionHash(data string) string {
+	data = envelope.Strip0x(data)
+	if len(data) < 8 {
+		return data
+	}
+	return data[0:8]
+}
+
+// saltedContractAddress returns the address of a contract deployed with a
+// salt. It is computed the way the CREATE2 opcode does,
+// keccak256(0xff ++ deployer ++ salt ++ keccak256(deploy code))[12:], so it is
+// known before the deployment and the same on every channel.
+func saltedContractAddress(deployer crypto.Address, salt binary.Word256, code []byte) crypto.Address {
+	data := append([]byte{0xff}, deployer.Bytes()...)
+	data = append(data, salt.Bytes()...)
+	data = append(data, keccak.Sha3(code)...)
+
+	return crypto.AddressFromWord256(binary.LeftPadWord256(keccak.Sha3(data)))
+}
+
+// result is returned, JSON encoded, as the message of the response of
+// contract deployments and invocations, so that it is recorded in the
+// transaction along with the payload.
+type result struct {
+	GasUsed uint64 `json:"gasUsed"`
+}
+
+// accountResult is reported in the message of the account query.
+type accountResult struct {
+	AddressScheme string `json:"addressScheme"`
+}
+
+func success(payload []byte, gasUsed uint64) pb.Response {
+	msg, err := json.Marshal(result{GasUsed: gasUsed})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to marshal result: %s", err.Error()))
+	}
+
+	return pb.Response{
+		Status:  shim.OK,
+		Message: string(msg),
+		Payload: payload,
+	}
+}
+
+// setGasLimit updates the maximum amount of gas a transaction can use. It
+// is restricted to the admins of the instance.
+func (evmcc *EvmChaincode) setGasLimit(stub shim.ChaincodeStubInterface, cfg *Config, limit []byte) pb.Response {
+	if err := checkAdmin(stub, cfg); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	gasLimit, err := parseQuantity(limit)
+	if err != nil {
+		return errorResponse(evmerrors.CodeDecoding, "failed to parse gas limit: %s", err.Error())
 	}
+
+	cfg.GasLimit = gasLimit
+	if err = cfg.validate(); err != nil {
+		return errorResponse(evmerrors.CodeInvalidRequest, "invalid config: %s", err.Error())
+	}
+
+	if err = putConfig(stub, cfg); err != nil {
+		return shim.Error(fmt.Sprintf("failed to store config: %s", err.Error()))
+	}
+
+	return shim.Success(nil)
+}
+
+// advanceBlock increments the block number counted in the ledger, and
+// returns it. It is restricted to the admins of instances counting block
+// numbers, which advance blocks at their own pace, so that the transactions of
+// a block read the counter without writing it.
+func (evmcc *EvmChaincode) advanceBlock(stub shim.ChaincodeStubInterface) pb.Response {
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err = checkAdmin(stub, cfg); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if cfg.Block.Number != BlockNumberCounter {
+		return errorResponse(evmerrors.CodeInvalidRequest, "block numbers are not counted on this instance")
+	}
+
+	number, err := nextBlockNumber(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(strconv.FormatUint(number, 10)))
+}
+
+// mint adds amount to the balance of an account, creating the account if
+// needed. It is restricted to the admins of instances with balances.
+func (evmcc *EvmChaincode) mint(state statemanager.StateManager, stub shim.ChaincodeStubInterface, cfg *Config, address, amount []byte) pb.Response {
+	if err := checkAdmin(stub, cfg); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if !cfg.Balances {
+		return errorResponse(evmerrors.CodeInvalidRequest, "balances are not enabled on this instance")
+	}
+
+	addr, err := parseAddress(address)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	value, err := parseQuantity(amount)
+	if err != nil {
+		return errorResponse(evmerrors.CodeDecoding, "failed to parse amount: %s", err.Error())
+	}
+
+	if !state.Exists(addr) {
+		state.CreateAccount(addr)
+	}
+
+	state.AddToBalance(addr, value)
+	if err = state.Error(); err != nil {
+		return shim.Error(fmt.Sprintf("failed to mint: %s", err.Error()))
+	}
+
+	return shim.Success(nil)
+}
+
+// getBalance returns the balance of an account in decimal.
+func (evmcc *EvmChaincode) getBalance(state statemanager.StateManager, address []byte) pb.Response {
+	addr, err := parseAddress(address)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	balance := state.GetBalance(addr)
+	if err = state.Error(); err != nil {
+		return shim.Error(fmt.Sprintf("failed to get balance: %s", err.Error()))
+	}
+
+	return shim.Success([]byte(strconv.FormatUint(balance, 10)))
 }
 
 func (evmcc *EvmChaincode) getCode(state statemanager.StateManager, stub shim.ChaincodeStubInterface, address []byte) pb.Response {
 	c, err := hex.DecodeString(string(address))
 	if err != nil {
-		return shim.Error(fmt.Sprintf("failed to decode callee address from %s: %s", string(address), err.Error()))
+		return errorResponse(evmerrors.CodeInvalidAddress, "failed to decode callee address from %s: %s", string(address), err.Error())
 	}
 
 	calleeAddr, err := crypto.AddressFromBytes(c)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("failed to get callee address: %s", err.Error()))
+		return errorResponse(evmerrors.CodeInvalidAddress, "failed to get callee address: %s", err.Error())
 	}
 
 	code := state.GetCode(calleeAddr)
 
-	if state.Error() != nil {
+	if err = state.Error(); err != nil {
 		return shim.Error(fmt.Sprintf("failed to get contract account: %s", err.Error()))
 	}
 
 	return shim.Success([]byte(hex.EncodeToString(code)))
 }
 
-func (evmcc *EvmChaincode) account(state statemanager.StateManager, stub shim.ChaincodeStubInterface) pb.Response {
-	creatorBytes, err := stub.GetCreator()
+// genesis returns the active configuration of the instance and the accounts
+// it was bootstrapped with, as a JSON document.
+func (evmcc *EvmChaincode) genesis(stub shim.ChaincodeStubInterface) pb.Response {
+	g, err := getGenesis(stub)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("failed to get creator: %s", err.Error()))
+		return shim.Error(err.Error())
 	}
 
-	si := &msp.SerializedIdentity{}
-	if err = proto.Unmarshal(creatorBytes, si); err != nil {
-		return shim.Error(fmt.Sprintf("failed to unmarshal serialized identity: %s", err.Error()))
+	doc, err := json.Marshal(g)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to marshal genesis: %s", err.Error()))
 	}
 
-	callerAddr, err := identityToAddr(si.IdBytes)
+	return shim.Success(doc)
+}
+
+// account returns the address of the creator of the transaction. The address
+// scheme of the instance is reported in the message of the response.
+func (evmcc *EvmChaincode) account(stub shim.ChaincodeStubInterface) pb.Response {
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	callerAddr, err := getCallerAddress(stub, cfg)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	msg, err := json.Marshal(accountResult{AddressScheme: cfg.AddressScheme})
 	if err != nil {
-		return shim.Error(fmt.Sprintf("fail to convert identity to address: %s", err.Error()))
+		return shim.Error(fmt.Sprintf("failed to marshal result: %s", err.Error()))
 	}
 
-	return shim.Success([]byte(callerAddr.String()))
+	return pb.Response{
+		Status:  shim.OK,
+		Message: string(msg),
+		Payload: []byte(callerAddr.String()),
+	}
 }
 
-func newParams() evm.Params {
+// newParams derives the block context of the vm from the proposal, so that
+// every endorser executes the transaction with the same values. Counted block
+// numbers are only read, so that transactions do not conflict on the counter.
+//
+// The timestamp of the proposal is chosen by the client submitting it, so
+// with the default timestamp source the submitter can skew block.timestamp
+// and block.number, within whatever tolerance the peers enforce. Contracts
+// that cannot trust the submitter with them should run on an instance
+// counting block numbers.
+//
+// The EVM of burrow v0.23 does not support the BLOCKHASH opcode, which always
+// returns zero, so no block hash is derived.
+func newParams(stub shim.ChaincodeStubInterface, cfg *Config) (evm.Params, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return evm.Params{}, fmt.Errorf("failed to get transaction timestamp: %s", err)
+	}
+	blockTime := ts.GetSeconds()
+
+	var blockHeight uint64
+	switch cfg.Block.Number {
+	case BlockNumberCounter:
+		if blockHeight, err = currentBlockNumber(stub); err != nil {
+			return evm.Params{}, err
+		}
+	default:
+		if blockTime > cfg.Block.Epoch {
+			blockHeight = uint64((blockTime - cfg.Block.Epoch) / cfg.Block.Interval)
+		}
+	}
+
 	return evm.Params{
-		BlockHeight: 0,
-		BlockHash:   binary.Zero256,
-		BlockTime:   0,
+		BlockHeight: blockHeight,
+		BlockTime:   blockTime,
 		GasLimit:    0,
-	}
+	}, nil
 }
 
-func getCallerAddress(stub shim.ChaincodeStubInterface) (crypto.Address, error) {
+// checkAdmin fails unless the creator of the transaction belongs to one of the
+// admin MSPs of the instance.
+func checkAdmin(stub shim.ChaincodeStubInterface, cfg *Config) error {
 	creatorBytes, err := stub.GetCreator()
 	if err != nil {
-		return crypto.ZeroAddress, fmt.Errorf("failed to get creator: %s", err)
+		return fmt.Errorf("failed to get creator: %s", err)
 	}
 
 	si := &msp.SerializedIdentity{}
 	if err = proto.Unmarshal(creatorBytes, si); err != nil {
-		return crypto.ZeroAddress, fmt.Errorf("failed to unmarshal serialized identity: %s", err)
+		return fmt.Errorf("failed to unmarshal serialized identity: %s", err)
 	}
 
-	callerAddr, err := identityToAddr(si.IdBytes)
-	if err != nil {
-		return crypto.ZeroAddress, fmt.Errorf("fail to convert identity to address: %s", err.Error())
+	for _, mspID := range cfg.Admins {
+		if si.Mspid == mspID {
+			return nil
+		}
 	}
 
-	return callerAddr, nil
+	return evmerrors.New(evmerrors.CodePermissionDenied, "%s is not an admin of this instance", si.Mspid)
 }
 
-func identityToAddr(id []byte) (crypto.Address, error) {
-	bl, _ := pem.Decode(id)
-	if bl == nil {
-		return crypto.ZeroAddress, fmt.Errorf("no pem data found")
-	}
-
-	cert, err := x509.ParseCertificate(bl.Bytes)
+// parseAddress decodes a hex encoded address, with an optional 0x prefix.
+func parseAddress(address []byte) (crypto.Address, error) {
+	a, err := envelope.ParseData(string(address))
 	if err != nil {
-		return crypto.ZeroAddress, fmt.Errorf("failed to parse certificate: %s", err)
+		return crypto.ZeroAddress, evmerrors.New(evmerrors.CodeInvalidAddress, "failed to decode address from %s: %s", string(address), err)
 	}
 
-	pubkeyBytes, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	addr, err := crypto.AddressFromBytes(a)
 	if err != nil {
-		return crypto.ZeroAddress, fmt.Errorf("unable to marshal public key: %s", err)
+		return crypto.ZeroAddress, evmerrors.New(evmerrors.CodeInvalidAddress, "failed to get address: %s", err)
 	}
 
-	return crypto.AddressFromWord256(sha3.Sum256(pubkeyBytes)), nil
+	return addr, nil
+}
+
+// parseQuantity decodes a quantity given to an admin function, hex encoded
+// like every other EVM quantity. The 0x prefix is required, so that decimal
+// quantities are rejected rather than misread.
+func parseQuantity(quantity []byte) (uint64, error) {
+	if !strings.HasPrefix(string(quantity), "0x") {
+		return 0, fmt.Errorf("%s is not a 0x prefixed hex quantity", string(quantity))
+	}
+	return envelope.ParseQuantity(string(quantity))
 }
 
 func main() {
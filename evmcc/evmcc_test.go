@@ -7,6 +7,8 @@ SPDX-License-Identifier: Apache-2.0
 package main_test
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
@@ -19,12 +21,20 @@ import (
 	"github.com/hyperledger/burrow/crypto"
 
 	"github.com/gogo/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/hyperledger/burrow/binary"
+	keccak "github.com/hyperledger/burrow/execution/evm/sha3"
 	"github.com/hyperledger/burrow/execution/exec"
+	"github.com/hyperledger/fabric-chaincode-evm/envelope"
 	evm "github.com/hyperledger/fabric-chaincode-evm/evmcc"
+	"github.com/hyperledger/fabric-chaincode-evm/evmerrors"
 	evmcc_mocks "github.com/hyperledger/fabric-chaincode-evm/mocks/evmcc"
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/common"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
 	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
 	"golang.org/x/crypto/sha3"
 
 	. "github.com/onsi/ginkgo"
@@ -73,6 +83,165 @@ var _ = Describe("evmcc", func() {
 			Expect(res.Status).To(Equal(int32(shim.OK)))
 			Expect(res.Payload).To(Equal([]byte(nil)))
 		})
+
+		It("stores the default config", func() {
+			res := evmcc.Init(stub)
+			Expect(res.Status).To(Equal(int32(shim.OK)))
+
+			Expect(stub.PutStateCallCount()).To(Equal(1))
+			key, value := stub.PutStateArgsForCall(0)
+			Expect(key).To(Equal("evmcc.config"))
+			Expect(value).To(MatchJSON(`{"gasLimit":10000000000,"block":{"number":"timestamp","interval":1},"addressScheme":"sha3","accountEncoding":"proto"}`))
+		})
+
+		It("leaves the stored config as is", func() {
+			fakeLedger["evmcc.config"] = []byte(`{"gasLimit":100000}`)
+
+			res := evmcc.Init(stub)
+			Expect(res.Status).To(Equal(int32(shim.OK)))
+			Expect(stub.PutStateCallCount()).To(Equal(0))
+		})
+
+		Context("when a config is given", func() {
+			BeforeEach(func() {
+				stub.GetArgsReturns([][]byte{[]byte("init"), []byte(`{"block":{"number":"counter"}}`)})
+			})
+
+			It("stores the config of the instance", func() {
+				res := evmcc.Init(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				Expect(stub.PutStateCallCount()).To(Equal(1))
+				key, value := stub.PutStateArgsForCall(0)
+				Expect(key).To(Equal("evmcc.config"))
+				Expect(value).To(MatchJSON(`{"gasLimit":10000000000,"block":{"number":"counter","interval":1},"addressScheme":"sha3","accountEncoding":"proto"}`))
+			})
+		})
+
+		Context("when the config is invalid", func() {
+			BeforeEach(func() {
+				stub.GetArgsReturns([][]byte{[]byte(`{"block":{"number":"wallclock"}}`)})
+			})
+
+			It("returns an error", func() {
+				res := evmcc.Init(stub)
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+				Expect(res.Message).To(ContainSubstring("unknown block number source"))
+				Expect(stub.PutStateCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the address scheme is unknown", func() {
+			BeforeEach(func() {
+				stub.GetArgsReturns([][]byte{[]byte(`{"addressScheme":"rot13"}`)})
+			})
+
+			It("returns an error", func() {
+				res := evmcc.Init(stub)
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+				Expect(res.Message).To(ContainSubstring("unknown address scheme"))
+			})
+		})
+
+		Context("when the instance is upgraded", func() {
+			BeforeEach(func() {
+				stub.GetArgsReturns([][]byte{[]byte(`{"addressScheme":"keccak","accountEncoding":"json"}`)})
+				res := evmcc.Init(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+			})
+
+			It("can change the rest of the config", func() {
+				stub.GetArgsReturns([][]byte{[]byte(`{"gasLimit":100000,"addressScheme":"keccak","accountEncoding":"json"}`)})
+				res := evmcc.Init(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(fakeLedger["evmcc.config"]).To(MatchJSON(`{"gasLimit":100000,"block":{"number":"timestamp","interval":1},"addressScheme":"keccak","accountEncoding":"json"}`))
+			})
+
+			It("cannot change the address scheme", func() {
+				stub.GetArgsReturns([][]byte{[]byte(`{"addressScheme":"sha3","accountEncoding":"json"}`)})
+				res := evmcc.Init(stub)
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+				Expect(res.Message).To(ContainSubstring(`address scheme of the instance cannot be changed from "keccak" to "sha3"`))
+			})
+
+			It("cannot change the account encoding", func() {
+				stub.GetArgsReturns([][]byte{[]byte(`{"addressScheme":"keccak"}`)})
+				res := evmcc.Init(stub)
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+				Expect(res.Message).To(ContainSubstring(`account encoding of the instance cannot be changed from "json" to "proto"`))
+			})
+		})
+
+		Context("when the account encoding is unknown", func() {
+			BeforeEach(func() {
+				stub.GetArgsReturns([][]byte{[]byte(`{"accountEncoding":"xml"}`)})
+			})
+
+			It("returns an error", func() {
+				res := evmcc.Init(stub)
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+				Expect(res.Message).To(ContainSubstring("unknown account encoding"))
+			})
+		})
+
+		Context("when the genesis has accounts", func() {
+			const (
+				registry = "000000000000000000000000000000000000fab0"
+				genesis  = `{"gasLimit":100000,"accounts":[{"address":"0x` + registry + `","balance":10,"code":"6001"}]}`
+			)
+
+			BeforeEach(func() {
+				stub.GetArgsReturns([][]byte{[]byte("init"), []byte(genesis)})
+			})
+
+			It("creates the accounts of the genesis", func() {
+				res := evmcc.Init(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				stub.GetArgsReturns([][]byte{[]byte("getCode"), []byte(registry)})
+				res = evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(string(res.Payload)).To(Equal("6001"))
+			})
+
+			It("can be read back with the genesis query", func() {
+				res := evmcc.Init(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				stub.GetArgsReturns([][]byte{[]byte("genesis")})
+				res = evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(res.Payload).To(MatchJSON(`{
+					"gasLimit":100000,
+					"block":{"number":"timestamp","interval":1},
+					"addressScheme":"sha3",
+					"accountEncoding":"proto",
+					"accounts":[{"address":"0x` + registry + `","balance":10,"code":"6001"}]
+				}`))
+			})
+
+			It("cannot create the accounts twice", func() {
+				res := evmcc.Init(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				res = evmcc.Init(stub)
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+				Expect(res.Message).To(ContainSubstring("genesis accounts have already been applied"))
+			})
+
+			Context("when an account is invalid", func() {
+				BeforeEach(func() {
+					stub.GetArgsReturns([][]byte{[]byte(`{"accounts":[{"address":"0x` + registry + `","code":"zz"}]}`)})
+				})
+
+				It("returns an error without writing any state", func() {
+					res := evmcc.Init(stub)
+					Expect(res.Status).To(Equal(int32(shim.ERROR)))
+					Expect(res.Message).To(ContainSubstring("failed to apply genesis"))
+					Expect(stub.PutStateCallCount()).To(Equal(0))
+				})
+			})
+		})
 	})
 
 	Describe("Invoke", func() {
@@ -121,9 +290,10 @@ AiEA0GxTPOXVHo0gJpMbHc9B73TL5ZfDhujoDyjb8DToWPQ=
 			res := evmcc.Invoke(stub)
 			Expect(res.Status).To(Equal(int32(shim.OK)))
 
-			// First PutState Call is to store the current sequence number
-			Expect(stub.PutStateCallCount()).To(Equal(4))
-			key, value := stub.PutStateArgsForCall(3)
+			// The caller and the contract are written along with the index of
+			// their accounts, the code of the contract last
+			Expect(stub.PutStateCallCount()).To(Equal(6))
+			key, value := stub.PutStateArgsForCall(5)
 
 			account := acm.Account{}
 
@@ -146,7 +316,7 @@ AiEA0GxTPOXVHo0gJpMbHc9B73TL5ZfDhujoDyjb8DToWPQ=
 				res := evmcc.Invoke(stub)
 
 				Expect(res.Status).To(Equal(int32(shim.OK)))
-				Expect(stub.PutStateCallCount()).To(Equal(4))
+				Expect(stub.PutStateCallCount()).To(Equal(6))
 
 				var err error
 				contractAddress, err = crypto.AddressFromHexString(string(res.Payload))
@@ -169,123 +339,1161 @@ AiEA0GxTPOXVHo0gJpMbHc9B73TL5ZfDhujoDyjb8DToWPQ=
 				Expect(hex.EncodeToString(res.Payload)).To(Equal("000000000000000000000000000000000000000000000000000000000000002a"))
 			})
 
-			Context("when getCode is invoked", func() {
+			Context("when the contract is invoked with an envelope", func() {
+				var env *envelope.Envelope
+
 				BeforeEach(func() {
-					stub.GetArgsReturns([][]byte{[]byte("getCode"), []byte(contractAddress.String())})
+					env = envelope.New()
+					env.To = "0x" + contractAddress.String()
+					env.Data = "0x" + SET + "000000000000000000000000000000000000000000000000000000000000002a"
 				})
-				It("will return the runtime bytecode of the contract", func() {
+
+				It("runs the method of the contract", func() {
+					args, err := env.Args()
+					Expect(err).ToNot(HaveOccurred())
+					stub.GetArgsReturns(args)
 					res := evmcc.Invoke(stub)
 					Expect(res.Status).To(Equal(int32(shim.OK)))
-					Expect(string(res.Payload)).To(Equal(runtimeCode))
+
+					stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(GET)})
+					res = evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(hex.EncodeToString(res.Payload)).To(Equal("000000000000000000000000000000000000000000000000000000000000002a"))
+				})
+
+				Context("when the envelope has unknown flags", func() {
+					BeforeEach(func() {
+						env.Flags = []string{"not-a-flag"}
+					})
+
+					It("returns an error", func() {
+						args, err := env.Args()
+						Expect(err).ToNot(HaveOccurred())
+						stub.GetArgsReturns(args)
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.ERROR)))
+						Expect(res.Message).To(ContainSubstring("unknown flags"))
+					})
+				})
+
+				Context("when the nonce does not match the sequence of the caller", func() {
+					BeforeEach(func() {
+						env.Nonce = "0x5"
+					})
+
+					It("returns an error", func() {
+						args, err := env.Args()
+						Expect(err).ToNot(HaveOccurred())
+						stub.GetArgsReturns(args)
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.ERROR)))
+						Expect(res.Message).To(ContainSubstring("nonce 5 does not match the sequence 1"))
+					})
+				})
+
+				Context("when the envelope version is not supported", func() {
+					It("returns an error", func() {
+						stub.GetArgsReturns([][]byte{[]byte(envelope.Function), []byte(`{"version":0}`)})
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.ERROR)))
+						Expect(res.Message).To(ContainSubstring("unsupported envelope version 0"))
+					})
 				})
 			})
 
-			Context("when another contract is deployed", func() {
+			It("reports the gas used in the response message", func() {
+				stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(SET + "000000000000000000000000000000000000000000000000000000000000002a")})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				var result struct {
+					GasUsed uint64 `json:"gasUsed"`
+				}
+				Expect(json.Unmarshal([]byte(res.Message), &result)).To(Succeed())
+				Expect(result.GasUsed).To(BeNumerically(">", 0))
+			})
+
+			Context("when the transaction does not provide enough gas", func() {
 				BeforeEach(func() {
-					stub.GetArgsReturns([][]byte{[]byte(crypto.ZeroAddress.String()), deployCode})
+					stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(SET + "000000000000000000000000000000000000000000000000000000000000002a"), []byte("1")})
 				})
-				It("creates a new contract and returns another contract address", func() {
+
+				It("stops the execution and returns an error", func() {
 					res := evmcc.Invoke(stub)
-					Expect(res.Status).To(Equal(int32(shim.OK)))
-					Expect(string(res.Payload)).ToNot(Equal(string(contractAddress.Bytes())))
+					Expect(res.Status).To(Equal(int32(shim.ERROR)))
+					Expect(res.Message).To(ContainSubstring("failed to execute contract"))
 				})
 			})
 
-		})
+			Context("when the gas limit of the instance is updated", func() {
+				var setGasLimit [][]byte
 
-		Context("when more than 2 args are given", func() {
-			BeforeEach(func() {
-				stub.GetArgsReturns([][]byte{[]byte("arg1"), []byte("arg2"), []byte("arg3")})
-			})
+				BeforeEach(func() {
+					setGasLimit = [][]byte{[]byte("setGasLimit"), []byte("0x1")}
+				})
 
-			It("returns an error", func() {
-				res := evmcc.Invoke(stub)
-				Expect(res.Status).To(Equal(int32(shim.ERROR)))
-				Expect(res.Message).To(ContainSubstring("expects 2 args"))
+				Context("when the creator is an admin", func() {
+					BeforeEach(func() {
+						stub.GetArgsReturns([][]byte{[]byte(`{"admins":["TestOrg"]}`)})
+						res := evmcc.Init(stub)
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+					})
+
+					It("limits the gas of the following transactions", func() {
+						stub.GetArgsReturns(setGasLimit)
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+
+						stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(SET + "000000000000000000000000000000000000000000000000000000000000002a")})
+						res = evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.ERROR)))
+						Expect(res.Message).To(ContainSubstring("failed to execute contract"))
+					})
+
+					It("rejects a limit that is not hex encoded", func() {
+						stub.GetArgsReturns([][]byte{[]byte("setGasLimit"), []byte("1000")})
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.ERROR)))
+
+						evmErr, ok := evmerrors.Parse(res.Message)
+						Expect(ok).To(BeTrue())
+						Expect(evmErr.Code).To(Equal(evmerrors.CodeDecoding))
+					})
+				})
+
+				Context("when the creator is not an admin", func() {
+					It("returns an error", func() {
+						stub.GetArgsReturns(setGasLimit)
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.ERROR)))
+						Expect(res.Message).To(ContainSubstring("TestOrg is not an admin of this instance"))
+					})
+				})
 			})
-		})
 
-		Context("when less than 2 args are given", func() {
-			Context("when only one argument is given", func() {
-				Context("when the argument is account", func() {
-					var callerAddress crypto.Address
+			Context("when orphaned storage is removed", func() {
+				var orphanSlot string
+
+				BeforeEach(func() {
+					stub.GetArgsReturns([][]byte{[]byte(`{"admins":["TestOrg"]}`)})
+					res := evmcc.Init(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					orphanSlot = strings.Repeat("AB", 20) + strings.Repeat("00", 32)
+					fakeLedger[orphanSlot] = []byte{42}
+
+					iter := &evmcc_mocks.MockStateQueryIterator{}
+					iter.HasNextReturnsOnCall(0, true)
+					iter.HasNextReturnsOnCall(1, true)
+					iter.NextReturnsOnCall(0, &queryresult.KV{Key: contractAddress.String()}, nil)
+					iter.NextReturnsOnCall(1, &queryresult.KV{Key: orphanSlot}, nil)
+					stub.GetStateByRangeReturns(iter, nil)
+				})
+
+				It("deletes the slots of accounts that do not exist", func() {
+					stub.GetArgsReturns([][]byte{[]byte("removeOrphanedStorage"), []byte(""), []byte("10")})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(res.Payload).To(MatchJSON(`{"removed":1,"next":"` + orphanSlot + `"}`))
+
+					Expect(fakeLedger).ToNot(HaveKey(orphanSlot))
+					Expect(fakeLedger).To(HaveKey(contractAddress.String()))
+				})
+
+				Context("when the storage is kept in a collection", func() {
+					var fakePrivate map[string][]byte
 
 					BeforeEach(func() {
-						stub.GetArgsReturns([][]byte{[]byte("account")})
-						stub.GetCreatorReturns(creator, nil)
-						si := &msp.SerializedIdentity{IdBytes: []byte(user0Cert)}
+						fakePrivate = map[string][]byte{orphanSlot: {42}}
+						stub.GetPrivateDataStub = func(collection, key string) ([]byte, error) {
+							Expect(collection).To(Equal("collection1"))
+							return fakePrivate[key], nil
+						}
+						stub.DelPrivateDataStub = func(collection, key string) error {
+							Expect(collection).To(Equal("collection1"))
+							delete(fakePrivate, key)
+							return nil
+						}
+
+						iter := &evmcc_mocks.MockStateQueryIterator{}
+						iter.HasNextReturnsOnCall(0, true)
+						iter.NextReturnsOnCall(0, &queryresult.KV{Key: orphanSlot}, nil)
+						stub.GetPrivateDataByRangeReturns(iter, nil)
+					})
 
-						var err error
-						callerAddress, err = identityToAddr(si.IdBytes)
-						Expect(err).ToNot(HaveOccurred())
+					It("lists the slots of accounts that do not exist", func() {
+						stub.GetArgsReturns([][]byte{[]byte("scanCollectionStorage"), []byte("collection1"), []byte(""), []byte("10")})
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+						Expect(res.Payload).To(MatchJSON(`{"orphaned":["` + orphanSlot + `"],"slots":[],"next":"` + orphanSlot + `"}`))
+
+						Expect(fakePrivate).To(HaveKey(orphanSlot))
 					})
 
-					It("will return the caller address of the contract", func() {
+					It("deletes the slots given without querying the collection", func() {
+						stub.GetArgsReturns([][]byte{[]byte("removeOrphanedCollectionStorage"), []byte("collection1"), []byte(orphanSlot)})
 						res := evmcc.Invoke(stub)
 						Expect(res.Status).To(Equal(int32(shim.OK)))
-						Expect(string(res.Payload)).To(Equal(callerAddress.String()))
+						Expect(res.Payload).To(MatchJSON(`{"removed":1}`))
+
+						Expect(fakePrivate).To(BeEmpty())
+						Expect(fakeLedger).To(HaveKey(orphanSlot))
+						Expect(stub.GetPrivateDataByRangeCallCount()).To(Equal(0))
 					})
 				})
+			})
 
-				Context("when the argument is not account", func() {
+			Context("when storage is migrated to composite keys", func() {
+				var legacySlot string
+
+				BeforeEach(func() {
+					stub.GetArgsReturns([][]byte{[]byte(`{"admins":["TestOrg"]}`)})
+					res := evmcc.Init(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					legacySlot = contractAddress.String() + strings.Repeat("00", 31) + "01"
+					fakeLedger[legacySlot] = []byte{42}
+
+					iter := &evmcc_mocks.MockStateQueryIterator{}
+					iter.HasNextReturnsOnCall(0, true)
+					iter.HasNextReturnsOnCall(1, true)
+					iter.NextReturnsOnCall(0, &queryresult.KV{Key: contractAddress.String()}, nil)
+					iter.NextReturnsOnCall(1, &queryresult.KV{Key: legacySlot, Value: []byte{42}}, nil)
+					stub.GetStateByRangeReturns(iter, nil)
+				})
+
+				It("moves the slots of existing contracts to composite keys", func() {
+					stub.GetArgsReturns([][]byte{[]byte("migrateStorage"), []byte(""), []byte("10")})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(res.Payload).To(MatchJSON(`{"migrated":1,"next":"` + legacySlot + `"}`))
+
+					compKey, err := shim.CreateCompositeKey(statemanager.StorageObjectType, []string{contractAddress.String(), strings.Repeat("00", 31) + "01"})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(fakeLedger).ToNot(HaveKey(legacySlot))
+					Expect(fakeLedger[compKey]).To(Equal([]byte{42}))
+				})
+
+				Context("when the creator is not an admin", func() {
 					BeforeEach(func() {
-						stub.GetArgsReturns([][]byte{[]byte("arg1")})
+						stub.GetCreatorReturns(marshalCreator("OtherOrg", []byte(user0Cert)), nil)
 					})
 
 					It("returns an error", func() {
+						stub.GetArgsReturns([][]byte{[]byte("migrateStorage"), []byte(""), []byte("10")})
 						res := evmcc.Invoke(stub)
 						Expect(res.Status).To(Equal(int32(shim.ERROR)))
-						Expect(res.Message).To(ContainSubstring("expects 2 args"))
+						Expect(res.Message).To(ContainSubstring("OtherOrg is not an admin of this instance"))
+						Expect(fakeLedger).To(HaveKey(legacySlot))
 					})
 				})
 			})
 
-			Context("when no argument is given", func() {
+			Context("when a storage slot is set to zero", func() {
+				var compKey string
+
 				BeforeEach(func() {
-					stub.GetArgsReturns([][]byte{[]byte("")})
+					stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(SET + "000000000000000000000000000000000000000000000000000000000000002a")})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					var err error
+					compKey, err = shim.CreateCompositeKey(statemanager.StorageObjectType, []string{contractAddress.String(), strings.Repeat("00", 32)})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(fakeLedger).To(HaveKey(compKey))
 				})
 
-				It("returns an error", func() {
+				It("deletes the slot from the ledger", func() {
+					stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(SET + "0000000000000000000000000000000000000000000000000000000000000000")})
 					res := evmcc.Invoke(stub)
-					Expect(res.Status).To(Equal(int32(shim.ERROR)))
-					Expect(res.Message).To(ContainSubstring("expects 2 args"))
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					Expect(fakeLedger).ToNot(HaveKey(compKey))
 				})
 			})
-		})
 
-		Describe("Voting DApp", func() {
-			var (
-				/* Voting App from https://solidity.readthedocs.io/en/develop/solidity-by-example.html#voting
-				pragma solidity ^0.4.16;
-				/// @title Voting with delegation.
-				contract Ballot {
-				    // This declares a new complex type which will
-				    // be used for variables later.
-				    // It will represent a single voter.
-				    struct Voter {
-				        uint weight; // weight is accumulated by delegation
-				        bool voted;  // if true, that person already voted
-				        address delegate; // person delegated to
-				        uint vote;   // index of the voted proposal
-				    }
-				    // This is a type for a single proposal.
-				    struct Proposal {
-				        bytes32 name;   // short name (up to 32 bytes)
-				        uint voteCount; // number of accumulated votes
-				    }
-				    address public chairperson;
-				    // This declares a state variable that
-				    // stores a `Voter` struct for each possible address.
-				    mapping(address => Voter) public voters;
-				    // A dynamically-sized array of `Proposal` structs.
-				    Proposal[] public proposals;
-				    /// Create a new ballot to choose one of `proposalNames`.
-				    function Ballot(bytes32[] proposalNames) public {
-				        chairperson = msg.sender;
-				        voters[chairperson].weight = 1;
-				        // For each of the provided proposal names,
-				        // create a new proposal object and add it
-				        // to the end of the array.
+			Context("when zero storage slots are compacted", func() {
+				var zeroKey string
+
+				BeforeEach(func() {
+					stub.GetArgsReturns([][]byte{[]byte(`{"admins":["TestOrg"]}`)})
+					res := evmcc.Init(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					var err error
+					zeroKey, err = shim.CreateCompositeKey(statemanager.StorageObjectType, []string{contractAddress.String(), strings.Repeat("00", 31) + "01"})
+					Expect(err).ToNot(HaveOccurred())
+					fakeLedger[zeroKey] = make([]byte, 32)
+				})
+
+				It("deletes the slots given that are set to zero", func() {
+					slots := strings.Repeat("00", 31) + "01," + strings.Repeat("00", 31) + "02"
+					stub.GetArgsReturns([][]byte{[]byte("compactStorage"), []byte(contractAddress.String()), []byte(slots)})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(res.Payload).To(MatchJSON(`{"removed":1}`))
+
+					Expect(fakeLedger).ToNot(HaveKey(zeroKey))
+				})
+			})
+
+			Context("when the storage of the contract is listed", func() {
+				BeforeEach(func() {
+					stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(SET + "000000000000000000000000000000000000000000000000000000000000002a")})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					compKey, err := shim.CreateCompositeKey(statemanager.StorageObjectType, []string{contractAddress.String(), strings.Repeat("00", 32)})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(fakeLedger).To(HaveKey(compKey))
+
+					stub.GetStateByRangeWithPaginationReturns(&evmcc_mocks.MockStateQueryIterator{}, &pb.QueryResponseMetadata{}, nil)
+
+					iter := &evmcc_mocks.MockStateQueryIterator{}
+					iter.HasNextReturnsOnCall(0, true)
+					iter.NextReturnsOnCall(0, &queryresult.KV{Key: compKey, Value: fakeLedger[compKey]}, nil)
+					stub.GetStateByPartialCompositeKeyWithPaginationReturns(iter, &pb.QueryResponseMetadata{FetchedRecordsCount: 1}, nil)
+				})
+
+				It("returns the slots of the contract", func() {
+					stub.GetArgsReturns([][]byte{[]byte("listStorage"), []byte(contractAddress.String()), []byte("")})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(res.Payload).To(MatchJSON(`{"storage":[{"slot":"` + strings.Repeat("00", 32) + `","value":"` + strings.Repeat("00", 31) + `2a"}]}`))
+
+					_, attributes, pageSize, _ := stub.GetStateByPartialCompositeKeyWithPaginationArgsForCall(0)
+					Expect(attributes).To(Equal([]string{contractAddress.String()}))
+					Expect(pageSize).To(Equal(int32(100)))
+				})
+			})
+
+			Context("when the state is exported", func() {
+				BeforeEach(func() {
+					stub.GetArgsReturns([][]byte{[]byte(`{"admins":["TestOrg"]}`)})
+					res := evmcc.Init(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					indexKey, err := shim.CreateCompositeKey(statemanager.AccountIndexObjectType, []string{contractAddress.String()})
+					Expect(err).ToNot(HaveOccurred())
+
+					// The contract is the only account indexed, its storage is empty
+					stub.GetStateByPartialCompositeKeyWithPaginationStub = func(objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+						iter := &evmcc_mocks.MockStateQueryIterator{}
+						if objectType == statemanager.AccountIndexObjectType {
+							iter.HasNextReturnsOnCall(0, true)
+							iter.NextReturnsOnCall(0, &queryresult.KV{Key: indexKey, Value: []byte{1}}, nil)
+						}
+						return iter, &pb.QueryResponseMetadata{}, nil
+					}
+					stub.GetStateByRangeWithPaginationReturns(&evmcc_mocks.MockStateQueryIterator{}, &pb.QueryResponseMetadata{}, nil)
+				})
+
+				It("returns a snapshot that can be imported", func() {
+					stub.GetArgsReturns([][]byte{[]byte("exportState"), []byte(""), []byte("10")})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					var snapshot struct {
+						Accounts []struct {
+							Address string `json:"address"`
+							Code    string `json:"code"`
+						} `json:"accounts"`
+						Checksum string `json:"checksum"`
+					}
+					Expect(json.Unmarshal(res.Payload, &snapshot)).To(Succeed())
+					Expect(snapshot.Accounts).To(HaveLen(1))
+					Expect(snapshot.Accounts[0].Address).To(Equal(hex.EncodeToString(contractAddress.Bytes())))
+					Expect(snapshot.Accounts[0].Code).To(Equal(runtimeCode))
+					Expect(snapshot.Checksum).ToNot(BeEmpty())
+
+					delete(fakeLedger, contractAddress.String())
+
+					stub.GetArgsReturns([][]byte{[]byte("importState"), res.Payload})
+					res = evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(res.Payload).To(MatchJSON(`{"imported":1}`))
+					Expect(fakeLedger).To(HaveKey(contractAddress.String()))
+				})
+
+				Context("when the creator is not an admin", func() {
+					BeforeEach(func() {
+						stub.GetCreatorReturns(marshalCreator("OtherOrg", []byte(user0Cert)), nil)
+					})
+
+					It("does not import the snapshot", func() {
+						stub.GetArgsReturns([][]byte{[]byte("importState"), []byte(`{"accounts":[],"checksum":""}`)})
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.ERROR)))
+						Expect(res.Message).To(ContainSubstring("OtherOrg is not an admin of this instance"))
+					})
+				})
+			})
+
+			Context("when the code of the contract is upgraded", func() {
+				var (
+					// Returns the value of the storage slot 0
+					upgradedCode = "60005460005260206000f3"
+					upgradeCode  [][]byte
+				)
+
+				BeforeEach(func() {
+					stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(SET + "000000000000000000000000000000000000000000000000000000000000002a")})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					upgradeCode = [][]byte{[]byte("upgradeCode"), []byte(contractAddress.String()), []byte(upgradedCode)}
+				})
+
+				Context("when the creator is an admin", func() {
+					BeforeEach(func() {
+						stub.GetArgsReturns([][]byte{[]byte(`{"admins":["TestOrg"]}`)})
+						res := evmcc.Init(stub)
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+					})
+
+					It("replaces the code and keeps the storage", func() {
+						stub.GetArgsReturns(upgradeCode)
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+
+						stub.GetArgsReturns([][]byte{[]byte("getCode"), []byte(contractAddress.String())})
+						res = evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+						Expect(string(res.Payload)).To(Equal(upgradedCode))
+
+						stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte("00000000")})
+						res = evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+						Expect(hex.EncodeToString(res.Payload)).To(Equal("000000000000000000000000000000000000000000000000000000000000002a"))
+					})
+
+					It("records the previous code hash and emits an event", func() {
+						runtime, err := hex.DecodeString(runtimeCode)
+						Expect(err).ToNot(HaveOccurred())
+						previousHash := hex.EncodeToString(keccak.Sha3(runtime))
+
+						stub.GetArgsReturns(upgradeCode)
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+
+						Expect(stub.SetEventCallCount()).To(Equal(1))
+						name, payload := stub.SetEventArgsForCall(0)
+						Expect(name).To(Equal("evmcc.upgrade"))
+
+						var upgrade map[string]string
+						Expect(json.Unmarshal(payload, &upgrade)).To(Succeed())
+						Expect(upgrade["address"]).To(Equal(hex.EncodeToString(contractAddress.Bytes())))
+						Expect(upgrade["previousCodeHash"]).To(Equal(previousHash))
+
+						stub.GetArgsReturns([][]byte{[]byte("dumpAccount"), []byte(contractAddress.String())})
+						res = evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+						Expect(string(res.Payload)).To(ContainSubstring(`"previousCodeHashes":["` + previousHash + `"]`))
+					})
+
+					It("does not upgrade accounts without code", func() {
+						stub.GetArgsReturns([][]byte{[]byte("upgradeCode"), []byte(crypto.ZeroAddress.String()), []byte(upgradedCode)})
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.ERROR)))
+						Expect(res.Message).To(ContainSubstring("no contract at"))
+					})
+				})
+
+				Context("when the creator is not an admin", func() {
+					It("returns an error", func() {
+						stub.GetArgsReturns(upgradeCode)
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.ERROR)))
+						Expect(res.Message).To(ContainSubstring("TestOrg is not an admin of this instance"))
+					})
+				})
+			})
+
+			Context("when getCode is invoked", func() {
+				BeforeEach(func() {
+					stub.GetArgsReturns([][]byte{[]byte("getCode"), []byte(contractAddress.String())})
+				})
+				It("will return the runtime bytecode of the contract", func() {
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(string(res.Payload)).To(Equal(runtimeCode))
+				})
+			})
+
+			Context("when the state of the contract is inspected", func() {
+				BeforeEach(func() {
+					stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(SET + "000000000000000000000000000000000000000000000000000000000000002a")})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+				})
+
+				It("returns the value of a storage slot", func() {
+					stub.GetArgsReturns([][]byte{[]byte("getStorageAt"), []byte(contractAddress.String()), []byte("0x0")})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(string(res.Payload)).To(Equal("000000000000000000000000000000000000000000000000000000000000002a"))
+				})
+
+				It("returns the nonce of the deployer", func() {
+					stub.GetArgsReturns([][]byte{[]byte("account")})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					stub.GetArgsReturns([][]byte{[]byte("getNonce"), res.Payload})
+					res = evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(string(res.Payload)).To(Equal("1"))
+				})
+
+				It("returns the permissions of the contract", func() {
+					stub.GetArgsReturns([][]byte{[]byte("getPermissions"), []byte(contractAddress.String())})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(res.Payload).To(MatchJSON(`{"permissions":{"call":true,"send":true},"roles":[]}`))
+				})
+
+				It("dumps the account of the contract", func() {
+					stub.GetArgsReturns([][]byte{[]byte("dumpAccount"), []byte(contractAddress.String())})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(res.Payload).To(MatchJSON(fmt.Sprintf(
+						`{"address":"%s","code":"%s","permissions":{"call":true,"send":true},"sequence":0}`,
+						hex.EncodeToString(contractAddress.Bytes()), runtimeCode)))
+				})
+
+				It("fails to dump an account that does not exist", func() {
+					stub.GetArgsReturns([][]byte{[]byte("dumpAccount"), []byte(crypto.ZeroAddress.String())})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.ERROR)))
+					Expect(res.Message).To(ContainSubstring("no account at"))
+				})
+			})
+
+			Context("when the contract is queried", func() {
+				It("runs the read-only methods of the contract", func() {
+					stub.GetArgsReturns([][]byte{[]byte("query"), []byte(contractAddress.String()), []byte(GET)})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(hex.EncodeToString(res.Payload)).To(Equal("0000000000000000000000000000000000000000000000000000000000000000"))
+				})
+
+				It("fails when the method modifies the state", func() {
+					putCount := stub.PutStateCallCount()
+
+					stub.GetArgsReturns([][]byte{[]byte("query"), []byte(contractAddress.String()), []byte(SET + "000000000000000000000000000000000000000000000000000000000000002a")})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.ERROR)))
+					Expect(res.Message).To(ContainSubstring("read-only"))
+					Expect(stub.PutStateCallCount()).To(Equal(putCount))
+				})
+
+				It("does not deploy contracts", func() {
+					stub.GetArgsReturns([][]byte{[]byte("query"), []byte(crypto.ZeroAddress.String()), deployCode})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.ERROR)))
+					Expect(res.Message).To(ContainSubstring("contracts cannot be deployed by a query"))
+				})
+
+				It("does not run the functions of the chaincode", func() {
+					stub.GetArgsReturns([][]byte{[]byte("query"), []byte("setGasLimit"), []byte("0x3e8")})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.ERROR)))
+				})
+			})
+
+			Context("when the contract call is traced", func() {
+				type trace struct {
+					Failed      bool   `json:"failed"`
+					Error       string `json:"error"`
+					ReturnValue string `json:"returnValue"`
+					Steps       []struct {
+						PC        uint64 `json:"pc"`
+						Op        string `json:"op"`
+						Depth     int    `json:"depth"`
+						StackSize int    `json:"stackSize"`
+						StackTop  string `json:"stackTop"`
+						Storage   []struct {
+							Op  string `json:"op"`
+							Key string `json:"key"`
+						} `json:"storage"`
+					} `json:"structLogs"`
+				}
+
+				It("returns the opcodes run by the call and the storage they accessed", func() {
+					stub.GetArgsReturns([][]byte{[]byte("trace"), []byte(contractAddress.String()), []byte(GET)})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					t := trace{}
+					Expect(json.Unmarshal(res.Payload, &t)).To(Succeed())
+					Expect(t.Failed).To(BeFalse())
+					Expect(t.ReturnValue).To(Equal("0000000000000000000000000000000000000000000000000000000000000000"))
+					Expect(t.Steps).ToNot(BeEmpty())
+					Expect(t.Steps[0].Depth).To(Equal(1))
+
+					var reads int
+					for _, step := range t.Steps {
+						if step.Op == "SLOAD" {
+							Expect(step.Storage).To(HaveLen(1))
+							Expect(step.Storage[0].Op).To(Equal("read"))
+							reads++
+						}
+					}
+					Expect(reads).ToNot(BeZero())
+				})
+
+				It("reports the stack of the opcodes from the messages of the vm", func() {
+					stub.GetArgsReturns([][]byte{[]byte("trace"), []byte(contractAddress.String()), []byte(GET)})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					t := trace{}
+					Expect(json.Unmarshal(res.Payload, &t)).To(Succeed())
+					Expect(len(t.Steps)).To(BeNumerically(">", 5))
+
+					// 6060 6040 52 6004 36: PUSH1 0x60, PUSH1 0x40, MSTORE,
+					// PUSH1 0x04, CALLDATASIZE
+					expected := []struct {
+						pc        uint64
+						op        string
+						stackSize int
+						stackTop  string
+					}{
+						{0, "PUSH1", 0, ""},
+						{2, "PUSH1", 1, "0x60"},
+						{4, "MSTORE", 2, "0x40"},
+						{5, "PUSH1", 0, ""},
+						{7, "CALLDATASIZE", 1, "0x04"},
+					}
+					for i, e := range expected {
+						Expect(t.Steps[i].PC).To(Equal(e.pc))
+						Expect(t.Steps[i].Op).To(Equal(e.op))
+						Expect(t.Steps[i].StackSize).To(Equal(e.stackSize))
+						Expect(t.Steps[i].StackTop).To(Equal(e.stackTop))
+					}
+				})
+
+				It("runs the writes of the call without modifying the state", func() {
+					putCount := stub.PutStateCallCount()
+					eventCount := stub.SetEventCallCount()
+
+					stub.GetArgsReturns([][]byte{[]byte("trace"), []byte(contractAddress.String()), []byte(SET + "000000000000000000000000000000000000000000000000000000000000002a")})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					t := trace{}
+					Expect(json.Unmarshal(res.Payload, &t)).To(Succeed())
+					Expect(t.Failed).To(BeFalse())
+
+					var writes int
+					for _, step := range t.Steps {
+						if step.Op == "SSTORE" {
+							Expect(step.Storage).To(HaveLen(1))
+							Expect(step.Storage[0].Op).To(Equal("write"))
+							writes++
+						}
+					}
+					Expect(writes).To(Equal(1))
+
+					Expect(stub.PutStateCallCount()).To(Equal(putCount))
+					Expect(stub.SetEventCallCount()).To(Equal(eventCount))
+				})
+
+				It("reports the failure of the call in the trace", func() {
+					stub.GetArgsReturns([][]byte{[]byte("trace"), []byte(contractAddress.String()), []byte("deadbeef")})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					t := trace{}
+					Expect(json.Unmarshal(res.Payload, &t)).To(Succeed())
+					Expect(t.Failed).To(BeTrue())
+					Expect(t.Error).ToNot(BeEmpty())
+				})
+
+				It("does not trace deployments", func() {
+					stub.GetArgsReturns([][]byte{[]byte("trace"), []byte(crypto.ZeroAddress.String()), deployCode})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.ERROR)))
+					Expect(res.Message).To(ContainSubstring("contracts cannot be deployed by a trace"))
+				})
+			})
+
+			Context("when the transaction fails", func() {
+				expectCode := func(res pb.Response, code evmerrors.Code) {
+					Expect(res.Status).To(Equal(int32(shim.ERROR)))
+					evmErr, ok := evmerrors.Parse(res.Message)
+					Expect(ok).To(BeTrue())
+					Expect(evmErr.Code).To(Equal(code))
+				}
+
+				It("reports a call to an address without code as not found", func() {
+					missing, err := crypto.AddressFromBytes([]byte("0000000000000missing"))
+					Expect(err).ToNot(HaveOccurred())
+
+					stub.GetArgsReturns([][]byte{[]byte(missing.String()), []byte(GET)})
+					expectCode(evmcc.Invoke(stub), evmerrors.CodeNotFound)
+				})
+
+				It("reports a malformed address as invalid", func() {
+					stub.GetArgsReturns([][]byte{[]byte("getBalance"), []byte("not-an-address")})
+					expectCode(evmcc.Invoke(stub), evmerrors.CodeInvalidAddress)
+				})
+
+				It("reports malformed input as a decoding failure", func() {
+					stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte("not-hex")})
+					expectCode(evmcc.Invoke(stub), evmerrors.CodeDecoding)
+				})
+
+				It("reports an execution running out of gas", func() {
+					stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(SET + "000000000000000000000000000000000000000000000000000000000000002a"), []byte("1")})
+					expectCode(evmcc.Invoke(stub), evmerrors.CodeOutOfGas)
+				})
+
+				It("reports a restricted function as denied", func() {
+					stub.GetArgsReturns([][]byte{[]byte("setGasLimit"), []byte("0x3e8")})
+					expectCode(evmcc.Invoke(stub), evmerrors.CodePermissionDenied)
+				})
+
+				It("reports a deployment option given to a call as an invalid request", func() {
+					env := envelope.New()
+					env.To = contractAddress.String()
+					env.Data = GET
+					env.Salt = "0x01"
+					args, err := env.Args()
+					Expect(err).ToNot(HaveOccurred())
+
+					stub.GetArgsReturns(args)
+					expectCode(evmcc.Invoke(stub), evmerrors.CodeInvalidRequest)
+				})
+
+				It("reports value sent to an instance without balances as an invalid request", func() {
+					env := envelope.New()
+					env.To = contractAddress.String()
+					env.Data = GET
+					env.Value = "0x1"
+					args, err := env.Args()
+					Expect(err).ToNot(HaveOccurred())
+
+					stub.GetArgsReturns(args)
+					expectCode(evmcc.Invoke(stub), evmerrors.CodeInvalidRequest)
+				})
+
+				It("reports a function given the wrong args as a decoding failure", func() {
+					stub.GetArgsReturns([][]byte{[]byte("getBalance"), []byte(contractAddress.String()), []byte("extra")})
+					res := evmcc.Invoke(stub)
+					expectCode(res, evmerrors.CodeDecoding)
+					Expect(res.Message).To(ContainSubstring("getBalance does not take 2 args"))
+				})
+
+				It("reports a function run by a query as an invalid request", func() {
+					stub.GetArgsReturns([][]byte{[]byte("query"), []byte("getBalance"), []byte(contractAddress.String())})
+					expectCode(evmcc.Invoke(stub), evmerrors.CodeInvalidRequest)
+				})
+			})
+
+			Context("when another contract is deployed", func() {
+				BeforeEach(func() {
+					stub.GetArgsReturns([][]byte{[]byte(crypto.ZeroAddress.String()), deployCode})
+				})
+				It("creates a new contract and returns another contract address", func() {
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(string(res.Payload)).ToNot(Equal(string(contractAddress.Bytes())))
+				})
+			})
+
+		})
+
+		Context("when more than 4 args are given", func() {
+			BeforeEach(func() {
+				stub.GetArgsReturns([][]byte{[]byte("arg1"), []byte("arg2"), []byte("arg3"), []byte("arg4"), []byte("arg5")})
+			})
+
+			It("returns an error", func() {
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+				Expect(res.Message).To(ContainSubstring("expects 2 to 4 args"))
+			})
+		})
+
+		Context("when less than 2 args are given", func() {
+			Context("when only one argument is given", func() {
+				Context("when the argument is account", func() {
+					var callerAddress crypto.Address
+
+					BeforeEach(func() {
+						stub.GetArgsReturns([][]byte{[]byte("account")})
+						stub.GetCreatorReturns(creator, nil)
+						si := &msp.SerializedIdentity{IdBytes: []byte(user0Cert)}
+
+						var err error
+						callerAddress, err = identityToAddr(si.IdBytes)
+						Expect(err).ToNot(HaveOccurred())
+					})
+
+					It("will return the caller address of the contract", func() {
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+						Expect(string(res.Payload)).To(Equal(callerAddress.String()))
+						Expect(res.Message).To(MatchJSON(`{"addressScheme":"sha3"}`))
+					})
+
+					Context("when the instance derives addresses the Ethereum way", func() {
+						BeforeEach(func() {
+							stub.GetArgsReturns([][]byte{[]byte(`{"addressScheme":"keccak"}`)})
+							res := evmcc.Init(stub)
+							Expect(res.Status).To(Equal(int32(shim.OK)))
+							stub.GetArgsReturns([][]byte{[]byte("account")})
+						})
+
+						It("returns the keccak address of the public key", func() {
+							bl, _ := pem.Decode([]byte(user0Cert))
+							cert, err := x509.ParseCertificate(bl.Bytes)
+							Expect(err).ToNot(HaveOccurred())
+							pubkey := cert.PublicKey.(*ecdsa.PublicKey)
+							hash := keccak.Sha3(elliptic.Marshal(pubkey.Curve, pubkey.X, pubkey.Y)[1:])
+
+							res := evmcc.Invoke(stub)
+							Expect(res.Status).To(Equal(int32(shim.OK)))
+							Expect(strings.ToLower(string(res.Payload))).To(Equal(hex.EncodeToString(hash[12:])))
+							Expect(res.Message).To(MatchJSON(`{"addressScheme":"keccak"}`))
+						})
+					})
+
+					Context("when the instance derives addresses from the MSP and subject", func() {
+						BeforeEach(func() {
+							stub.GetArgsReturns([][]byte{[]byte(`{"addressScheme":"msp"}`)})
+							res := evmcc.Init(stub)
+							Expect(res.Status).To(Equal(int32(shim.OK)))
+							stub.GetArgsReturns([][]byte{[]byte("account")})
+						})
+
+						It("returns an address bound to the MSP", func() {
+							res := evmcc.Invoke(stub)
+							Expect(res.Status).To(Equal(int32(shim.OK)))
+							mspAddress := string(res.Payload)
+							Expect(mspAddress).ToNot(Equal(callerAddress.String()))
+
+							stub.GetCreatorReturns(marshalCreator("OtherOrg", []byte(user0Cert)), nil)
+							res = evmcc.Invoke(stub)
+							Expect(res.Status).To(Equal(int32(shim.OK)))
+							Expect(string(res.Payload)).ToNot(Equal(mspAddress))
+						})
+					})
+
+					Context("when the instance takes addresses from a certificate attribute", func() {
+						BeforeEach(func() {
+							stub.GetArgsReturns([][]byte{[]byte(`{"addressScheme":"attribute"}`)})
+							res := evmcc.Init(stub)
+							Expect(res.Status).To(Equal(int32(shim.OK)))
+							stub.GetArgsReturns([][]byte{[]byte("account")})
+						})
+
+						It("fails for certificates without the attribute", func() {
+							res := evmcc.Invoke(stub)
+							Expect(res.Status).To(Equal(int32(shim.ERROR)))
+							Expect(res.Message).To(ContainSubstring("certificate has no eth.address attribute"))
+						})
+					})
+				})
+
+				Context("when the argument is not account", func() {
+					BeforeEach(func() {
+						stub.GetArgsReturns([][]byte{[]byte("arg1")})
+					})
+
+					It("returns an error", func() {
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.ERROR)))
+						Expect(res.Message).To(ContainSubstring("expects 2 to 4 args"))
+					})
+				})
+			})
+
+			Context("when no argument is given", func() {
+				BeforeEach(func() {
+					stub.GetArgsReturns([][]byte{[]byte("")})
+				})
+
+				It("returns an error", func() {
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.ERROR)))
+					Expect(res.Message).To(ContainSubstring("expects 2 to 4 args"))
+				})
+			})
+		})
+
+		Context("when a function is run at a point in time", func() {
+			var (
+				addr      crypto.Address
+				txID      = strings.Repeat("1", 64)
+				otherTxID = strings.Repeat("2", 64)
+			)
+
+			// marshalBlock returns a block of the transactions given.
+			marshalBlock := func(number uint64, txIDs ...string) []byte {
+				block := &common.Block{Header: &common.BlockHeader{Number: number}, Data: &common.BlockData{}}
+				for _, id := range txIDs {
+					chdr, err := proto.Marshal(&common.ChannelHeader{TxId: id})
+					Expect(err).ToNot(HaveOccurred())
+					payload, err := proto.Marshal(&common.Payload{Header: &common.Header{ChannelHeader: chdr}})
+					Expect(err).ToNot(HaveOccurred())
+					env, err := proto.Marshal(&common.Envelope{Payload: payload})
+					Expect(err).ToNot(HaveOccurred())
+					block.Data.Data = append(block.Data.Data, env)
+				}
+
+				b, err := proto.Marshal(block)
+				Expect(err).ToNot(HaveOccurred())
+				return b
+			}
+
+			BeforeEach(func() {
+				var err error
+				addr, err = crypto.AddressFromBytes([]byte("0000000000000address"))
+				Expect(err).ToNot(HaveOccurred())
+
+				acc := acm.Account{Address: addr, Balance: 42, Permissions: statemanager.ContractPerms}
+				serialized, err := acc.Marshal()
+				Expect(err).ToNot(HaveOccurred())
+
+				stub.GetHistoryForKeyStub = func(key string) (shim.HistoryQueryIteratorInterface, error) {
+					iter := &evmcc_mocks.MockHistoryQueryIterator{}
+					if key == addr.String() {
+						iter.HasNextReturnsOnCall(0, true)
+						iter.NextReturns(&queryresult.KeyModification{TxId: txID, Value: serialized}, nil)
+					}
+					return iter, nil
+				}
+
+				// The transaction is the second of block 7
+				stub.GetChannelIDReturns("channel1")
+				stub.InvokeChaincodeStub = func(name string, args [][]byte, channel string) pb.Response {
+					Expect(name).To(Equal("qscc"))
+					Expect(args[:2]).To(Equal([][]byte{[]byte("GetBlockByTxID"), []byte("channel1")}))
+					return pb.Response{Status: shim.OK, Payload: marshalBlock(7, otherTxID, txID)}
+				}
+			})
+
+			It("reads the state as it was at a block", func() {
+				stub.GetArgsReturns([][]byte{[]byte("at"), []byte("7"), []byte("getBalance"), []byte(addr.String())})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(string(res.Payload)).To(Equal("42"))
+
+				stub.GetArgsReturns([][]byte{[]byte("at"), []byte("0x6"), []byte("getBalance"), []byte(addr.String())})
+				res = evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(string(res.Payload)).To(Equal("0"))
+			})
+
+			It("reads the state as it was at a transaction", func() {
+				stub.GetArgsReturns([][]byte{[]byte("at"), []byte(txID), []byte("getBalance"), []byte(addr.String())})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(string(res.Payload)).To(Equal("42"))
+
+				stub.GetArgsReturns([][]byte{[]byte("at"), []byte(otherTxID), []byte("getBalance"), []byte(addr.String())})
+				res = evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(string(res.Payload)).To(Equal("0"))
+			})
+
+			It("returns an error when the transaction cannot be found", func() {
+				stub.InvokeChaincodeReturns(pb.Response{Status: shim.ERROR, Message: "no such transaction"})
+				stub.InvokeChaincodeStub = nil
+
+				stub.GetArgsReturns([][]byte{[]byte("at"), []byte(otherTxID), []byte("getBalance"), []byte(addr.String())})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+
+				evmErr, ok := evmerrors.Parse(res.Message)
+				Expect(ok).To(BeTrue())
+				Expect(evmErr.Code).To(Equal(evmerrors.CodeNotFound))
+			})
+
+			It("returns an error when the point in time is invalid", func() {
+				stub.GetArgsReturns([][]byte{[]byte("at"), []byte("yesterday"), []byte("getBalance"), []byte(addr.String())})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+
+				evmErr, ok := evmerrors.Parse(res.Message)
+				Expect(ok).To(BeTrue())
+				Expect(evmErr.Code).To(Equal(evmerrors.CodeDecoding))
+			})
+
+			It("returns an error for functions that write to the state", func() {
+				stub.GetArgsReturns([][]byte{[]byte("at"), []byte("7"), []byte("mint"), []byte(addr.String()), []byte("1")})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+				Expect(res.Message).To(ContainSubstring("mint cannot be run at a point in time"))
+			})
+		})
+
+		Context("when instances are created", func() {
+			BeforeEach(func() {
+				stub.GetArgsReturns([][]byte{[]byte(`{"admins":["TestOrg"]}`)})
+				res := evmcc.Init(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				stub.GetStateByPartialCompositeKeyStub = func(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+					prefix, err := shim.CreateCompositeKey(objectType, attributes)
+					Expect(err).ToNot(HaveOccurred())
+
+					iter := &evmcc_mocks.MockStateQueryIterator{}
+					var kvs []*queryresult.KV
+					for key, value := range fakeLedger {
+						if strings.HasPrefix(key, prefix) {
+							kvs = append(kvs, &queryresult.KV{Key: key, Value: value})
+						}
+					}
+					iter.HasNextStub = func() bool {
+						return iter.NextCallCount() < len(kvs)
+					}
+					iter.NextStub = func() (*queryresult.KV, error) {
+						return kvs[iter.NextCallCount()-1], nil
+					}
+					return iter, nil
+				}
+
+				stub.GetTxIDReturns("1234")
+				stub.GetArgsReturns([][]byte{[]byte("createInstance"), []byte("network-a"), []byte(`{"admins":["TestOrg"],"balances":true}`)})
+				res = evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(string(res.Payload)).To(Equal("network-a"))
+			})
+
+			It("stores the configuration of the instance in its namespace", func() {
+				Expect(fakeLedger).To(HaveKey("~network-a/evmcc.config"))
+
+				stub.GetArgsReturns([][]byte{[]byte("instance"), []byte("network-a"), []byte("genesis")})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				Expect(string(res.Payload)).To(ContainSubstring(`"balances":true`))
+			})
+
+			It("lists the instances", func() {
+				stub.GetArgsReturns([][]byte{[]byte("listInstances")})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(res.Payload).To(MatchJSON(`[{"id":"network-a","txId":"1234"}]`))
+			})
+
+			It("isolates the accounts of the instance", func() {
+				addr := "0000000000000000000000000000000000000001"
+
+				stub.GetArgsReturns([][]byte{[]byte("instance"), []byte("network-a"), []byte("mint"), []byte(addr), []byte("0x5")})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(fakeLedger).To(HaveKey("~network-a/" + addr))
+				Expect(fakeLedger).ToNot(HaveKey(addr))
+
+				stub.GetArgsReturns([][]byte{[]byte("instance"), []byte("network-a"), []byte("getBalance"), []byte(addr)})
+				res = evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(string(res.Payload)).To(Equal("5"))
+
+				stub.GetArgsReturns([][]byte{[]byte("getBalance"), []byte(addr)})
+				res = evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(string(res.Payload)).To(Equal("0"))
+			})
+
+			It("deploys contracts in the instance", func() {
+				stub.GetArgsReturns([][]byte{[]byte("instance"), []byte("network-a"), []byte(crypto.ZeroAddress.String()), deployCode})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				contractAddress, err := crypto.AddressFromHexString(string(res.Payload))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fakeLedger).To(HaveKey("~network-a/" + contractAddress.String()))
+				Expect(fakeLedger).ToNot(HaveKey(contractAddress.String()))
+
+				stub.GetArgsReturns([][]byte{[]byte("instance"), []byte("network-a"), []byte("getCode"), []byte(contractAddress.String())})
+				res = evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(string(res.Payload)).To(Equal(runtimeCode))
+			})
+
+			It("returns an error when the instance already exists", func() {
+				stub.GetArgsReturns([][]byte{[]byte("createInstance"), []byte("network-a"), []byte(`{}`)})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+				Expect(res.Message).To(ContainSubstring("instance network-a already exists"))
+			})
+
+			It("returns an error when the instance id is invalid", func() {
+				stub.GetArgsReturns([][]byte{[]byte("createInstance"), []byte("Network/A"), []byte(`{}`)})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+				Expect(res.Message).To(ContainSubstring("invalid instance id"))
+			})
+
+			It("returns a not found error when the instance does not exist", func() {
+				stub.GetArgsReturns([][]byte{[]byte("instance"), []byte("network-b"), []byte("getBalance"), []byte(crypto.ZeroAddress.String())})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+
+				evmErr, ok := evmerrors.Parse(res.Message)
+				Expect(ok).To(BeTrue())
+				Expect(evmErr.Code).To(Equal(evmerrors.CodeNotFound))
+			})
+
+			It("does not create instances in an instance", func() {
+				stub.GetArgsReturns([][]byte{[]byte("instance"), []byte("network-a"), []byte("createInstance"), []byte("network-b"), []byte(`{}`)})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+				Expect(res.Message).To(ContainSubstring("createInstance cannot be run in an instance"))
+			})
+
+			Context("when the creator is not an admin", func() {
+				BeforeEach(func() {
+					stub.GetCreatorReturns(marshalCreator("OtherOrg", []byte(user0Cert)), nil)
+				})
+
+				It("returns an error", func() {
+					stub.GetArgsReturns([][]byte{[]byte("createInstance"), []byte("network-b"), []byte(`{"admins":["OtherOrg"]}`)})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.ERROR)))
+					Expect(res.Message).To(ContainSubstring("OtherOrg is not an admin of this instance"))
+				})
+			})
+		})
+
+		Describe("Voting DApp", func() {
+			var (
+				/* Voting App from https://solidity.readthedocs.io/en/develop/solidity-by-example.html#voting
+				pragma solidity ^0.4.16;
+				/// @title Voting with delegation.
+				contract Ballot {
+				    // This declares a new complex type which will
+				    // be used for variables later.
+				    // It will represent a single voter.
+				    struct Voter {
+				        uint weight; // weight is accumulated by delegation
+				        bool voted;  // if true, that person already voted
+				        address delegate; // person delegated to
+				        uint vote;   // index of the voted proposal
+				    }
+				    // This is a type for a single proposal.
+				    struct Proposal {
+				        bytes32 name;   // short name (up to 32 bytes)
+				        uint voteCount; // number of accumulated votes
+				    }
+				    address public chairperson;
+				    // This declares a state variable that
+				    // stores a `Voter` struct for each possible address.
+				    mapping(address => Voter) public voters;
+				    // A dynamically-sized array of `Proposal` structs.
+				    Proposal[] public proposals;
+				    /// Create a new ballot to choose one of `proposalNames`.
+				    function Ballot(bytes32[] proposalNames) public {
+				        chairperson = msg.sender;
+				        voters[chairperson].weight = 1;
+				        // For each of the provided proposal names,
+				        // create a new proposal object and add it
+				        // to the end of the array.
 				        for (uint i = 0; i < proposalNames.length; i++) {
 				            // `Proposal({...})` creates a temporary
 				            // Proposal object and `proposals.push(...)`
@@ -369,296 +1577,1089 @@ AiEA0GxTPOXVHo0gJpMbHc9B73TL5ZfDhujoDyjb8DToWPQ=
 				            }
 				        }
 				    }
-				    // Calls winningProposal() function to get the index
-				    // of the winner contained in the proposals array and then
-				    // returns the name of the winner
-				    function winnerName() public view
-				            returns (bytes32 winnerName)
-				    {
-				        winnerName = proposals[winningProposal()].name;
+				    // Calls winningProposal() function to get the index
+				    // of the winner contained in the proposals array and then
+				    // returns the name of the winner
+				    function winnerName() public view
+				            returns (bytes32 winnerName)
+				    {
+				        winnerName = proposals[winningProposal()].name;
+				    }
+				}
+				*/
+				contractByteCode = "6060604052341561000f57600080fd5b604051610b0b380380610b0b833981016040528080518201919050506000336000806101000a81548173ffffffffffffffffffffffffffffffffffffffff021916908373ffffffffffffffffffffffffffffffffffffffff16021790555060018060008060009054906101000a900473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060000181905550600090505b815181101561016757600280548060010182816100f7919061016e565b916000526020600020906002020160006040805190810160405280868681518110151561012057fe5b9060200190602002015160001916815260200160008152509091909150600082015181600001906000191690556020820151816001015550505080806001019150506100da565b50506101cf565b81548183558181151161019b5760020281600202836000526020600020918201910161019a91906101a0565b5b505050565b6101cc91905b808211156101c8576000808201600090556001820160009055506002016101a6565b5090565b90565b61092d806101de6000396000f30060606040526004361061008e576000357c0100000000000000000000000000000000000000000000000000000000900463ffffffff1680630121b93f14610093578063013cf08b146100b65780632e4176cf146100fc5780635c19a95c14610151578063609ff1bd1461018a5780639e7b8d61146101b3578063a3ec138d146101ec578063e2ba53f01461027e575b600080fd5b341561009e57600080fd5b6100b460048080359060200190919050506102af565b005b34156100c157600080fd5b6100d7600480803590602001909190505061036c565b6040518083600019166000191681526020018281526020019250505060405180910390f35b341561010757600080fd5b61010f61039f565b604051808273ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200191505060405180910390f35b341561015c57600080fd5b610188600480803573ffffffffffffffffffffffffffffffffffffffff169060200190919050506103c4565b005b341561019557600080fd5b61019d6106ae565b6040518082815260200191505060405180910390f35b34156101be57600080fd5b6101ea600480803573ffffffffffffffffffffffffffffffffffffffff16906020019091905050610729565b005b34156101f757600080fd5b610223600480803573ffffffffffffffffffffffffffffffffffffffff16906020019091905050610875565b60405180858152602001841515151581526020018373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200182815260200194505050505060405180910390f35b341561028957600080fd5b6102916108d2565b60405180826000191660001916815260200191505060405180910390f35b6000600160003373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002090508060010160009054906101000a900460ff1615151561031057600080fd5b60018160010160006101000a81548160ff021916908315150217905550818160020181905550806000015460028381548110151561034a57fe5b9060005260206000209060020201600101600082825401925050819055505050565b60028181548110151561037b57fe5b90600052602060002090600202016000915090508060000154908060010154905082565b6000809054906101000a900473ffffffffffffffffffffffffffffffffffffffff1681565b600080600160003373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002091508160010160009054906101000a900460ff1615151561042657600080fd5b3373ffffffffffffffffffffffffffffffffffffffff168373ffffffffffffffffffffffffffffffffffffffff161415151561046157600080fd5b5b600073ffffffffffffffffffffffffffffffffffffffff16600160008573ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060010160019054906101000a900473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff1614151561059f57600160008473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060010160019054906101000a900473ffffffffffffffffffffffffffffffffffffffff1692503373ffffffffffffffffffffffffffffffffffffffff168373ffffffffffffffffffffffffffffffffffffffff161415151561059a57600080fd5b610462565b60018260010160006101000a81548160ff021916908315150217905550828260010160016101000a81548173ffffffffffffffffffffffffffffffffffffffff021916908373ffffffffffffffffffffffffffffffffffffffff160217905550600160008473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002090508060010160009054906101000a900460ff16156106925781600001546002826002015481548110151561066f57fe5b9060005260206000209060020201600101600082825401925050819055506106a9565b816000015481600001600082825401925050819055505b505050565b6000806000809150600090505b60028054905081101561072457816002828154811015156106d857fe5b9060005260206000209060020201600101541115610717576002818154811015156106ff57fe5b90600052602060002090600202016001015491508092505b80806001019150506106bb565b505090565b6000809054906101000a900473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff163373ffffffffffffffffffffffffffffffffffffffff161480156107d25750600160008273ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060010160009054906101000a900460ff16155b801561082057506000600160008373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060000154145b151561082b57600080fd5b60018060008373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff1681526020019081526020016000206000018190555050565b60016020528060005260406000206000915090508060000154908060010160009054906101000a900460ff16908060010160019054906101000a900473ffffffffffffffffffffffffffffffffffffffff16908060020154905084565b600060026108de6106ae565b8154811015156108ea57fe5b9060005260206000209060020201600001549050905600a165627a7a723058209216e84efeb17007ba61a1573380cb306de0e38c64eb02e0f9362367121816080029"
+				runtimeByteCode  = "60606040526004361061008e576000357c0100000000000000000000000000000000000000000000000000000000900463ffffffff1680630121b93f14610093578063013cf08b146100b65780632e4176cf146100fc5780635c19a95c14610151578063609ff1bd1461018a5780639e7b8d61146101b3578063a3ec138d146101ec578063e2ba53f01461027e575b600080fd5b341561009e57600080fd5b6100b460048080359060200190919050506102af565b005b34156100c157600080fd5b6100d7600480803590602001909190505061036c565b6040518083600019166000191681526020018281526020019250505060405180910390f35b341561010757600080fd5b61010f61039f565b604051808273ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200191505060405180910390f35b341561015c57600080fd5b610188600480803573ffffffffffffffffffffffffffffffffffffffff169060200190919050506103c4565b005b341561019557600080fd5b61019d6106ae565b6040518082815260200191505060405180910390f35b34156101be57600080fd5b6101ea600480803573ffffffffffffffffffffffffffffffffffffffff16906020019091905050610729565b005b34156101f757600080fd5b610223600480803573ffffffffffffffffffffffffffffffffffffffff16906020019091905050610875565b60405180858152602001841515151581526020018373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200182815260200194505050505060405180910390f35b341561028957600080fd5b6102916108d2565b60405180826000191660001916815260200191505060405180910390f35b6000600160003373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002090508060010160009054906101000a900460ff1615151561031057600080fd5b60018160010160006101000a81548160ff021916908315150217905550818160020181905550806000015460028381548110151561034a57fe5b9060005260206000209060020201600101600082825401925050819055505050565b60028181548110151561037b57fe5b90600052602060002090600202016000915090508060000154908060010154905082565b6000809054906101000a900473ffffffffffffffffffffffffffffffffffffffff1681565b600080600160003373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002091508160010160009054906101000a900460ff1615151561042657600080fd5b3373ffffffffffffffffffffffffffffffffffffffff168373ffffffffffffffffffffffffffffffffffffffff161415151561046157600080fd5b5b600073ffffffffffffffffffffffffffffffffffffffff16600160008573ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060010160019054906101000a900473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff1614151561059f57600160008473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060010160019054906101000a900473ffffffffffffffffffffffffffffffffffffffff1692503373ffffffffffffffffffffffffffffffffffffffff168373ffffffffffffffffffffffffffffffffffffffff161415151561059a57600080fd5b610462565b60018260010160006101000a81548160ff021916908315150217905550828260010160016101000a81548173ffffffffffffffffffffffffffffffffffffffff021916908373ffffffffffffffffffffffffffffffffffffffff160217905550600160008473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002090508060010160009054906101000a900460ff16156106925781600001546002826002015481548110151561066f57fe5b9060005260206000209060020201600101600082825401925050819055506106a9565b816000015481600001600082825401925050819055505b505050565b6000806000809150600090505b60028054905081101561072457816002828154811015156106d857fe5b9060005260206000209060020201600101541115610717576002818154811015156106ff57fe5b90600052602060002090600202016001015491508092505b80806001019150506106bb565b505090565b6000809054906101000a900473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff163373ffffffffffffffffffffffffffffffffffffffff161480156107d25750600160008273ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060010160009054906101000a900460ff16155b801561082057506000600160008373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060000154145b151561082b57600080fd5b60018060008373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff1681526020019081526020016000206000018190555050565b60016020528060005260406000206000915090508060000154908060010160009054906101000a900460ff16908060010160019054906101000a900473ffffffffffffffffffffffffffffffffffffffff16908060020154905084565b600060026108de6106ae565b8154811015156108ea57fe5b9060005260206000209060020201600001549050905600a165627a7a723058209216e84efeb17007ba61a1573380cb306de0e38c64eb02e0f9362367121816080029"
+				// encoded bytes for ["a", "b"]
+				// It consists of four elements which take byte32 each:
+				// - 0x20 the location of data
+				// - 0x2  the length of array
+				// - 0x61 left-aligned 'a'
+				// - 0x62 left-aligned 'b'
+				constructorArgs = "0000000000000000000000000000000000000000000000000000000000000020000000000000000000000000000000000000000000000000000000000000000261000000000000000000000000000000000000000000000000000000000000006200000000000000000000000000000000000000000000000000000000000000"
+
+				// Method hash
+				giveRightToVote = "9e7b8d61"
+				proposals       = "013cf08b"
+				vote            = "0121b93f"
+				winnerName      = "e2ba53f0"
+				voters          = "a3ec138d"
+
+				user1Cert = `-----BEGIN CERTIFICATE-----
+MIICGTCCAcCgAwIBAgIRAOdmptMzz5y0A9GOgFLxRNcwCgYIKoZIzj0EAwIwczEL
+MAkGA1UEBhMCVVMxEzARBgNVBAgTCkNhbGlmb3JuaWExFjAUBgNVBAcTDVNhbiBG
+cmFuY2lzY28xGTAXBgNVBAoTEG9yZzEuZXhhbXBsZS5jb20xHDAaBgNVBAMTE2Nh
+Lm9yZzEuZXhhbXBsZS5jb20wHhcNMTgwMjEyMDY0MDMyWhcNMjgwMjEwMDY0MDMy
+WjBbMQswCQYDVQQGEwJVUzETMBEGA1UECBMKQ2FsaWZvcm5pYTEWMBQGA1UEBxMN
+U2FuIEZyYW5jaXNjbzEfMB0GA1UEAwwWVXNlcjFAb3JnMS5leGFtcGxlLmNvbTBZ
+MBMGByqGSM49AgEGCCqGSM49AwEHA0IABEwsU2N6Kqrtl73S7+7/nD/LTfDFVWO4
+q3MTtbckd6MH2zTUj9idLoaQ5VNGJVTRRPs+O6bxlvl0Mitu1rcXFoyjTTBLMA4G
+A1UdDwEB/wQEAwIHgDAMBgNVHRMBAf8EAjAAMCsGA1UdIwQkMCKAIKtXuAgSGNzS
+0Yz91W08FSieahwkOU7pWJvh86pkNuxSMAoGCCqGSM49BAMCA0cAMEQCIDOGUUvv
+SgCqSQONblgBtkKuKgN36VgX+jLhZbaqMNAtAiBXiAHbgYdu3UHBVJwdTYxuFTWJ
+Vc4foA7mruwjI8sEng==
+-----END CERTIFICATE-----`
+
+				user2Cert = `-----BEGIN CERTIFICATE-----
+MIICGDCCAb+gAwIBAgIQMhSPvpu4KGobIvRGEGnZojAKBggqhkjOPQQDAjBzMQsw
+CQYDVQQGEwJVUzETMBEGA1UECBMKQ2FsaWZvcm5pYTEWMBQGA1UEBxMNU2FuIEZy
+YW5jaXNjbzEZMBcGA1UEChMQb3JnMi5leGFtcGxlLmNvbTEcMBoGA1UEAxMTY2Eu
+b3JnMi5leGFtcGxlLmNvbTAeFw0xODAyMTIwNjQwMzJaFw0yODAyMTAwNjQwMzJa
+MFsxCzAJBgNVBAYTAlVTMRMwEQYDVQQIEwpDYWxpZm9ybmlhMRYwFAYDVQQHEw1T
+YW4gRnJhbmNpc2NvMR8wHQYDVQQDDBZVc2VyMUBvcmcyLmV4YW1wbGUuY29tMFkw
+EwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE6mVSTODH+CUZk7/rU+MtycF610ifj0pT
+gXGYgJXLLcWbGAC1/ADE8rgq+zihgStD9rnwk0XMitXvqYbIhR0EEqNNMEswDgYD
+VR0PAQH/BAQDAgeAMAwGA1UdEwEB/wQCMAAwKwYDVR0jBCQwIoAg1NNSEgEmJaVF
+hk5bEaOs6HQS2PFt/VMeXrBUwIGDSogwCgYIKoZIzj0EAwIDRwAwRAIgY6k7AARJ
+yJINhf9ub8QcQiMnrTgD4kmhjh5ey8E7fVACIA/i396+beIk0T0c8loygaCiftyG
+H8GZeN2ifTyJzzGo
+-----END CERTIFICATE-----`
+
+				user1 = marshalCreator("TestOrg", []byte(user1Cert))
+				user2 = marshalCreator("TestOrg", []byte(user2Cert))
+
+				deployCode      []byte
+				contractAddress crypto.Address
+			)
+
+			BeforeEach(func() {
+				deployCode = []byte(contractByteCode + constructorArgs)
+
+				// zero address, and deploy code is contract creation
+				stub.GetArgsReturns([][]byte{[]byte(crypto.ZeroAddress.String()), deployCode})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				// Last PutState Call is to store contract runtime bytecode
+				key, value := stub.PutStateArgsForCall(stub.PutStateCallCount() - 1)
+
+				account := acm.Account{}
+				account.Unmarshal(value)
+
+				Expect(strings.ToLower(key)).To(Equal(strings.ToLower(string(res.Payload))))
+				Expect(hex.EncodeToString(account.Code)).To(Equal(runtimeByteCode))
+
+				var err error
+				contractAddress, err = crypto.AddressFromHexString(string(res.Payload))
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("is able to properly initialize proposals through constructor", func() {
+				//invoke proposals(x) to see if constructor args are properly stored
+				stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(proposals + "0000000000000000000000000000000000000000000000000000000000000000")})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(hex.EncodeToString(res.Payload)).To(Equal("61000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000"))
+
+				stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(proposals + "0000000000000000000000000000000000000000000000000000000000000001")})
+				res = evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(hex.EncodeToString(res.Payload)).To(Equal("62000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000"))
+			})
+
+			Context("when user1 is given right to vote", func() {
+				var baseCallCount int
+
+				BeforeEach(func() {
+					user1Addr, err := identityToAddr([]byte(user1Cert))
+					Expect(err).ToNot(HaveOccurred())
+					stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(giveRightToVote + hex.EncodeToString(user1Addr.Word256().Bytes()))})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					baseCallCount = stub.PutStateCallCount()
+				})
+
+				Context("when user1 votes for proposal 'a'", func() {
+					BeforeEach(func() {
+						stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(vote + "0000000000000000000000000000000000000000000000000000000000000000")})
+						stub.GetCreatorReturns(user1, nil)
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+						Expect(stub.PutStateCallCount()).To(Equal(baseCallCount+4), "`vote` should perform 3 writes: sender.voted, sender.vote, voteCount")
+					})
+
+					It("sets the variables of voter 1 (user1) properly", func() {
+						user1addr, err := identityToAddr([]byte(user1Cert))
+						Expect(err).ToNot(HaveOccurred())
+						stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(voters + hex.EncodeToString(user1addr.Word256().Bytes()))})
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+						Expect(hex.EncodeToString(res.Payload)).To(Equal("0000000000000000000000000000000000000000000000000000000000000001000000000000000000000000000000000000000000000000000000000000000100000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000"))
+					})
+
+					It("increments vote count of proposal 'a'", func() {
+						stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(proposals + "0000000000000000000000000000000000000000000000000000000000000000")})
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+						Expect(hex.EncodeToString(res.Payload)).To(Equal("61000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000001"))
+					})
+
+					It("should make proposal 'a' winner", func() {
+						stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(winnerName)})
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+						Expect(hex.EncodeToString(res.Payload)).To(Equal("6100000000000000000000000000000000000000000000000000000000000000"))
+					})
+				})
+
+				Context("when user2 votes for proposal 'a'", func() {
+					BeforeEach(func() {
+						stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(vote + "0000000000000000000000000000000000000000000000000000000000000000")})
+						stub.GetCreatorReturns(user2, nil)
+						res := evmcc.Invoke(stub)
+						baseCallCount = stub.PutStateCallCount()
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+						Expect(stub.PutStateCallCount()).To(Equal(baseCallCount), "require(!sender.voted) should fail, therefore NO write should be performed")
+					})
+
+					It("does not increment vote count of proposal 'a'", func() {
+						stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(proposals + "0000000000000000000000000000000000000000000000000000000000000000")})
+						res := evmcc.Invoke(stub)
+						Expect(stub.PutStateCallCount()).To(Equal(baseCallCount), "query should not write to ledger")
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+						Expect(hex.EncodeToString(res.Payload)).To(Equal("61000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000"))
+					})
+				})
+			})
+		})
+
+		Context("when a smart contract has events", func() {
+			var (
+				userCert = `-----BEGIN CERTIFICATE-----
+MIICGTCCAcCgAwIBAgIRAOdmptMzz5y0A9GOgFLxRNcwCgYIKoZIzj0EAwIwczEL
+MAkGA1UEBhMCVVMxEzARBgNVBAgTCkNhbGlmb3JuaWExFjAUBgNVBAcTDVNhbiBG
+cmFuY2lzY28xGTAXBgNVBAoTEG9yZzEuZXhhbXBsZS5jb20xHDAaBgNVBAMTE2Nh
+Lm9yZzEuZXhhbXBsZS5jb20wHhcNMTgwMjEyMDY0MDMyWhcNMjgwMjEwMDY0MDMy
+WjBbMQswCQYDVQQGEwJVUzETMBEGA1UECBMKQ2FsaWZvcm5pYTEWMBQGA1UEBxMN
+U2FuIEZyYW5jaXNjbzEfMB0GA1UEAwwWVXNlcjFAb3JnMS5leGFtcGxlLmNvbTBZ
+MBMGByqGSM49AgEGCCqGSM49AwEHA0IABEwsU2N6Kqrtl73S7+7/nD/LTfDFVWO4
+q3MTtbckd6MH2zTUj9idLoaQ5VNGJVTRRPs+O6bxlvl0Mitu1rcXFoyjTTBLMA4G
+A1UdDwEB/wQEAwIHgDAMBgNVHRMBAf8EAjAAMCsGA1UdIwQkMCKAIKtXuAgSGNzS
+0Yz91W08FSieahwkOU7pWJvh86pkNuxSMAoGCCqGSM49BAMCA0cAMEQCIDOGUUvv
+SgCqSQONblgBtkKuKgN36VgX+jLhZbaqMNAtAiBXiAHbgYdu3UHBVJwdTYxuFTWJ
+Vc4foA7mruwjI8sEng==
+-----END CERTIFICATE-----`
+
+				creator = marshalCreator("TestOrg", []byte(userCert))
+
+				/*pragma solidity ^0.4.0;
+				  contract Instructor {
+				    bytes32 fName;
+				    uint age;
+				    uint salary;
+				    event Setter(bytes32 indexed name, uint age, uint salary);
+				    function setInstructor(bytes32 _fName, uint _age, uint _salary) public {
+				      fName = _fName;
+				      age = _age;
+				      salary = _salary;
+				      emit Setter(_fName, _age, _salary);
+				    }
+				    function getInstructor() public constant returns (bytes32, uint, uint) {
+				      return (fName, age, salary);
 				    }
+				  }*/
+
+				deployCode      = []byte("608060405234801561001057600080fd5b50610122806100206000396000f30060806040526004361060485763ffffffff7c010000000000000000000000000000000000000000000000000000000060003504166331fb1dff8114604d5780633c1b81a514606a575b600080fd5b348015605857600080fd5b506068600435602435604435609a565b005b348015607557600080fd5b50607c60e8565b60408051938452602084019290925282820152519081900360600190f35b6000839055600182905560028190556040805183815260208101839052815185927fe920a6ca2d94687457e136223552305dbabca6f28cf9c65d18efc2193a2369b0928290030190a2505050565b6000546001546002549091925600a165627a7a723058201f3b3871bfe7762e6fb776ed8b5d5533e07178b576c630cf89a7e63a7b54b57b0029")
+				contractAddress crypto.Address
+				SET             = "31fb1dff" //"setInstructor(bytes32,uint256,uint256)"
+				GET             = "3c1b81a5" //"getInstructor()"
+				msg             exec.LogEvent
+				messagePayloads []exec.LogEvent
+			)
+
+			BeforeEach(func() {
+				// Set contract creator
+				stub.GetCreatorReturns(creator, nil)
+
+				// zero address, and deploy code is contract creation
+				stub.GetArgsReturns([][]byte{[]byte(crypto.ZeroAddress.String()), deployCode})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(stub.PutStateCallCount()).To(Equal(6))
+
+				var err error
+				contractAddress, err = crypto.AddressFromHexString(string(res.Payload))
+				Expect(err).ToNot(HaveOccurred())
+
+				topics := []binary.Word256{}
+
+				//First topic refers to the Event: sha3('Setter(bytes32, uint256, uint256)')
+				topic, err := hex.DecodeString("e920a6ca2d94687457e136223552305dbabca6f28cf9c65d18efc2193a2369b0")
+				Expect(err).ToNot(HaveOccurred())
+				topics = append(topics, binary.RightPadWord256(topic))
+
+				// Second topic is the value of the first indexed param of the event. In this case it is the name in bytes. The value is "Sam" in hex
+				topic, err = hex.DecodeString("53616d0000000000000000000000000000000000000000000000000000000000")
+				Expect(err).ToNot(HaveOccurred())
+				topics = append(topics, binary.RightPadWord256(topic))
+
+				//Data contains the non indexed elements of the event concatenated together. Remaining values are age and salary which are hex encoded
+				// 0x0000000000000000000000000000000000000000000000000000000000000019 is 25 (age)
+				// 0x0000000000000000000000000000000000000000000000000000000000007530 is 30000 (salary)
+				data, err := hex.DecodeString("00000000000000000000000000000000000000000000000000000000000000190000000000000000000000000000000000000000000000000000000000007530")
+
+				Expect(err).ToNot(HaveOccurred())
+
+				msg = exec.LogEvent{
+					Address: contractAddress,
+					Topics:  topics,
+					Data:    data,
 				}
+
+				messagePayloads = []exec.LogEvent{msg}
+			})
+
+			Context("if the method called emits event(s)", func() {
+				It("sets the chaincode event", func() {
+					// The 3 values following SET are the arguments to SET. All 3 are hex encoded
+					// First arg is the hex encoded name "Sam"
+					// Second arg is the hex encoded value 25
+					// Third arg is the hex encoded value 30
+					stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(SET + "53616d0000000000000000000000000000000000000000000000000000000000" + "0000000000000000000000000000000000000000000000000000000000000019" + "0000000000000000000000000000000000000000000000000000000000007530")})
+
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					expectedPayload, ok := json.Marshal(messagePayloads)
+					Expect(ok).ToNot(HaveOccurred())
+
+					Expect(stub.SetEventCallCount()).To(Equal(1))
+					setEventName, setEventPayload := stub.SetEventArgsForCall(0)
+					Expect(setEventName).To(Equal(SET))
+					Expect(setEventPayload).To(Equal([]byte(expectedPayload)))
+
+					var unmarshaledPayloads []exec.LogEvent
+					err := json.Unmarshal(setEventPayload, &unmarshaledPayloads)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(unmarshaledPayloads).To(Equal(messagePayloads))
+				})
+			})
+
+			Context("if the method called does not emit any events", func() {
+				It("doesn't set any chaincode event", func() {
+					stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(GET)})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(stub.SetEventCallCount()).To(Equal(0))
+				})
+			})
+		})
+
+		Context("when a contract reverts", func() {
+			var (
+				/* Hand assembled contract whose runtime code reverts with
+				   Error("boom") whatever the input is
+				*/
+				deployCode      = []byte("6057600c60003960576000f37f08c379a000000000000000000000000000000000000000000000000000000000600052602060045260046024527f626f6f6d0000000000000000000000000000000000000000000000000000000060445260646000fd")
+				revertData      = "08c379a0" + "0000000000000000000000000000000000000000000000000000000000000020" + "0000000000000000000000000000000000000000000000000000000000000004" + "626f6f6d00000000000000000000000000000000000000000000000000000000"
+				contractAddress crypto.Address
+			)
+
+			BeforeEach(func() {
+				stub.GetArgsReturns([][]byte{[]byte(crypto.ZeroAddress.String()), deployCode})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				var err error
+				contractAddress, err = crypto.AddressFromHexString(string(res.Payload))
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("returns the decoded revert reason and the revert data", func() {
+				stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte("6d4ce63c")})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+
+				revertErr, ok := evmerrors.Parse(res.Message)
+				Expect(ok).To(BeTrue())
+				Expect(revertErr).To(Equal(&evmerrors.Error{
+					Code:    evmerrors.CodeRevert,
+					Message: "execution reverted: boom",
+					Data:    revertData,
+					Reason:  "boom",
+				}))
+			})
+		})
+
+		Context("when a contract reads the block context", func() {
+			var (
+				/* Hand assembled contract whose runtime code returns
+				   (block.timestamp, block.number)
 				*/
-				contractByteCode = "6060604052341561000f57600080fd5b604051610b0b380380610b0b833981016040528080518201919050506000336000806101000a81548173ffffffffffffffffffffffffffffffffffffffff021916908373ffffffffffffffffffffffffffffffffffffffff16021790555060018060008060009054906101000a900473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060000181905550600090505b815181101561016757600280548060010182816100f7919061016e565b916000526020600020906002020160006040805190810160405280868681518110151561012057fe5b9060200190602002015160001916815260200160008152509091909150600082015181600001906000191690556020820151816001015550505080806001019150506100da565b50506101cf565b81548183558181151161019b5760020281600202836000526020600020918201910161019a91906101a0565b5b505050565b6101cc91905b808211156101c8576000808201600090556001820160009055506002016101a6565b5090565b90565b61092d806101de6000396000f30060606040526004361061008e576000357c0100000000000000000000000000000000000000000000000000000000900463ffffffff1680630121b93f14610093578063013cf08b146100b65780632e4176cf146100fc5780635c19a95c14610151578063609ff1bd1461018a5780639e7b8d61146101b3578063a3ec138d146101ec578063e2ba53f01461027e575b600080fd5b341561009e57600080fd5b6100b460048080359060200190919050506102af565b005b34156100c157600080fd5b6100d7600480803590602001909190505061036c565b6040518083600019166000191681526020018281526020019250505060405180910390f35b341561010757600080fd5b61010f61039f565b604051808273ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200191505060405180910390f35b341561015c57600080fd5b610188600480803573ffffffffffffffffffffffffffffffffffffffff169060200190919050506103c4565b005b341561019557600080fd5b61019d6106ae565b6040518082815260200191505060405180910390f35b34156101be57600080fd5b6101ea600480803573ffffffffffffffffffffffffffffffffffffffff16906020019091905050610729565b005b34156101f757600080fd5b610223600480803573ffffffffffffffffffffffffffffffffffffffff16906020019091905050610875565b60405180858152602001841515151581526020018373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200182815260200194505050505060405180910390f35b341561028957600080fd5b6102916108d2565b60405180826000191660001916815260200191505060405180910390f35b6000600160003373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002090508060010160009054906101000a900460ff1615151561031057600080fd5b60018160010160006101000a81548160ff021916908315150217905550818160020181905550806000015460028381548110151561034a57fe5b9060005260206000209060020201600101600082825401925050819055505050565b60028181548110151561037b57fe5b90600052602060002090600202016000915090508060000154908060010154905082565b6000809054906101000a900473ffffffffffffffffffffffffffffffffffffffff1681565b600080600160003373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002091508160010160009054906101000a900460ff1615151561042657600080fd5b3373ffffffffffffffffffffffffffffffffffffffff168373ffffffffffffffffffffffffffffffffffffffff161415151561046157600080fd5b5b600073ffffffffffffffffffffffffffffffffffffffff16600160008573ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060010160019054906101000a900473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff1614151561059f57600160008473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060010160019054906101000a900473ffffffffffffffffffffffffffffffffffffffff1692503373ffffffffffffffffffffffffffffffffffffffff168373ffffffffffffffffffffffffffffffffffffffff161415151561059a57600080fd5b610462565b60018260010160006101000a81548160ff021916908315150217905550828260010160016101000a81548173ffffffffffffffffffffffffffffffffffffffff021916908373ffffffffffffffffffffffffffffffffffffffff160217905550600160008473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002090508060010160009054906101000a900460ff16156106925781600001546002826002015481548110151561066f57fe5b9060005260206000209060020201600101600082825401925050819055506106a9565b816000015481600001600082825401925050819055505b505050565b6000806000809150600090505b60028054905081101561072457816002828154811015156106d857fe5b9060005260206000209060020201600101541115610717576002818154811015156106ff57fe5b90600052602060002090600202016001015491508092505b80806001019150506106bb565b505090565b6000809054906101000a900473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff163373ffffffffffffffffffffffffffffffffffffffff161480156107d25750600160008273ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060010160009054906101000a900460ff16155b801561082057506000600160008373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060000154145b151561082b57600080fd5b60018060008373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff1681526020019081526020016000206000018190555050565b60016020528060005260406000206000915090508060000154908060010160009054906101000a900460ff16908060010160019054906101000a900473ffffffffffffffffffffffffffffffffffffffff16908060020154905084565b600060026108de6106ae565b8154811015156108ea57fe5b9060005260206000209060020201600001549050905600a165627a7a723058209216e84efeb17007ba61a1573380cb306de0e38c64eb02e0f9362367121816080029"
-				runtimeByteCode  = "60606040526004361061008e576000357c0100000000000000000000000000000000000000000000000000000000900463ffffffff1680630121b93f14610093578063013cf08b146100b65780632e4176cf146100fc5780635c19a95c14610151578063609ff1bd1461018a5780639e7b8d61146101b3578063a3ec138d146101ec578063e2ba53f01461027e575b600080fd5b341561009e57600080fd5b6100b460048080359060200190919050506102af565b005b34156100c157600080fd5b6100d7600480803590602001909190505061036c565b6040518083600019166000191681526020018281526020019250505060405180910390f35b341561010757600080fd5b61010f61039f565b604051808273ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200191505060405180910390f35b341561015c57600080fd5b610188600480803573ffffffffffffffffffffffffffffffffffffffff169060200190919050506103c4565b005b341561019557600080fd5b61019d6106ae565b6040518082815260200191505060405180910390f35b34156101be57600080fd5b6101ea600480803573ffffffffffffffffffffffffffffffffffffffff16906020019091905050610729565b005b34156101f757600080fd5b610223600480803573ffffffffffffffffffffffffffffffffffffffff16906020019091905050610875565b60405180858152602001841515151581526020018373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200182815260200194505050505060405180910390f35b341561028957600080fd5b6102916108d2565b60405180826000191660001916815260200191505060405180910390f35b6000600160003373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002090508060010160009054906101000a900460ff1615151561031057600080fd5b60018160010160006101000a81548160ff021916908315150217905550818160020181905550806000015460028381548110151561034a57fe5b9060005260206000209060020201600101600082825401925050819055505050565b60028181548110151561037b57fe5b90600052602060002090600202016000915090508060000154908060010154905082565b6000809054906101000a900473ffffffffffffffffffffffffffffffffffffffff1681565b600080600160003373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002091508160010160009054906101000a900460ff1615151561042657600080fd5b3373ffffffffffffffffffffffffffffffffffffffff168373ffffffffffffffffffffffffffffffffffffffff161415151561046157600080fd5b5b600073ffffffffffffffffffffffffffffffffffffffff16600160008573ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060010160019054906101000a900473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff1614151561059f57600160008473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060010160019054906101000a900473ffffffffffffffffffffffffffffffffffffffff1692503373ffffffffffffffffffffffffffffffffffffffff168373ffffffffffffffffffffffffffffffffffffffff161415151561059a57600080fd5b610462565b60018260010160006101000a81548160ff021916908315150217905550828260010160016101000a81548173ffffffffffffffffffffffffffffffffffffffff021916908373ffffffffffffffffffffffffffffffffffffffff160217905550600160008473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002090508060010160009054906101000a900460ff16156106925781600001546002826002015481548110151561066f57fe5b9060005260206000209060020201600101600082825401925050819055506106a9565b816000015481600001600082825401925050819055505b505050565b6000806000809150600090505b60028054905081101561072457816002828154811015156106d857fe5b9060005260206000209060020201600101541115610717576002818154811015156106ff57fe5b90600052602060002090600202016001015491508092505b80806001019150506106bb565b505090565b6000809054906101000a900473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff163373ffffffffffffffffffffffffffffffffffffffff161480156107d25750600160008273ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060010160009054906101000a900460ff16155b801561082057506000600160008373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060000154145b151561082b57600080fd5b60018060008373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff1681526020019081526020016000206000018190555050565b60016020528060005260406000206000915090508060000154908060010160009054906101000a900460ff16908060010160019054906101000a900473ffffffffffffffffffffffffffffffffffffffff16908060020154905084565b600060026108de6106ae565b8154811015156108ea57fe5b9060005260206000209060020201600001549050905600a165627a7a723058209216e84efeb17007ba61a1573380cb306de0e38c64eb02e0f9362367121816080029"
-				// encoded bytes for ["a", "b"]
-				// It consists of four elements which take byte32 each:
-				// - 0x20 the location of data
-				// - 0x2  the length of array
-				// - 0x61 left-aligned 'a'
-				// - 0x62 left-aligned 'b'
-				constructorArgs = "0000000000000000000000000000000000000000000000000000000000000020000000000000000000000000000000000000000000000000000000000000000261000000000000000000000000000000000000000000000000000000000000006200000000000000000000000000000000000000000000000000000000000000"
+				deployCode      = []byte("600d600c600039600d6000f3426000524360205260406000f3")
+				contractAddress crypto.Address
+			)
 
-				// Method hash
-				giveRightToVote = "9e7b8d61"
-				proposals       = "013cf08b"
-				vote            = "0121b93f"
-				winnerName      = "e2ba53f0"
-				voters          = "a3ec138d"
+			BeforeEach(func() {
+				stub.GetTxTimestampReturns(&timestamp.Timestamp{Seconds: 1000}, nil)
+			})
 
-				user1Cert = `-----BEGIN CERTIFICATE-----
-MIICGTCCAcCgAwIBAgIRAOdmptMzz5y0A9GOgFLxRNcwCgYIKoZIzj0EAwIwczEL
-MAkGA1UEBhMCVVMxEzARBgNVBAgTCkNhbGlmb3JuaWExFjAUBgNVBAcTDVNhbiBG
-cmFuY2lzY28xGTAXBgNVBAoTEG9yZzEuZXhhbXBsZS5jb20xHDAaBgNVBAMTE2Nh
-Lm9yZzEuZXhhbXBsZS5jb20wHhcNMTgwMjEyMDY0MDMyWhcNMjgwMjEwMDY0MDMy
-WjBbMQswCQYDVQQGEwJVUzETMBEGA1UECBMKQ2FsaWZvcm5pYTEWMBQGA1UEBxMN
-U2FuIEZyYW5jaXNjbzEfMB0GA1UEAwwWVXNlcjFAb3JnMS5leGFtcGxlLmNvbTBZ
-MBMGByqGSM49AgEGCCqGSM49AwEHA0IABEwsU2N6Kqrtl73S7+7/nD/LTfDFVWO4
-q3MTtbckd6MH2zTUj9idLoaQ5VNGJVTRRPs+O6bxlvl0Mitu1rcXFoyjTTBLMA4G
-A1UdDwEB/wQEAwIHgDAMBgNVHRMBAf8EAjAAMCsGA1UdIwQkMCKAIKtXuAgSGNzS
-0Yz91W08FSieahwkOU7pWJvh86pkNuxSMAoGCCqGSM49BAMCA0cAMEQCIDOGUUvv
-SgCqSQONblgBtkKuKgN36VgX+jLhZbaqMNAtAiBXiAHbgYdu3UHBVJwdTYxuFTWJ
-Vc4foA7mruwjI8sEng==
------END CERTIFICATE-----`
+			JustBeforeEach(func() {
+				stub.GetArgsReturns([][]byte{[]byte(crypto.ZeroAddress.String()), deployCode})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
 
-				user2Cert = `-----BEGIN CERTIFICATE-----
-MIICGDCCAb+gAwIBAgIQMhSPvpu4KGobIvRGEGnZojAKBggqhkjOPQQDAjBzMQsw
-CQYDVQQGEwJVUzETMBEGA1UECBMKQ2FsaWZvcm5pYTEWMBQGA1UEBxMNU2FuIEZy
-YW5jaXNjbzEZMBcGA1UEChMQb3JnMi5leGFtcGxlLmNvbTEcMBoGA1UEAxMTY2Eu
-b3JnMi5leGFtcGxlLmNvbTAeFw0xODAyMTIwNjQwMzJaFw0yODAyMTAwNjQwMzJa
-MFsxCzAJBgNVBAYTAlVTMRMwEQYDVQQIEwpDYWxpZm9ybmlhMRYwFAYDVQQHEw1T
-YW4gRnJhbmNpc2NvMR8wHQYDVQQDDBZVc2VyMUBvcmcyLmV4YW1wbGUuY29tMFkw
-EwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE6mVSTODH+CUZk7/rU+MtycF610ifj0pT
-gXGYgJXLLcWbGAC1/ADE8rgq+zihgStD9rnwk0XMitXvqYbIhR0EEqNNMEswDgYD
-VR0PAQH/BAQDAgeAMAwGA1UdEwEB/wQCMAAwKwYDVR0jBCQwIoAg1NNSEgEmJaVF
-hk5bEaOs6HQS2PFt/VMeXrBUwIGDSogwCgYIKoZIzj0EAwIDRwAwRAIgY6k7AARJ
-yJINhf9ub8QcQiMnrTgD4kmhjh5ey8E7fVACIA/i396+beIk0T0c8loygaCiftyG
-H8GZeN2ifTyJzzGo
------END CERTIFICATE-----`
+				var err error
+				contractAddress, err = crypto.AddressFromHexString(string(res.Payload))
+				Expect(err).ToNot(HaveOccurred())
+			})
 
-				user1 = marshalCreator("TestOrg", []byte(user1Cert))
-				user2 = marshalCreator("TestOrg", []byte(user2Cert))
+			It("derives the block number from the transaction timestamp", func() {
+				stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte("00000000")})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(hex.EncodeToString(res.Payload)).To(Equal(
+					"00000000000000000000000000000000000000000000000000000000000003e8" +
+						"00000000000000000000000000000000000000000000000000000000000003e8"))
+			})
 
-				deployCode      []byte
+			Context("when the instance counts block numbers", func() {
+				BeforeEach(func() {
+					stub.GetArgsReturns([][]byte{[]byte(`{"block":{"number":"counter"}}`)})
+					res := evmcc.Init(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+				})
+
+				It("reads the block number without writing it", func() {
+					stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte("00000000")})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(hex.EncodeToString(res.Payload)).To(Equal(
+						"00000000000000000000000000000000000000000000000000000000000003e8" +
+							"0000000000000000000000000000000000000000000000000000000000000000"))
+					Expect(fakeLedger).ToNot(HaveKey("evmcc.blocknumber"))
+				})
+
+				Context("when an admin advances the block", func() {
+					BeforeEach(func() {
+						stub.GetArgsReturns([][]byte{[]byte(`{"admins":["TestOrg"],"block":{"number":"counter"}}`)})
+						res := evmcc.Init(stub)
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+
+						stub.GetArgsReturns([][]byte{[]byte("advanceBlock")})
+						res = evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+						Expect(string(res.Payload)).To(Equal("1"))
+					})
+
+					It("runs transactions and queries in the new block", func() {
+						for _, args := range [][][]byte{
+							{[]byte(contractAddress.String()), []byte("00000000")},
+							{[]byte("query"), []byte(contractAddress.String()), []byte("00000000")},
+						} {
+							stub.GetArgsReturns(args)
+							res := evmcc.Invoke(stub)
+							Expect(res.Status).To(Equal(int32(shim.OK)))
+							Expect(hex.EncodeToString(res.Payload)).To(Equal(
+								"00000000000000000000000000000000000000000000000000000000000003e8" +
+									"0000000000000000000000000000000000000000000000000000000000000001"))
+						}
+					})
+				})
+
+				It("only lets admins advance the block", func() {
+					stub.GetArgsReturns([][]byte{[]byte("advanceBlock")})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.ERROR)))
+					Expect(res.Message).To(ContainSubstring("TestOrg is not an admin of this instance"))
+				})
+			})
+
+			It("does not let blocks be advanced unless they are counted", func() {
+				stub.GetArgsReturns([][]byte{[]byte(`{"admins":["TestOrg"]}`)})
+				res := evmcc.Init(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				stub.GetArgsReturns([][]byte{[]byte("advanceBlock")})
+				res = evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+				Expect(res.Message).To(Equal("block numbers are not counted on this instance"))
+			})
+
+			It("returns zero for BLOCKHASH, which the EVM does not support", func() {
+				/* Hand assembled contract whose runtime code returns
+				   blockhash(block.number - 1)
+				*/
+				stub.GetArgsReturns([][]byte{[]byte(crypto.ZeroAddress.String()), []byte("600d600c600039600d6000f3600143034060005260206000f3")})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				stub.GetArgsReturns([][]byte{res.Payload, []byte("00000000")})
+				res = evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(res.Payload).To(Equal(make([]byte, 32)))
+			})
+		})
+
+		Context("when value is sent", func() {
+			var (
+				callerAddress crypto.Address
+				payee         = "000000000000000000000000000000000000beef"
+				transfer      *envelope.Envelope
+			)
+
+			BeforeEach(func() {
+				var err error
+				callerAddress, err = identityToAddr([]byte(user0Cert))
+				Expect(err).ToNot(HaveOccurred())
+
+				transfer = envelope.New()
+				transfer.To = "0x" + payee
+				transfer.Value = "0x2a"
+			})
+
+			It("returns an error unless balances are enabled", func() {
+				args, err := transfer.Args()
+				Expect(err).ToNot(HaveOccurred())
+				stub.GetArgsReturns(args)
+
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+				Expect(res.Message).To(Equal("value transfers are not enabled on this instance"))
+			})
+
+			Context("when balances are enabled", func() {
+				BeforeEach(func() {
+					stub.GetArgsReturns([][]byte{[]byte(`{"admins":["TestOrg"],"balances":true}`)})
+					res := evmcc.Init(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					stub.GetArgsReturns([][]byte{[]byte("mint"), []byte(callerAddress.String()), []byte("0x64")})
+					res = evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+				})
+
+				getBalance := func(address string) string {
+					stub.GetArgsReturns([][]byte{[]byte("getBalance"), []byte(address)})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					return string(res.Payload)
+				}
+
+				It("mints value to accounts", func() {
+					Expect(getBalance(callerAddress.String())).To(Equal("100"))
+				})
+
+				It("transfers value between addresses", func() {
+					args, err := transfer.Args()
+					Expect(err).ToNot(HaveOccurred())
+					stub.GetArgsReturns(args)
+
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					Expect(getBalance(callerAddress.String())).To(Equal("58"))
+					Expect(getBalance("0x" + payee)).To(Equal("42"))
+				})
+
+				It("passes the value to contracts", func() {
+					/* Hand assembled contract whose runtime code returns
+					   msg.value
+					*/
+					stub.GetArgsReturns([][]byte{[]byte(crypto.ZeroAddress.String()), []byte("6009600c60003960096000f33460005260206000f3")})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					contractAddress := string(res.Payload)
+
+					call := envelope.New()
+					call.To = contractAddress
+					call.Value = "0xa"
+					args, err := call.Args()
+					Expect(err).ToNot(HaveOccurred())
+					stub.GetArgsReturns(args)
+
+					res = evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(hex.EncodeToString(res.Payload)).To(Equal("000000000000000000000000000000000000000000000000000000000000000a"))
+
+					Expect(getBalance(callerAddress.String())).To(Equal("90"))
+					Expect(getBalance(contractAddress)).To(Equal("10"))
+				})
+
+				Context("when the caller does not have enough balance", func() {
+					It("returns an error", func() {
+						transfer.Value = "0x1000"
+						args, err := transfer.Args()
+						Expect(err).ToNot(HaveOccurred())
+						stub.GetArgsReturns(args)
+
+						res := evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.ERROR)))
+					})
+				})
+
+				Context("when the creator is not an admin", func() {
+					It("cannot mint", func() {
+						stub.GetArgsReturns([][]byte{[]byte(`{"balances":true}`)})
+						res := evmcc.Init(stub)
+						Expect(res.Status).To(Equal(int32(shim.OK)))
+
+						stub.GetArgsReturns([][]byte{[]byte("mint"), []byte(callerAddress.String()), []byte("0x64")})
+						res = evmcc.Invoke(stub)
+						Expect(res.Status).To(Equal(int32(shim.ERROR)))
+						Expect(res.Message).To(ContainSubstring("TestOrg is not an admin of this instance"))
+					})
+				})
+			})
+		})
+
+		Context("when a contract invokes a chaincode", func() {
+			var (
+				deployCode      = forwarderCode("0fab01")
 				contractAddress crypto.Address
+				// abi.encode("asset", "get", [bytes("a1")])
+				input = "0000000000000000000000000000000000000000000000000000000000000060" +
+					"00000000000000000000000000000000000000000000000000000000000000a0" +
+					"00000000000000000000000000000000000000000000000000000000000000e0" +
+					"0000000000000000000000000000000000000000000000000000000000000005" +
+					"6173736574000000000000000000000000000000000000000000000000000000" +
+					"0000000000000000000000000000000000000000000000000000000000000003" +
+					"6765740000000000000000000000000000000000000000000000000000000000" +
+					"0000000000000000000000000000000000000000000000000000000000000001" +
+					"0000000000000000000000000000000000000000000000000000000000000020" +
+					"0000000000000000000000000000000000000000000000000000000000000002" +
+					"6131000000000000000000000000000000000000000000000000000000000000"
 			)
 
 			BeforeEach(func() {
-				deployCode = []byte(contractByteCode + constructorArgs)
+				stub.GetArgsReturns([][]byte{[]byte(crypto.ZeroAddress.String()), deployCode})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				var err error
+				contractAddress, err = crypto.AddressFromHexString(string(res.Payload))
+				Expect(err).ToNot(HaveOccurred())
+
+				stub.InvokeChaincodeReturns(pb.Response{Status: shim.OK, Payload: []byte("value")})
+			})
+
+			It("forwards the request to the chaincode and returns its response", func() {
+				stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(input)})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				Expect(stub.InvokeChaincodeCallCount()).To(Equal(1))
+				name, args, channel := stub.InvokeChaincodeArgsForCall(0)
+				Expect(name).To(Equal("asset"))
+				Expect(args).To(Equal([][]byte{[]byte("get"), []byte("a1")}))
+				Expect(channel).To(BeEmpty())
+
+				// abi.encode(int32(200), bytes("value"))
+				Expect(hex.EncodeToString(res.Payload)).To(Equal(
+					"00000000000000000000000000000000000000000000000000000000000000c8" +
+						"0000000000000000000000000000000000000000000000000000000000000040" +
+						"0000000000000000000000000000000000000000000000000000000000000005" +
+						"76616c7565000000000000000000000000000000000000000000000000000000"))
+			})
+
+			Context("when the request cannot be decoded", func() {
+				It("does not invoke the chaincode", func() {
+					stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(input[:128])})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(res.Payload).To(BeEmpty())
+					Expect(stub.InvokeChaincodeCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the contract is queried", func() {
+				It("does not invoke the chaincode", func() {
+					stub.GetArgsReturns([][]byte{[]byte("query"), []byte(contractAddress.String()), []byte(input)})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(res.Payload).To(BeEmpty())
+					Expect(stub.InvokeChaincodeCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the frame invoking the chaincode reverts", func() {
+				var proxyAddress string
+
+				BeforeEach(func() {
+					/* Hand assembled contract whose runtime code forwards
+					its input to the precompile, like forwarderCode, and
+					reverts with the output of the precompile. */
+					revertingCode := []byte("6020600c60003960206000f336600060003760006000366000600062" + "0fab01" + "5af1503d600060003e3d6000fd")
+					stub.GetArgsReturns([][]byte{[]byte(crypto.ZeroAddress.String()), revertingCode})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					stub.GetArgsReturns([][]byte{[]byte(crypto.ZeroAddress.String()), proxyCode(string(res.Payload))})
+					res = evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					proxyAddress = string(res.Payload)
+				})
+
+				// The invocation is not atomic with the frame: the
+				// chaincode invoked has run, and its writes stay in the
+				// transaction, though the frame invoking it reverted.
+				It("keeps the invocation when the caller of the frame succeeds", func() {
+					stub.GetArgsReturns([][]byte{[]byte(proxyAddress), []byte(input)})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					Expect(stub.InvokeChaincodeCallCount()).To(Equal(1))
+					name, args, _ := stub.InvokeChaincodeArgsForCall(0)
+					Expect(name).To(Equal("asset"))
+					Expect(args).To(Equal([][]byte{[]byte("get"), []byte("a1")}))
+				})
+			})
+		})
+
+		Context("when a contract is deployed to a private data collection", func() {
+			var (
+				fakePrivate     map[string][]byte
+				contractAddress string
+				SET             = "60fe47b1"
+				GET             = "6d4ce63c"
+			)
+
+			BeforeEach(func() {
+				fakePrivate = make(map[string][]byte)
+
+				stub.PutPrivateDataStub = func(collection, key string, value []byte) error {
+					fakePrivate[collection+"/"+key] = value
+					return nil
+				}
+
+				stub.GetPrivateDataStub = func(collection, key string) ([]byte, error) {
+					return fakePrivate[collection+"/"+key], nil
+				}
+
+				deploy := envelope.New()
+				deploy.Data = string(deployCode)
+				deploy.Collection = "secrets"
+				args, err := deploy.Args()
+				Expect(err).ToNot(HaveOccurred())
+				stub.GetArgsReturns(args)
+
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				contractAddress = string(res.Payload)
+			})
+
+			It("keeps the storage of the contract in the collection", func() {
+				stub.GetArgsReturns([][]byte{[]byte(contractAddress), []byte(SET + "000000000000000000000000000000000000000000000000000000000000002a")})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				Expect(stub.PutPrivateDataCallCount()).To(Equal(1))
+				collection, _, _ := stub.PutPrivateDataArgsForCall(0)
+				Expect(collection).To(Equal("secrets"))
+
+				stub.GetArgsReturns([][]byte{[]byte(contractAddress), []byte(GET)})
+				res = evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(hex.EncodeToString(res.Payload)).To(Equal("000000000000000000000000000000000000000000000000000000000000002a"))
+				Expect(stub.GetPrivateDataCallCount()).To(BeNumerically(">", 0))
+			})
+
+			It("keeps the account of the contract public", func() {
+				address, err := crypto.AddressFromHexString(contractAddress)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fakeLedger).To(HaveKey(address.String()))
+				Expect(fakeLedger[address.String()+".metadata"]).To(MatchJSON(`{"collection":"secrets"}`))
+			})
+
+			Context("when a collection is given to a contract invocation", func() {
+				It("returns an error", func() {
+					call := envelope.New()
+					call.To = contractAddress
+					call.Data = GET
+					call.Collection = "secrets"
+					args, err := call.Args()
+					Expect(err).ToNot(HaveOccurred())
+					stub.GetArgsReturns(args)
+
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.ERROR)))
+					Expect(res.Message).To(Equal("a collection can only be given when deploying a contract"))
+				})
+			})
+		})
+
+		Context("when the instance stores accounts in JSON", func() {
+			var (
+				contractAddress crypto.Address
+				GET             = "6d4ce63c"
+			)
+
+			BeforeEach(func() {
+				stub.GetArgsReturns([][]byte{[]byte(`{"accountEncoding":"json"}`)})
+				res := evmcc.Init(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				stub.GetArgsReturns([][]byte{[]byte(crypto.ZeroAddress.String()), deployCode})
+				res = evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				var err error
+				contractAddress, err = crypto.AddressFromHexString(string(res.Payload))
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("writes the account of the contract as a JSON document along with its deployer", func() {
+				code, err := hex.DecodeString(runtimeCode)
+				Expect(err).ToNot(HaveOccurred())
 
-				// zero address, and deploy code is contract creation
-				stub.GetArgsReturns([][]byte{[]byte(crypto.ZeroAddress.String()), deployCode})
+				stub.GetArgsReturns([][]byte{[]byte("account")})
 				res := evmcc.Invoke(stub)
 				Expect(res.Status).To(Equal(int32(shim.OK)))
+				deployer := strings.ToLower(string(res.Payload))
 
-				// Last PutState Call is to store contract runtime bytecode
-				key, value := stub.PutStateArgsForCall(stub.PutStateCallCount() - 1)
+				Expect(fakeLedger[contractAddress.String()]).To(MatchJSON(fmt.Sprintf(
+					`{"version":1,"type":"evmcc.account","address":"%s","balance":0,"sequence":0,"code":"%s","codeHash":"%s","deployer":"%s","permissions":{"call":true,"send":true}}`,
+					hex.EncodeToString(contractAddress.Bytes()), runtimeCode, hex.EncodeToString(keccak.Sha3(code)), deployer)))
+				Expect(fakeLedger[contractAddress.String()+".metadata"]).To(MatchJSON(`{"deployer":"` + deployer + `"}`))
+			})
 
-				account := acm.Account{}
-				account.Unmarshal(value)
+			It("runs the contract", func() {
+				stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(GET)})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				Expect(hex.EncodeToString(res.Payload)).To(Equal("0000000000000000000000000000000000000000000000000000000000000000"))
+			})
+		})
 
-				Expect(strings.ToLower(key)).To(Equal(strings.ToLower(string(res.Payload))))
-				Expect(hex.EncodeToString(account.Code)).To(Equal(runtimeByteCode))
+		Context("when a contract is deployed with a salt", func() {
+			var (
+				callerAddress crypto.Address
+				salted        *envelope.Envelope
+				expected      string
+			)
 
+			BeforeEach(func() {
 				var err error
-				contractAddress, err = crypto.AddressFromHexString(string(res.Payload))
+				callerAddress, err = identityToAddr([]byte(user0Cert))
+				Expect(err).ToNot(HaveOccurred())
+
+				salted = envelope.New()
+				salted.Data = string(deployCode)
+				salted.Salt = "0x01"
+
+				code, err := hex.DecodeString(string(deployCode))
 				Expect(err).ToNot(HaveOccurred())
+
+				data := append([]byte{0xff}, callerAddress.Bytes()...)
+				data = append(data, binary.LeftPadWord256([]byte{1}).Bytes()...)
+				data = append(data, keccak.Sha3(code)...)
+				expected = hex.EncodeToString(keccak.Sha3(data)[12:])
 			})
 
-			It("is able to properly initialize proposals through constructor", func() {
-				//invoke proposals(x) to see if constructor args are properly stored
-				stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(proposals + "0000000000000000000000000000000000000000000000000000000000000000")})
-				res := evmcc.Invoke(stub)
+			deploy := func() pb.Response {
+				args, err := salted.Args()
+				Expect(err).ToNot(HaveOccurred())
+				stub.GetArgsReturns(args)
+				return evmcc.Invoke(stub)
+			}
+
+			It("deploys the contract at the address derived from the salt", func() {
+				res := deploy()
 				Expect(res.Status).To(Equal(int32(shim.OK)))
-				Expect(hex.EncodeToString(res.Payload)).To(Equal("61000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000"))
+				Expect(string(res.Payload)).To(Equal(expected))
 
-				stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(proposals + "0000000000000000000000000000000000000000000000000000000000000001")})
+				stub.GetArgsReturns([][]byte{[]byte("getCode"), []byte(expected)})
 				res = evmcc.Invoke(stub)
 				Expect(res.Status).To(Equal(int32(shim.OK)))
-				Expect(hex.EncodeToString(res.Payload)).To(Equal("62000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000"))
+				Expect(string(res.Payload)).To(Equal(runtimeCode))
 			})
 
-			Context("when user1 is given right to vote", func() {
-				var baseCallCount int
+			It("detects the collisions with existing contracts", func() {
+				res := deploy()
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				res = deploy()
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+				Expect(res.Message).To(ContainSubstring("failed to create account"))
+			})
 
+			Context("when value was sent to the address beforehand", func() {
 				BeforeEach(func() {
-					user1Addr, err := identityToAddr([]byte(user1Cert))
-					Expect(err).ToNot(HaveOccurred())
-					stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(giveRightToVote + hex.EncodeToString(user1Addr.Word256().Bytes()))})
-					res := evmcc.Invoke(stub)
+					stub.GetArgsReturns([][]byte{[]byte(`{"admins":["TestOrg"],"balances":true}`)})
+					res := evmcc.Init(stub)
 					Expect(res.Status).To(Equal(int32(shim.OK)))
 
-					baseCallCount = stub.PutStateCallCount()
+					stub.GetArgsReturns([][]byte{[]byte("mint"), []byte(expected), []byte("0x7")})
+					res = evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
 				})
 
-				Context("when user1 votes for proposal 'a'", func() {
-					BeforeEach(func() {
-						stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(vote + "0000000000000000000000000000000000000000000000000000000000000000")})
-						stub.GetCreatorReturns(user1, nil)
-						res := evmcc.Invoke(stub)
-						Expect(res.Status).To(Equal(int32(shim.OK)))
-						Expect(stub.PutStateCallCount()).To(Equal(baseCallCount+4), "`vote` should perform 3 writes: sender.voted, sender.vote, voteCount")
-					})
-
-					It("sets the variables of voter 1 (user1) properly", func() {
-						user1addr, err := identityToAddr([]byte(user1Cert))
-						Expect(err).ToNot(HaveOccurred())
-						stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(voters + hex.EncodeToString(user1addr.Word256().Bytes()))})
-						res := evmcc.Invoke(stub)
-						Expect(res.Status).To(Equal(int32(shim.OK)))
-						Expect(hex.EncodeToString(res.Payload)).To(Equal("0000000000000000000000000000000000000000000000000000000000000001000000000000000000000000000000000000000000000000000000000000000100000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000"))
-					})
-
-					It("increments vote count of proposal 'a'", func() {
-						stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(proposals + "0000000000000000000000000000000000000000000000000000000000000000")})
-						res := evmcc.Invoke(stub)
-						Expect(res.Status).To(Equal(int32(shim.OK)))
-						Expect(hex.EncodeToString(res.Payload)).To(Equal("61000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000001"))
-					})
+				It("deploys the contract and keeps the balance", func() {
+					res := deploy()
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(string(res.Payload)).To(Equal(expected))
 
-					It("should make proposal 'a' winner", func() {
-						stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(winnerName)})
-						res := evmcc.Invoke(stub)
-						Expect(res.Status).To(Equal(int32(shim.OK)))
-						Expect(hex.EncodeToString(res.Payload)).To(Equal("6100000000000000000000000000000000000000000000000000000000000000"))
-					})
+					stub.GetArgsReturns([][]byte{[]byte("getBalance"), []byte(expected)})
+					res = evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(string(res.Payload)).To(Equal("7"))
 				})
+			})
 
-				Context("when user2 votes for proposal 'a'", func() {
-					BeforeEach(func() {
-						stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(vote + "0000000000000000000000000000000000000000000000000000000000000000")})
-						stub.GetCreatorReturns(user2, nil)
-						res := evmcc.Invoke(stub)
-						baseCallCount = stub.PutStateCallCount()
-						Expect(res.Status).To(Equal(int32(shim.OK)))
-						Expect(stub.PutStateCallCount()).To(Equal(baseCallCount), "require(!sender.voted) should fail, therefore NO write should be performed")
-					})
-
-					It("does not increment vote count of proposal 'a'", func() {
-						stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(proposals + "0000000000000000000000000000000000000000000000000000000000000000")})
-						res := evmcc.Invoke(stub)
-						Expect(stub.PutStateCallCount()).To(Equal(baseCallCount), "query should not write to ledger")
-						Expect(res.Status).To(Equal(int32(shim.OK)))
-						Expect(hex.EncodeToString(res.Payload)).To(Equal("61000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000"))
-					})
+			Context("when the salt is too long", func() {
+				It("returns an error", func() {
+					salted.Salt = "0x" + strings.Repeat("01", 33)
+					res := deploy()
+					Expect(res.Status).To(Equal(int32(shim.ERROR)))
+					Expect(res.Message).To(ContainSubstring("invalid salt"))
 				})
 			})
 		})
 
-		Context("when a smart contract has events", func() {
+		Context("when a contract has an acl", func() {
 			var (
-				userCert = `-----BEGIN CERTIFICATE-----
-MIICGTCCAcCgAwIBAgIRAOdmptMzz5y0A9GOgFLxRNcwCgYIKoZIzj0EAwIwczEL
-MAkGA1UEBhMCVVMxEzARBgNVBAgTCkNhbGlmb3JuaWExFjAUBgNVBAcTDVNhbiBG
-cmFuY2lzY28xGTAXBgNVBAoTEG9yZzEuZXhhbXBsZS5jb20xHDAaBgNVBAMTE2Nh
-Lm9yZzEuZXhhbXBsZS5jb20wHhcNMTgwMjEyMDY0MDMyWhcNMjgwMjEwMDY0MDMy
-WjBbMQswCQYDVQQGEwJVUzETMBEGA1UECBMKQ2FsaWZvcm5pYTEWMBQGA1UEBxMN
-U2FuIEZyYW5jaXNjbzEfMB0GA1UEAwwWVXNlcjFAb3JnMS5leGFtcGxlLmNvbTBZ
-MBMGByqGSM49AgEGCCqGSM49AwEHA0IABEwsU2N6Kqrtl73S7+7/nD/LTfDFVWO4
-q3MTtbckd6MH2zTUj9idLoaQ5VNGJVTRRPs+O6bxlvl0Mitu1rcXFoyjTTBLMA4G
-A1UdDwEB/wQEAwIHgDAMBgNVHRMBAf8EAjAAMCsGA1UdIwQkMCKAIKtXuAgSGNzS
-0Yz91W08FSieahwkOU7pWJvh86pkNuxSMAoGCCqGSM49BAMCA0cAMEQCIDOGUUvv
-SgCqSQONblgBtkKuKgN36VgX+jLhZbaqMNAtAiBXiAHbgYdu3UHBVJwdTYxuFTWJ
-Vc4foA7mruwjI8sEng==
------END CERTIFICATE-----`
+				contractAddress string
+				GET             = "6d4ce63c"
+			)
 
-				creator = marshalCreator("TestOrg", []byte(userCert))
+			deploy := func(acl string) pb.Response {
+				env := envelope.New()
+				env.Data = string(deployCode)
+				env.ACL = []byte(acl)
+				args, err := env.Args()
+				Expect(err).ToNot(HaveOccurred())
+				stub.GetArgsReturns(args)
+				return evmcc.Invoke(stub)
+			}
 
-				/*pragma solidity ^0.4.0;
-				  contract Instructor {
-				    bytes32 fName;
-				    uint age;
-				    uint salary;
-				    event Setter(bytes32 indexed name, uint age, uint salary);
-				    function setInstructor(bytes32 _fName, uint _age, uint _salary) public {
-				      fName = _fName;
-				      age = _age;
-				      salary = _salary;
-				      emit Setter(_fName, _age, _salary);
-				    }
-				    function getInstructor() public constant returns (bytes32, uint, uint) {
-				      return (fName, age, salary);
-				    }
-				  }*/
+			get := func() pb.Response {
+				stub.GetArgsReturns([][]byte{[]byte(contractAddress), []byte(GET)})
+				return evmcc.Invoke(stub)
+			}
 
-				deployCode      = []byte("608060405234801561001057600080fd5b50610122806100206000396000f30060806040526004361060485763ffffffff7c010000000000000000000000000000000000000000000000000000000060003504166331fb1dff8114604d5780633c1b81a514606a575b600080fd5b348015605857600080fd5b506068600435602435604435609a565b005b348015607557600080fd5b50607c60e8565b60408051938452602084019290925282820152519081900360600190f35b6000839055600182905560028190556040805183815260208101839052815185927fe920a6ca2d94687457e136223552305dbabca6f28cf9c65d18efc2193a2369b0928290030190a2505050565b6000546001546002549091925600a165627a7a723058201f3b3871bfe7762e6fb776ed8b5d5533e07178b576c630cf89a7e63a7b54b57b0029")
-				contractAddress crypto.Address
-				SET             = "31fb1dff" //"setInstructor(bytes32,uint256,uint256)"
-				GET             = "3c1b81a5" //"getInstructor()"
-				msg             exec.LogEvent
-				messagePayloads []exec.LogEvent
-			)
+			setACL := func(acl string) pb.Response {
+				stub.GetArgsReturns([][]byte{[]byte("setACL"), []byte(contractAddress), []byte(acl)})
+				return evmcc.Invoke(stub)
+			}
 
 			BeforeEach(func() {
-				// Set contract creator
-				stub.GetCreatorReturns(creator, nil)
+				stub.GetArgsReturns([][]byte{[]byte(`{"admins":["TestOrg"]}`)})
+				res := evmcc.Init(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
 
-				// zero address, and deploy code is contract creation
-				stub.GetArgsReturns([][]byte{[]byte(crypto.ZeroAddress.String()), deployCode})
-				res := evmcc.Invoke(stub)
+				res = deploy(`{"mspids":["TestOrg"]}`)
 				Expect(res.Status).To(Equal(int32(shim.OK)))
-				Expect(stub.PutStateCallCount()).To(Equal(4))
+				contractAddress = string(res.Payload)
+			})
 
-				var err error
-				contractAddress, err = crypto.AddressFromHexString(string(res.Payload))
-				Expect(err).ToNot(HaveOccurred())
+			It("allows the creators satisfying the acl", func() {
+				res := get()
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+			})
 
-				topics := []binary.Word256{}
+			It("denies the creators of other MSPs", func() {
+				res := setACL(`{"mspids":["OtherOrg"]}`)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
 
-				//First topic refers to the Event: sha3('Setter(bytes32, uint256, uint256)')
-				topic, err := hex.DecodeString("e920a6ca2d94687457e136223552305dbabca6f28cf9c65d18efc2193a2369b0")
-				Expect(err).ToNot(HaveOccurred())
-				topics = append(topics, binary.RightPadWord256(topic))
+				res = get()
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+				Expect(res.Message).To(ContainSubstring("access denied to contract"))
+				Expect(res.Message).To(ContainSubstring("MSP TestOrg is not allowed"))
+			})
 
-				// Second topic is the value of the first indexed param of the event. In this case it is the name in bytes. The value is "Sam" in hex
-				topic, err = hex.DecodeString("53616d0000000000000000000000000000000000000000000000000000000000")
-				Expect(err).ToNot(HaveOccurred())
-				topics = append(topics, binary.RightPadWord256(topic))
+			It("denies the creators without the organizational unit", func() {
+				res := setACL(`{"ous":["client"]}`)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
 
-				//Data contains the non indexed elements of the event concatenated together. Remaining values are age and salary which are hex encoded
-				// 0x0000000000000000000000000000000000000000000000000000000000000019 is 25 (age)
-				// 0x0000000000000000000000000000000000000000000000000000000000007530 is 30000 (salary)
-				data, err := hex.DecodeString("00000000000000000000000000000000000000000000000000000000000000190000000000000000000000000000000000000000000000000000000000007530")
+				res = get()
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+				Expect(res.Message).To(ContainSubstring("organizational units"))
+			})
 
-				Expect(err).ToNot(HaveOccurred())
+			It("denies the creators without the attributes", func() {
+				res := setACL(`{"attributes":{"role":"auditor"}}`)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
 
-				msg = exec.LogEvent{
-					Address: contractAddress,
-					Topics:  topics,
-					Data:    data,
-				}
+				res = get()
+				Expect(res.Status).To(Equal(int32(shim.ERROR)))
+				Expect(res.Message).To(ContainSubstring("attribute role=auditor is required"))
+			})
 
-				messagePayloads = []exec.LogEvent{msg}
+			It("can be removed", func() {
+				res := setACL(`{"mspids":["OtherOrg"]}`)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				res = setACL(`null`)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				res = get()
+				Expect(res.Status).To(Equal(int32(shim.OK)))
 			})
 
-			Context("if the method called emits event(s)", func() {
-				It("sets the chaincode event", func() {
-					// The 3 values following SET are the arguments to SET. All 3 are hex encoded
-					// First arg is the hex encoded name "Sam"
-					// Second arg is the hex encoded value 25
-					// Third arg is the hex encoded value 30
-					stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(SET + "53616d0000000000000000000000000000000000000000000000000000000000" + "0000000000000000000000000000000000000000000000000000000000000019" + "0000000000000000000000000000000000000000000000000000000000007530")})
+			Context("when the contract is called by another contract", func() {
+				var proxyAddress string
+
+				BeforeEach(func() {
+					stub.GetArgsReturns([][]byte{[]byte(crypto.ZeroAddress.String()), proxyCode(contractAddress)})
+					res := evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					proxyAddress = string(res.Payload)
+				})
 
+				It("allows the creators satisfying the acl", func() {
+					stub.GetArgsReturns([][]byte{[]byte(proxyAddress), []byte(GET)})
 					res := evmcc.Invoke(stub)
 					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(res.Payload).To(HaveLen(32))
+				})
 
-					expectedPayload, ok := json.Marshal(messagePayloads)
-					Expect(ok).ToNot(HaveOccurred())
+				It("denies the creators of other MSPs", func() {
+					res := setACL(`{"mspids":["OtherOrg"]}`)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
 
-					Expect(stub.SetEventCallCount()).To(Equal(1))
-					setEventName, setEventPayload := stub.SetEventArgsForCall(0)
-					Expect(setEventName).To(Equal(SET))
-					Expect(setEventPayload).To(Equal([]byte(expectedPayload)))
+					stub.GetArgsReturns([][]byte{[]byte(proxyAddress), []byte(GET)})
+					res = evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.ERROR)))
+					Expect(res.Message).To(ContainSubstring("access denied to contract"))
+					Expect(res.Message).To(ContainSubstring("MSP TestOrg is not allowed"))
 
-					var unmarshaledPayloads []exec.LogEvent
-					err := json.Unmarshal(setEventPayload, &unmarshaledPayloads)
-					Expect(err).ToNot(HaveOccurred())
-					Expect(unmarshaledPayloads).To(Equal(messagePayloads))
+					evmErr, ok := evmerrors.Parse(res.Message)
+					Expect(ok).To(BeTrue())
+					Expect(evmErr.Code).To(Equal(evmerrors.CodePermissionDenied))
 				})
 			})
 
-			Context("if the method called does not emit any events", func() {
-				It("doesn't set any chaincode event", func() {
-					stub.GetArgsReturns([][]byte{[]byte(contractAddress.String()), []byte(GET)})
+			Context("when another contract reads the code of the contract", func() {
+				var codeSizeAddress string
+
+				BeforeEach(func() {
+					/* Hand assembled contract whose runtime code returns
+					the EXTCODESIZE of the address given as input. */
+					codeSizeCode := []byte("600c600c600039600c6000f3" + "6000353b60005260206000f3")
+					stub.GetArgsReturns([][]byte{[]byte(crypto.ZeroAddress.String()), codeSizeCode})
 					res := evmcc.Invoke(stub)
 					Expect(res.Status).To(Equal(int32(shim.OK)))
-					Expect(stub.SetEventCallCount()).To(Equal(0))
+					codeSizeAddress = string(res.Payload)
+				})
+
+				It("is not subject to the acl", func() {
+					res := setACL(`{"mspids":["OtherOrg"]}`)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+
+					stub.GetArgsReturns([][]byte{[]byte(codeSizeAddress), []byte(strings.Repeat("0", 24) + contractAddress)})
+					res = evmcc.Invoke(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+					Expect(res.Payload).To(HaveLen(32))
+					Expect(res.Payload).ToNot(Equal(make([]byte, 32)))
+				})
+			})
+
+			Context("when the creator is not an admin", func() {
+				BeforeEach(func() {
+					stub.GetArgsReturns([][]byte{[]byte(`{"admins":["OtherOrg"]}`)})
+					res := evmcc.Init(stub)
+					Expect(res.Status).To(Equal(int32(shim.OK)))
+				})
+
+				It("cannot deploy a contract with an acl", func() {
+					res := deploy(`{"mspids":["TestOrg"]}`)
+					Expect(res.Status).To(Equal(int32(shim.ERROR)))
+					Expect(res.Message).To(ContainSubstring("TestOrg is not an admin of this instance"))
 				})
+
+				It("cannot update the acl", func() {
+					res := setACL(`{"mspids":["OtherOrg"]}`)
+					Expect(res.Status).To(Equal(int32(shim.ERROR)))
+					Expect(res.Message).To(ContainSubstring("TestOrg is not an admin of this instance"))
+				})
+			})
+		})
+
+		Context("when a contract reads the transaction context", func() {
+			call := func(precompile string, input string) string {
+				stub.GetArgsReturns([][]byte{[]byte(crypto.ZeroAddress.String()), forwarderCode(precompile)})
+				res := evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+
+				stub.GetArgsReturns([][]byte{res.Payload, []byte(input)})
+				res = evmcc.Invoke(stub)
+				Expect(res.Status).To(Equal(int32(shim.OK)))
+				return hex.EncodeToString(res.Payload)
+			}
+
+			// abi.encode(string) of a short string
+			encodeString := func(s string) string {
+				padded := make([]byte, 32)
+				copy(padded, s)
+				return "0000000000000000000000000000000000000000000000000000000000000020" +
+					fmt.Sprintf("%064x", len(s)) + hex.EncodeToString(padded)
+			}
+
+			BeforeEach(func() {
+				stub.GetTxIDReturns("txid")
+				stub.GetChannelIDReturns("mychannel")
+				stub.GetTransientReturns(map[string][]byte{"secret": []byte("shh")}, nil)
+			})
+
+			It("returns the MSP ID of the creator", func() {
+				Expect(call("0fab02", "")).To(Equal(encodeString("TestOrg")))
+			})
+
+			It("returns the transaction ID", func() {
+				Expect(call("0fab04", "")).To(Equal(encodeString("txid")))
+			})
+
+			It("returns the channel ID", func() {
+				Expect(call("0fab05", "")).To(Equal(encodeString("mychannel")))
+			})
+
+			It("returns the values of the transient map", func() {
+				Expect(call("0fab06", encodeString("secret"))).To(Equal(
+					"0000000000000000000000000000000000000000000000000000000000000001" +
+						"0000000000000000000000000000000000000000000000000000000000000040" +
+						"0000000000000000000000000000000000000000000000000000000000000003" +
+						"7368680000000000000000000000000000000000000000000000000000000000"))
+
+				Expect(call("0fab06", encodeString("other"))).To(Equal(
+					"0000000000000000000000000000000000000000000000000000000000000000" +
+						"0000000000000000000000000000000000000000000000000000000000000040" +
+						"0000000000000000000000000000000000000000000000000000000000000000"))
+			})
+
+			It("reports attributes missing from the certificate", func() {
+				Expect(call("0fab03", encodeString("role"))).To(Equal(
+					"0000000000000000000000000000000000000000000000000000000000000000" +
+						"0000000000000000000000000000000000000000000000000000000000000040" +
+						"0000000000000000000000000000000000000000000000000000000000000000"))
 			})
 		})
 	})
 })
 
+// forwarderCode returns the deploy code of a hand assembled contract whose
+// runtime code forwards its input to the precompile at the given hex encoded
+// 3 bytes address, and returns the output of the precompile.
+func forwarderCode(precompile string) []byte {
+	return []byte("6020600c60003960206000f336600060003760006000366000600062" + precompile + "5af1503d600060003e3d6000f3")
+}
+
+// proxyCode returns the deployment code of a contract forwarding its call
+// data to the contract at the hex encoded address, and returning the output
+// of the call.
+func proxyCode(address string) []byte {
+	return []byte("6031600c60003960316000f3366000600037600060003660006000" + "73" + address + "5af1503d600060003e3d6000f3")
+}
+
 // TODO: This is copied from evmcc. Consider moving this to an util pkg
 func identityToAddr(id []byte) (crypto.Address, error) {
 	bl, _ := pem.Decode(id)
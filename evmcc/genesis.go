@@ -0,0 +1,94 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// genesisKey holds the accounts the instance was bootstrapped with.
+const genesisKey = "evmcc.genesis"
+
+// Genesis is the document given to Init. It is the configuration of the
+// instance, along with the accounts the instance starts with, so that a bare
+// configuration is a valid genesis.
+type Genesis struct {
+	Config
+	statemanager.Genesis
+}
+
+// parseGenesis decodes a genesis document. Configuration fields that are not
+// set keep their default value.
+func parseGenesis(doc []byte) (*Genesis, error) {
+	g := &Genesis{Config: *defaultConfig()}
+	if err := json.Unmarshal(doc, g); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal genesis: %s", err)
+	}
+
+	if err := g.Config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %s", err)
+	}
+
+	return g, nil
+}
+
+// applyGenesis creates the accounts of the genesis and stores them, so that
+// they can be read back. Accounts can only be created once per instance, an
+// upgrade may only change the configuration.
+func applyGenesis(stub shim.ChaincodeStubInterface, g *Genesis) error {
+	if len(g.Accounts) == 0 {
+		return nil
+	}
+
+	applied, err := stub.GetState(genesisKey)
+	if err != nil {
+		return fmt.Errorf("failed to get genesis: %s", err)
+	}
+
+	if len(applied) != 0 {
+		return fmt.Errorf("genesis accounts have already been applied")
+	}
+
+	if err = g.Genesis.Apply(statemanager.NewStateManager(stub, g.Config.stateOptions()...)); err != nil {
+		return err
+	}
+
+	doc, err := json.Marshal(g.Genesis)
+	if err != nil {
+		return fmt.Errorf("failed to marshal genesis: %s", err)
+	}
+
+	return stub.PutState(genesisKey, doc)
+}
+
+// getGenesis returns the active configuration of the instance along with the
+// accounts it was bootstrapped with.
+func getGenesis(stub shim.ChaincodeStubInterface) (*Genesis, error) {
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Genesis{Config: *cfg}
+
+	doc, err := stub.GetState(genesisKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get genesis: %s", err)
+	}
+
+	if len(doc) != 0 {
+		if err = json.Unmarshal(doc, &g.Genesis); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal genesis: %s", err)
+		}
+	}
+
+	return g, nil
+}
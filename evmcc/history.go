@@ -0,0 +1,123 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-evm/evmerrors"
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/common"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// historical runs an inspection function on the state as it was at a point
+// of the ledger, given as a Fabric block number or transaction id:
+// 'at, point, function, args...'
+func (evmcc *EvmChaincode) historical(stub shim.ChaincodeStubInterface, point []byte, args [][]byte) pb.Response {
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	locate := transactionLocator(stub)
+	at, err := historyPosition(locate, string(point))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	state := statemanager.NewHistoricalStateManager(stub, at, locate, cfg.stateOptions()...)
+
+	switch {
+	case len(args) == 2 && string(args[0]) == "getCode":
+		return evmcc.getCode(state, stub, args[1])
+	case len(args) == 2 && string(args[0]) == "getBalance":
+		return evmcc.getBalance(state, args[1])
+	case len(args) == 2 && string(args[0]) == "getNonce":
+		return evmcc.getNonce(state, args[1])
+	case len(args) == 2 && string(args[0]) == "dumpAccount":
+		return evmcc.dumpAccount(state, args[1])
+	case len(args) == 3 && string(args[0]) == "getStorageAt":
+		return evmcc.getStorageAt(state, args[1], args[2])
+	}
+
+	return errorResponse(evmerrors.CodeInvalidRequest, "%s cannot be run at a point in time", args[0])
+}
+
+// historyPosition returns the position in the ledger the state is read at,
+// for a point given as a block number or a transaction id. The state at a
+// block is the state once every transaction of the block was committed, and
+// the state at a transaction includes its writes.
+func historyPosition(locate statemanager.Locator, point string) (statemanager.Position, error) {
+	if txID, err := hex.DecodeString(point); err == nil && len(txID) == 32 {
+		at, err := locate(point)
+		if err != nil {
+			return statemanager.Position{}, evmerrors.New(evmerrors.CodeNotFound, "transaction %s not found: %s", point, err)
+		}
+		return at, nil
+	}
+
+	number, err := strconv.ParseUint(point, 0, 64)
+	if err != nil {
+		return statemanager.Position{}, evmerrors.New(evmerrors.CodeDecoding, "invalid point in time %s, expected a block number or a transaction id", point)
+	}
+
+	return statemanager.EndOfBlock(number), nil
+}
+
+// transactionLocator locates the transactions of the ledger in the block the
+// query system chaincode returns for them.
+func transactionLocator(stub shim.ChaincodeStubInterface) statemanager.Locator {
+	return func(txID string) (statemanager.Position, error) {
+		res := stub.InvokeChaincode("qscc", [][]byte{[]byte("GetBlockByTxID"), []byte(stub.GetChannelID()), []byte(txID)}, "")
+		if res.Status != shim.OK {
+			return statemanager.Position{}, fmt.Errorf("failed to get the block of transaction %s: %s", txID, res.Message)
+		}
+
+		block := &common.Block{}
+		if err := proto.Unmarshal(res.Payload, block); err != nil {
+			return statemanager.Position{}, fmt.Errorf("failed to unmarshal the block of transaction %s: %s", txID, err)
+		}
+
+		for index, data := range block.GetData().GetData() {
+			id, err := envelopeTxID(data)
+			if err != nil {
+				return statemanager.Position{}, fmt.Errorf("failed to read block %d: %s", block.GetHeader().GetNumber(), err)
+			}
+
+			if id == txID {
+				return statemanager.Position{Block: block.GetHeader().GetNumber(), Index: index}, nil
+			}
+		}
+
+		return statemanager.Position{}, fmt.Errorf("transaction %s is not in block %d", txID, block.GetHeader().GetNumber())
+	}
+}
+
+// envelopeTxID returns the id of the transaction of a block envelope.
+func envelopeTxID(data []byte) (string, error) {
+	env := &common.Envelope{}
+	if err := proto.Unmarshal(data, env); err != nil {
+		return "", err
+	}
+
+	payload := &common.Payload{}
+	if err := proto.Unmarshal(env.GetPayload(), payload); err != nil {
+		return "", err
+	}
+
+	chdr := &common.ChannelHeader{}
+	if err := proto.Unmarshal(payload.GetHeader().GetChannelHeader(), chdr); err != nil {
+		return "", err
+	}
+
+	return chdr.GetTxId(), nil
+}
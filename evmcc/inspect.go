@@ -0,0 +1,179 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/burrow/binary"
+	"github.com/hyperledger/fabric-chaincode-evm/envelope"
+	"github.com/hyperledger/fabric-chaincode-evm/evmerrors"
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// accountDump is the JSON form of an account returned by dumpAccount.
+type accountDump struct {
+	statemanager.GenesisAccount
+	Sequence uint64                         `json:"sequence"`
+	Metadata *statemanager.ContractMetadata `json:"metadata,omitempty"`
+}
+
+// storagePageSize is the number of storage slots listStorage returns at
+// once.
+const storagePageSize = 100
+
+// storagePage is a page of the storage of a contract returned by
+// listStorage. Bookmark is the bookmark of the next page, empty on the last
+// page.
+type storagePage struct {
+	Storage  []statemanager.StorageSlot `json:"storage"`
+	Bookmark string                     `json:"bookmark,omitempty"`
+}
+
+// accountPermissions is the JSON form of the permissions of an account, the
+// base permissions set on it and its roles.
+type accountPermissions struct {
+	Permissions map[string]bool `json:"permissions"`
+	Roles       []string        `json:"roles"`
+}
+
+// getStorageAt returns the hex encoded value of a storage slot of a contract.
+func (evmcc *EvmChaincode) getStorageAt(state statemanager.StateManager, address, slot []byte) pb.Response {
+	addr, err := parseAddress(address)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := envelope.ParseData(string(slot))
+	if err != nil || len(key) > binary.Word256Length {
+		return errorResponse(evmerrors.CodeDecoding, "invalid storage slot %s", string(slot))
+	}
+
+	value := state.GetStorage(addr, binary.LeftPadWord256(key))
+	if err = state.Error(); err != nil {
+		return shim.Error(fmt.Sprintf("failed to get storage: %s", err.Error()))
+	}
+
+	return shim.Success([]byte(hex.EncodeToString(value.Bytes())))
+}
+
+// getNonce returns the sequence of an account in decimal, the number of
+// contracts it deployed.
+func (evmcc *EvmChaincode) getNonce(state statemanager.StateManager, address []byte) pb.Response {
+	addr, err := parseAddress(address)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	sequence := state.GetSequence(addr)
+	if err = state.Error(); err != nil {
+		return shim.Error(fmt.Sprintf("failed to get sequence: %s", err.Error()))
+	}
+
+	return shim.Success([]byte(strconv.FormatUint(sequence, 10)))
+}
+
+// getPermissions returns the permissions of an account as a JSON document.
+func (evmcc *EvmChaincode) getPermissions(state statemanager.StateManager, address []byte) pb.Response {
+	addr, err := parseAddress(address)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	acc, err := state.GetAccount(addr)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to get account: %s", err.Error()))
+	}
+
+	perms := accountPermissions{Permissions: map[string]bool{}, Roles: []string{}}
+	if acc != nil {
+		ga := statemanager.NewGenesisAccount(acc)
+		if ga.Permissions != nil {
+			perms.Permissions = ga.Permissions
+		}
+		if ga.Roles != nil {
+			perms.Roles = ga.Roles
+		}
+	}
+
+	doc, err := json.Marshal(perms)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to marshal permissions: %s", err.Error()))
+	}
+
+	return shim.Success(doc)
+}
+
+// dumpAccount returns an account, its code, permissions and contract
+// metadata as a JSON document.
+func (evmcc *EvmChaincode) dumpAccount(state statemanager.StateManager, address []byte) pb.Response {
+	addr, err := parseAddress(address)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	acc, err := state.GetAccount(addr)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to get account: %s", err.Error()))
+	}
+
+	if acc == nil {
+		return errorResponse(evmerrors.CodeNotFound, "no account at %s", addr)
+	}
+
+	metadata, err := state.GetMetadata(addr)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to get metadata: %s", err.Error()))
+	}
+
+	doc, err := json.Marshal(accountDump{
+		GenesisAccount: statemanager.NewGenesisAccount(acc),
+		Sequence:       acc.Sequence,
+		Metadata:       metadata,
+	})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to marshal account: %s", err.Error()))
+	}
+
+	return shim.Success(doc)
+}
+
+// listStorage returns the storage slots of a contract from bookmark, a page
+// at a time, as a JSON document.
+func (evmcc *EvmChaincode) listStorage(state statemanager.StateManager, stub shim.ChaincodeStubInterface, address, bookmark []byte) pb.Response {
+	addr, err := parseAddress(address)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	metadata, err := state.GetMetadata(addr)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to get metadata: %s", err.Error()))
+	}
+
+	var collection string
+	if metadata != nil {
+		collection = metadata.Collection
+	}
+
+	slots, next, err := statemanager.ListStorage(stub, addr, collection, string(bookmark), storagePageSize)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to list storage: %s", err.Error()))
+	}
+
+	doc, err := json.Marshal(storagePage{Storage: slots, Bookmark: next})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to marshal storage: %s", err.Error()))
+	}
+
+	return shim.Success(doc)
+}
@@ -0,0 +1,160 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-evm/evmerrors"
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// instanceObjectType is the object type of the composite keys registering
+// the instances created in the default one.
+const instanceObjectType = "evmcc.instance"
+
+// instanceRecord registers an instance, along with the transaction that
+// created it.
+type instanceRecord struct {
+	ID   string `json:"id"`
+	TxID string `json:"txId"`
+}
+
+// instance runs a function on the state of an instance, isolated from the
+// default one and from the other instances: 'instance, id, function, args...'
+func (evmcc *EvmChaincode) instance(stub shim.ChaincodeStubInterface, id []byte, args [][]byte) pb.Response {
+	switch string(args[0]) {
+	case "instance", "createInstance", "listInstances":
+		return errorResponse(evmerrors.CodeInvalidRequest, "%s cannot be run in an instance", args[0])
+	}
+
+	record, err := getInstance(stub, string(id))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if record == nil {
+		return errorResponse(evmerrors.CodeNotFound, "instance %s does not exist", string(id))
+	}
+
+	return evmcc.invoke(statemanager.NewNamespaceStub(stub, record.ID), args)
+}
+
+// createInstance creates an instance from a genesis document, the way Init
+// does for the default instance. The instance has its own configuration, so
+// its admins, gas limit and address scheme are the ones of its genesis. It is
+// restricted to the admins of the default instance.
+func (evmcc *EvmChaincode) createInstance(stub shim.ChaincodeStubInterface, cfg *Config, id, doc []byte) pb.Response {
+	if err := checkAdmin(stub, cfg); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := statemanager.ValidateNamespace(string(id)); err != nil {
+		return errorResponse(evmerrors.CodeDecoding, "invalid instance id: %s", err.Error())
+	}
+
+	existing, err := getInstance(stub, string(id))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if existing != nil {
+		return errorResponse(evmerrors.CodeAlreadyExists, "instance %s already exists", string(id))
+	}
+
+	genesis, err := parseGenesis(doc)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	instanceStub := statemanager.NewNamespaceStub(stub, string(id))
+	if err = applyGenesis(instanceStub, genesis); err != nil {
+		return shim.Error(fmt.Sprintf("failed to apply genesis: %s", err.Error()))
+	}
+
+	if err = putConfig(instanceStub, &genesis.Config); err != nil {
+		return shim.Error(fmt.Sprintf("failed to store config: %s", err.Error()))
+	}
+
+	if err = putInstance(stub, &instanceRecord{ID: string(id), TxID: stub.GetTxID()}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(id)
+}
+
+// listInstances returns the instances created in the default one, in the
+// order of their ids.
+func (evmcc *EvmChaincode) listInstances(stub shim.ChaincodeStubInterface) pb.Response {
+	iter, err := stub.GetStateByPartialCompositeKey(instanceObjectType, []string{})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to list instances: %s", err.Error()))
+	}
+	defer iter.Close()
+
+	instances := []instanceRecord{}
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("failed to list instances: %s", err.Error()))
+		}
+
+		var record instanceRecord
+		if err = json.Unmarshal(kv.Value, &record); err != nil {
+			return shim.Error(fmt.Sprintf("failed to unmarshal instance: %s", err.Error()))
+		}
+		instances = append(instances, record)
+	}
+
+	doc, err := json.Marshal(instances)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to marshal instances: %s", err.Error()))
+	}
+
+	return shim.Success(doc)
+}
+
+// getInstance returns the record of an instance, nil if it does not exist.
+func getInstance(stub shim.ChaincodeStubInterface, id string) (*instanceRecord, error) {
+	key, err := shim.CreateCompositeKey(instanceObjectType, []string{id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create instance key: %s", err)
+	}
+
+	doc, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance: %s", err)
+	}
+
+	if len(doc) == 0 {
+		return nil, nil
+	}
+
+	record := &instanceRecord{}
+	if err = json.Unmarshal(doc, record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal instance: %s", err)
+	}
+
+	return record, nil
+}
+
+func putInstance(stub shim.ChaincodeStubInterface, record *instanceRecord) error {
+	key, err := shim.CreateCompositeKey(instanceObjectType, []string{record.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create instance key: %s", err)
+	}
+
+	doc, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal instance: %s", err)
+	}
+
+	return stub.PutState(key, doc)
+}
@@ -0,0 +1,205 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-evm/evmerrors"
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// migrationResult reports the progress of a migration run over a part of the
+// world state. Next is the key to continue after, empty once done.
+type migrationResult struct {
+	Removed int    `json:"removed"`
+	Next    string `json:"next,omitempty"`
+}
+
+// storageMigrationResult reports the progress of the migration of storage
+// slots to composite keys. Next is the key to continue after, empty once
+// done.
+type storageMigrationResult struct {
+	Migrated int    `json:"migrated"`
+	Next     string `json:"next,omitempty"`
+}
+
+// removeOrphanedStorage deletes the storage slots left behind by accounts
+// removed before their storage was, in the public world state. It is
+// restricted to the admins of the instance and scans at most limit keys after
+// start.
+func (evmcc *EvmChaincode) removeOrphanedStorage(stub shim.ChaincodeStubInterface, cfg *Config, start, limit []byte) pb.Response {
+	if err := checkAdmin(stub, cfg); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	n, err := strconv.Atoi(string(limit))
+	if err != nil {
+		return errorResponse(evmerrors.CodeDecoding, "failed to parse limit: %s", err.Error())
+	}
+
+	removed, next, err := statemanager.RemoveOrphanedStorage(stub, string(start), n)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to remove orphaned storage: %s", err.Error()))
+	}
+
+	doc, err := json.Marshal(migrationResult{Removed: removed, Next: next})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to marshal result: %s", err.Error()))
+	}
+
+	return shim.Success(doc)
+}
+
+// migrateStorage moves the storage slots of contracts from the keys used by
+// earlier versions of the chaincode to composite keys, in the public world
+// state. It is restricted to the admins of the instance and scans at most
+// limit keys after start.
+func (evmcc *EvmChaincode) migrateStorage(stub shim.ChaincodeStubInterface, cfg *Config, start, limit []byte) pb.Response {
+	if err := checkAdmin(stub, cfg); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	n, err := strconv.Atoi(string(limit))
+	if err != nil {
+		return errorResponse(evmerrors.CodeDecoding, "failed to parse limit: %s", err.Error())
+	}
+
+	migrated, next, err := statemanager.MigrateStorage(stub, string(start), n)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to migrate storage: %s", err.Error()))
+	}
+
+	doc, err := json.Marshal(storageMigrationResult{Migrated: migrated, Next: next})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to marshal result: %s", err.Error()))
+	}
+
+	return shim.Success(doc)
+}
+
+// scanCollectionStorage lists the storage slots kept in a collection at the
+// keys used by earlier versions of the chaincode, for
+// removeOrphanedCollectionStorage and migrateCollectionStorage to be given.
+// It reads private data with a range query, which Fabric only allows in
+// transactions that do not write, so it is meant to be queried. It is
+// restricted to the admins of the instance and scans at most limit keys after
+// start.
+func (evmcc *EvmChaincode) scanCollectionStorage(stub shim.ChaincodeStubInterface, cfg *Config, collection, start, limit []byte) pb.Response {
+	if err := checkAdmin(stub, cfg); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	n, err := strconv.Atoi(string(limit))
+	if err != nil {
+		return errorResponse(evmerrors.CodeDecoding, "failed to parse limit: %s", err.Error())
+	}
+
+	scan, err := statemanager.ScanCollectionStorage(stub, string(collection), string(start), n)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to scan collection storage: %s", err.Error()))
+	}
+
+	doc, err := json.Marshal(scan)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to marshal result: %s", err.Error()))
+	}
+
+	return shim.Success(doc)
+}
+
+// removeOrphanedCollectionStorage deletes the comma separated slot keys given
+// from a collection, as listed by scanCollectionStorage, when their accounts
+// no longer exist. It is restricted to the admins of the instance.
+func (evmcc *EvmChaincode) removeOrphanedCollectionStorage(stub shim.ChaincodeStubInterface, cfg *Config, collection, keys []byte) pb.Response {
+	if err := checkAdmin(stub, cfg); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	removed, err := statemanager.RemoveOrphanedCollectionStorage(stub, string(collection), splitList(keys))
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to remove orphaned storage: %s", err.Error()))
+	}
+
+	doc, err := json.Marshal(migrationResult{Removed: removed})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to marshal result: %s", err.Error()))
+	}
+
+	return shim.Success(doc)
+}
+
+// migrateCollectionStorage moves the comma separated slot keys given to
+// composite keys in a collection, as listed by scanCollectionStorage, when
+// their accounts exist. It is restricted to the admins of the instance.
+func (evmcc *EvmChaincode) migrateCollectionStorage(stub shim.ChaincodeStubInterface, cfg *Config, collection, keys []byte) pb.Response {
+	if err := checkAdmin(stub, cfg); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	migrated, err := statemanager.MigrateCollectionStorage(stub, string(collection), splitList(keys))
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to migrate storage: %s", err.Error()))
+	}
+
+	doc, err := json.Marshal(storageMigrationResult{Migrated: migrated})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to marshal result: %s", err.Error()))
+	}
+
+	return shim.Success(doc)
+}
+
+// splitList splits a comma separated list, empty for an empty list.
+func splitList(list []byte) []string {
+	if len(list) == 0 {
+		return nil
+	}
+	return strings.Split(string(list), ",")
+}
+
+// compactStorage deletes the storage slots of a contract set to zero that
+// earlier versions of the chaincode kept in the world state. It is restricted
+// to the admins of the instance, and checks the comma separated slots given,
+// as listed by listStorage.
+func (evmcc *EvmChaincode) compactStorage(state statemanager.StateManager, stub shim.ChaincodeStubInterface, cfg *Config, address, slots []byte) pb.Response {
+	if err := checkAdmin(stub, cfg); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	addr, err := parseAddress(address)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	metadata, err := state.GetMetadata(addr)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to get metadata: %s", err.Error()))
+	}
+
+	var collection string
+	if metadata != nil {
+		collection = metadata.Collection
+	}
+
+	removed, err := statemanager.CompactStorage(stub, addr, collection, splitList(slots))
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to compact storage: %s", err.Error()))
+	}
+
+	doc, err := json.Marshal(migrationResult{Removed: removed})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to marshal result: %s", err.Error()))
+	}
+
+	return shim.Success(doc)
+}
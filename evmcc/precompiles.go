@@ -0,0 +1,221 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/burrow/binary"
+	"github.com/hyperledger/burrow/crypto"
+	"github.com/hyperledger/burrow/execution/errors"
+	"github.com/hyperledger/burrow/execution/evm"
+	"github.com/hyperledger/burrow/logging"
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Precompiles are native contracts giving EVM contracts access to Fabric.
+// They live at reserved addresses, away from the addresses of the Ethereum
+// and Burrow precompiles, and are called with ABI encoded arguments without
+// a function selector.
+var (
+	// InvokeChaincodeAddress takes (string chaincode, string function,
+	// bytes[] args) and returns (int32 status, bytes payload) of the
+	// invocation of a chaincode on the same channel. The invocation is not
+	// part of the frame calling it: the writes of the chaincode invoked stay
+	// in the transaction when the frame, or a frame below the one of the
+	// transaction, reverts, and are only discarded with the whole
+	// transaction.
+	InvokeChaincodeAddress = precompileAddress(0x01)
+	// CreatorMSPIDAddress returns (string mspid), the MSP ID of the creator
+	// of the transaction.
+	CreatorMSPIDAddress = precompileAddress(0x02)
+	// CreatorAttributeAddress takes (string name) and returns (bool found,
+	// string value), the value of an attribute of the certificate of the
+	// creator of the transaction.
+	CreatorAttributeAddress = precompileAddress(0x03)
+	// TxIDAddress returns (string txid), the Fabric transaction ID.
+	TxIDAddress = precompileAddress(0x04)
+	// ChannelIDAddress returns (string channel), the ID of the channel.
+	ChannelIDAddress = precompileAddress(0x05)
+	// TransientAddress takes (string key) and returns (bool found, bytes
+	// value), the value of a key of the transient map of the proposal.
+	TransientAddress = precompileAddress(0x06)
+)
+
+const (
+	// invokeChaincodeGas is the gas charged for the invocation of a
+	// chaincode.
+	invokeChaincodeGas uint64 = 1000
+	// txContextGas is the gas charged to read the transaction context.
+	txContextGas uint64 = 100
+)
+
+func init() {
+	registerPrecompile(InvokeChaincodeAddress, invokeChaincode)
+	registerPrecompile(CreatorMSPIDAddress, creatorMSPID)
+	registerPrecompile(CreatorAttributeAddress, creatorAttribute)
+	registerPrecompile(TxIDAddress, txID)
+	registerPrecompile(ChannelIDAddress, channelID)
+	registerPrecompile(TransientAddress, transient)
+}
+
+func precompileAddress(n byte) crypto.Address {
+	return crypto.AddressFromWord256(binary.LeftPadWord256([]byte{0x0f, 0xab, n}))
+}
+
+func registerPrecompile(address crypto.Address, fn evm.NativeContract) {
+	if !evm.RegisterNativeContract(address.Word256(), fn) {
+		panic(fmt.Sprintf("a native contract is already registered at %s", address))
+	}
+}
+
+// useGas charges the gas of a precompile.
+func useGas(gas *uint64, cost uint64) error {
+	if *gas < cost {
+		return errors.ErrorCodeInsufficientGas
+	}
+	*gas -= cost
+	return nil
+}
+
+// getStub returns the stub of the transaction running the vm. Precompiles
+// are registered once for all transactions, they reach the stub through the
+// state they are given.
+func getStub(state evm.Interface) (shim.ChaincodeStubInterface, error) {
+	sm, ok := state.(statemanager.StateManager)
+	if !ok {
+		return nil, fmt.Errorf("precompile called without a chaincode state")
+	}
+	return sm.Stub(), nil
+}
+
+func invokeChaincode(state evm.Interface, caller crypto.Address, input []byte, gas *uint64, logger *logging.Logger) ([]byte, error) {
+	if err := useGas(gas, invokeChaincodeGas); err != nil {
+		return nil, err
+	}
+
+	sm, ok := state.(statemanager.StateManager)
+	if !ok {
+		return nil, fmt.Errorf("precompile called without a chaincode state")
+	}
+
+	// The chaincode invoked may write, and its writes cannot be discarded
+	// with the frame. A frame that may still write cannot discard them
+	// either, a contract catching the revert of a call keeps the writes of
+	// the chaincodes invoked by it.
+	if sm.ReadOnly() {
+		return nil, errors.ErrorCodef(errors.ErrorCodeIllegalWrite,
+			"chaincodes cannot be invoked from a read-only call")
+	}
+	stub := sm.Stub()
+
+	name, err := unpackString(input, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode chaincode name: %s", err)
+	}
+
+	function, err := unpackBytes(input, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode function: %s", err)
+	}
+
+	args, err := unpackBytesArray(input, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode args: %s", err)
+	}
+
+	// An empty channel is the channel of the transaction
+	res := stub.InvokeChaincode(name, append([][]byte{function}, args...), "")
+
+	output := append(packInt(int64(res.Status)), packUint(64)...)
+	return append(output, packBytes(res.Payload)...), nil
+}
+
+// txContext charges the gas of the precompiles reading the transaction
+// context, and returns the stub.
+func txContext(state evm.Interface, gas *uint64) (shim.ChaincodeStubInterface, error) {
+	if err := useGas(gas, txContextGas); err != nil {
+		return nil, err
+	}
+	return getStub(state)
+}
+
+func creatorMSPID(state evm.Interface, caller crypto.Address, input []byte, gas *uint64, logger *logging.Logger) ([]byte, error) {
+	stub, err := txContext(state, gas)
+	if err != nil {
+		return nil, err
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MSP ID: %s", err)
+	}
+
+	return packString(mspID), nil
+}
+
+func creatorAttribute(state evm.Interface, caller crypto.Address, input []byte, gas *uint64, logger *logging.Logger) ([]byte, error) {
+	stub, err := txContext(state, gas)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := unpackString(input, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode attribute name: %s", err)
+	}
+
+	value, found, err := cid.GetAttributeValue(stub, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attribute %s: %s", name, err)
+	}
+
+	output := append(packBool(found), packUint(64)...)
+	return append(output, packBytes([]byte(value))...), nil
+}
+
+func txID(state evm.Interface, caller crypto.Address, input []byte, gas *uint64, logger *logging.Logger) ([]byte, error) {
+	stub, err := txContext(state, gas)
+	if err != nil {
+		return nil, err
+	}
+
+	return packString(stub.GetTxID()), nil
+}
+
+func channelID(state evm.Interface, caller crypto.Address, input []byte, gas *uint64, logger *logging.Logger) ([]byte, error) {
+	stub, err := txContext(state, gas)
+	if err != nil {
+		return nil, err
+	}
+
+	return packString(stub.GetChannelID()), nil
+}
+
+func transient(state evm.Interface, caller crypto.Address, input []byte, gas *uint64, logger *logging.Logger) ([]byte, error) {
+	stub, err := txContext(state, gas)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := unpackString(input, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode transient key: %s", err)
+	}
+
+	transientMap, err := stub.GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transient map: %s", err)
+	}
+
+	value, found := transientMap[key]
+
+	output := append(packBool(found), packUint(64)...)
+	return append(output, packBytes(value)...), nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/burrow/execution/errors"
+	"github.com/hyperledger/fabric-chaincode-evm/evmerrors"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var (
+	// keccak256("Error(string)")[:4]
+	errorSelector = []byte{0x08, 0xc3, 0x79, 0xa0}
+	// keccak256("Panic(uint256)")[:4]
+	panicSelector = []byte{0x4e, 0x48, 0x7b, 0x71}
+)
+
+// executionError builds the response returned when the vm fails to run a
+// contract. Failures clients can act on are returned as an evmerrors.Error,
+// reverted executions carrying their return data so that clients can decode
+// the revert reason.
+func executionError(msg string, output []byte, err error) pb.Response {
+	coded, ok := err.(errors.CodedError)
+	if !ok {
+		return shim.Error(fmt.Sprintf("%s: %s", msg, err.Error()))
+	}
+
+	switch coded.ErrorCode() {
+	case errors.ErrorCodeExecutionReverted:
+	case errors.ErrorCodeInsufficientGas:
+		return errorResponse(evmerrors.CodeOutOfGas, "%s: %s", msg, err.Error())
+	case errors.ErrorCodePermissionDenied, errors.ErrorCodeIllegalWrite:
+		return errorResponse(evmerrors.CodePermissionDenied, "%s: %s", msg, err.Error())
+	default:
+		return shim.Error(fmt.Sprintf("%s: %s", msg, err.Error()))
+	}
+
+	revertErr := &evmerrors.Error{
+		Code:    evmerrors.CodeRevert,
+		Message: "execution reverted",
+		Data:    hex.EncodeToString(output),
+	}
+
+	if reason, ok := decodeRevertReason(output); ok {
+		revertErr.Reason = reason
+		revertErr.Message += ": " + reason
+	}
+
+	return shim.Error(revertErr.Error())
+}
+
+// errorResponse returns a failed response whose message is an
+// evmerrors.Error with the given code.
+func errorResponse(code evmerrors.Code, format string, args ...interface{}) pb.Response {
+	return shim.Error(evmerrors.New(code, format, args...).Error())
+}
+
+// decodeRevertReason decodes the return data of a reverted execution. It
+// understands the `Error(string)` and `Panic(uint256)` encodings emitted by
+// Solidity, any other data is left for the caller to decode.
+func decodeRevertReason(output []byte) (string, bool) {
+	if len(output) < 4 {
+		return "", false
+	}
+
+	selector, data := output[:4], output[4:]
+	switch {
+	case bytes.Equal(selector, errorSelector):
+		reason, err := unpackString(data, 0)
+		if err != nil {
+			return "", false
+		}
+		return reason, true
+	case bytes.Equal(selector, panicSelector):
+		if len(data) < 32 {
+			return "", false
+		}
+		return fmt.Sprintf("panic: 0x%x", new(big.Int).SetBytes(data[:32])), true
+	default:
+		return "", false
+	}
+}
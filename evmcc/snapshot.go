@@ -0,0 +1,86 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-evm/evmerrors"
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// importResult reports the number of accounts imported from a snapshot.
+type importResult struct {
+	Imported int `json:"imported"`
+}
+
+// exportState returns a snapshot of at most limit accounts from the address
+// start, along with their code, storage and metadata, as a JSON document. The
+// snapshot gives the address of the next account whenever there is one.
+func (evmcc *EvmChaincode) exportState(state statemanager.StateManager, start, limit []byte) pb.Response {
+	n, err := strconv.Atoi(string(limit))
+	if err != nil {
+		return errorResponse(evmerrors.CodeDecoding, "failed to parse limit: %s", err.Error())
+	}
+
+	snapshot, err := statemanager.ExportState(state, string(start), n)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to export state: %s", err.Error()))
+	}
+
+	doc, err := json.Marshal(snapshot)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to marshal snapshot: %s", err.Error()))
+	}
+
+	return shim.Success(doc)
+}
+
+// digestState returns the digest of the whole state of the instance, to check
+// that an instance importing its snapshots ends up with the same state, as a
+// JSON document.
+func (evmcc *EvmChaincode) digestState(stub shim.ChaincodeStubInterface) pb.Response {
+	digest, err := statemanager.DigestState(statemanager.NewReadOnlyStateManager(stub))
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to digest state: %s", err.Error()))
+	}
+
+	doc, err := json.Marshal(digest)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to marshal digest: %s", err.Error()))
+	}
+
+	return shim.Success(doc)
+}
+
+// importState creates the accounts of a snapshot exported from another
+// instance. It is restricted to the admins of the instance.
+func (evmcc *EvmChaincode) importState(state statemanager.StateManager, stub shim.ChaincodeStubInterface, cfg *Config, doc []byte) pb.Response {
+	if err := checkAdmin(stub, cfg); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var snapshot statemanager.Snapshot
+	if err := json.Unmarshal(doc, &snapshot); err != nil {
+		return errorResponse(evmerrors.CodeDecoding, "failed to unmarshal snapshot: %s", err.Error())
+	}
+
+	if err := snapshot.Apply(state); err != nil {
+		return shim.Error(fmt.Sprintf("failed to import state: %s", err.Error()))
+	}
+
+	res, err := json.Marshal(importResult{Imported: len(snapshot.Accounts)})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to marshal result: %s", err.Error()))
+	}
+
+	return shim.Success(res)
+}
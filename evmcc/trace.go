@@ -0,0 +1,350 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/burrow/acm/state"
+	"github.com/hyperledger/burrow/binary"
+	"github.com/hyperledger/burrow/crypto"
+	"github.com/hyperledger/burrow/execution/evm"
+	"github.com/hyperledger/burrow/logging"
+	"github.com/hyperledger/burrow/logging/structure"
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// traceResult is the trace of a contract call, in the form of the
+// debug_traceCall results of Ethereum clients.
+type traceResult struct {
+	Gas         uint64       `json:"gas"`
+	Failed      bool         `json:"failed"`
+	Error       string       `json:"error,omitempty"`
+	ReturnValue string       `json:"returnValue"`
+	Steps       []*traceStep `json:"structLogs"`
+}
+
+// traceStep is the state of the vm before it runs an opcode, along with the
+// storage the opcode accessed. StackTop is the hex encoded value on top of
+// the stack, left out when it is not known. Detail is the description burrow
+// gives of the operands and the result of the opcode, e.g. the value pushed
+// on the stack.
+type traceStep struct {
+	PC        uint64          `json:"pc"`
+	Op        string          `json:"op"`
+	Gas       uint64          `json:"gas"`
+	Depth     int             `json:"depth"`
+	StackSize int             `json:"stackSize"`
+	StackTop  string          `json:"stackTop,omitempty"`
+	Detail    string          `json:"detail,omitempty"`
+	Storage   []storageAccess `json:"storage,omitempty"`
+}
+
+// stepFormat is the message burrow v0.23 logs, with DebugOpcodes, before it
+// runs an opcode: vm.Debugf("(pc) %-3d (op) %-14s (st) %-4d (gas) %d", ...).
+// The trace tests pin it, along with the " => 0x%X" detail of PUSH opcodes.
+const stepFormat = "(pc) %d (op) %s (st) %d (gas) %d"
+
+// pushedPrefix starts the detail burrow logs for the opcodes pushing a value
+// it reports, e.g. "=> 0x60" for PUSH1 0x60.
+const pushedPrefix = "=> 0x"
+
+// storageAccess is a read or a write of a storage slot. Addresses, keys and
+// values are hex encoded.
+type storageAccess struct {
+	Op      string `json:"op"`
+	Address string `json:"address"`
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+}
+
+// tracer builds the trace of a call from the opcodes the vm reports when
+// DebugOpcodes is set, and from the storage accesses of the state.
+type tracer struct {
+	result traceResult
+	// Burrow does not report the call depth, it is followed from the calls
+	// and returns of the trace.
+	depth    int
+	entering bool
+	leaving  bool
+	// Burrow only reports the size of the stack, its values are followed
+	// for each call depth from the opcodes run. Values burrow does not
+	// report are empty.
+	stacks [][]string
+}
+
+func newTracer() *tracer {
+	return &tracer{result: traceResult{Steps: []*traceStep{}}}
+}
+
+// logger returns a burrow logger handing the messages of the vm to the
+// tracer.
+func (t *tracer) logger() *logging.Logger {
+	return logging.NewLogger(t)
+}
+
+// Log implements the go-kit logger interface, burrow writes one message when
+// an opcode is about to run and more messages describing what it did.
+func (t *tracer) Log(keyvals ...interface{}) error {
+	var msg string
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvals[i] == structure.MessageKey {
+			msg, _ = keyvals[i+1].(string)
+		}
+	}
+
+	step := &traceStep{}
+	_, err := fmt.Sscanf(msg, stepFormat, &step.PC, &step.Op, &step.StackSize, &step.Gas)
+	if err == nil {
+		t.step(step)
+		return nil
+	}
+
+	if last := t.last(); last != nil {
+		last.Detail = strings.TrimSpace(last.Detail + " " + strings.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+func (t *tracer) step(step *traceStep) {
+	// The details of the previous opcode are complete, its effect on the
+	// stack is known
+	last := t.last()
+	if last != nil {
+		t.run(last)
+	}
+
+	if t.entering {
+		// Calls to accounts without code do not run any opcode
+		if step.PC == 0 {
+			t.depth++
+		}
+	} else if t.leaving {
+		t.depth--
+	}
+	t.entering, t.leaving = false, false
+
+	step.Depth = t.depth + 1
+	step.StackTop = t.stackTop(step, last == nil || step.Depth > last.Depth)
+	t.result.Steps = append(t.result.Steps, step)
+
+	switch step.Op {
+	case "CALL", "CALLCODE", "DELEGATECALL", "STATICCALL", "CREATE":
+		t.entering = true
+	case "STOP", "RETURN", "REVERT", "INVALID", "SELFDESTRUCT":
+		t.leaving = t.depth > 0
+	}
+}
+
+// stackTop returns the value on top of the stack of the call of step, once
+// the stack followed agrees with the size burrow reports. The stack of a call
+// that is entered starts empty.
+func (t *tracer) stackTop(step *traceStep, entered bool) string {
+	for len(t.stacks) < step.Depth {
+		t.stacks = append(t.stacks, nil)
+	}
+	if entered {
+		t.stacks = t.stacks[:step.Depth]
+		t.stacks[step.Depth-1] = nil
+	}
+
+	stack := t.stacks[step.Depth-1]
+	if len(stack) != step.StackSize {
+		// An opcode of unknown effect, the values are lost
+		stack = make([]string, step.StackSize)
+		t.stacks[step.Depth-1] = stack
+	}
+
+	if len(stack) == 0 {
+		return ""
+	}
+	return stack[len(stack)-1]
+}
+
+// run applies the opcode of step to the stack of its call. The value pushed
+// by an opcode is known when burrow reports it, and the values moved by DUP
+// and SWAP opcodes when they are known.
+func (t *tracer) run(step *traceStep) {
+	if len(t.stacks) < step.Depth {
+		return
+	}
+	stack := t.stacks[step.Depth-1]
+
+	if n, ok := opcodeIndex(step.Op, "DUP"); ok {
+		if n <= len(stack) {
+			stack = append(stack, stack[len(stack)-n])
+		}
+		t.stacks[step.Depth-1] = stack
+		return
+	}
+
+	if n, ok := opcodeIndex(step.Op, "SWAP"); ok {
+		if n < len(stack) {
+			top := len(stack) - 1
+			stack[top], stack[top-n] = stack[top-n], stack[top]
+		}
+		return
+	}
+
+	pops, pushes, ok := stackEffect(step.Op)
+	if !ok || pops > len(stack) {
+		return
+	}
+
+	stack = stack[:len(stack)-pops]
+	for i := 0; i < pushes; i++ {
+		stack = append(stack, "")
+	}
+	if pushes == 1 {
+		stack[len(stack)-1] = pushedValue(step.Detail)
+	}
+	t.stacks[step.Depth-1] = stack
+}
+
+// pushedValue returns the value burrow reports an opcode pushed, from its
+// detail, or an empty string when it is not reported.
+func pushedValue(detail string) string {
+	if !strings.HasPrefix(detail, pushedPrefix) {
+		return ""
+	}
+
+	value := strings.Fields(detail[len(pushedPrefix):])
+	if len(value) == 0 {
+		return ""
+	}
+
+	if _, err := hex.DecodeString(value[0]); err != nil {
+		return ""
+	}
+	return "0x" + strings.ToLower(value[0])
+}
+
+// opcodeIndex returns n for the opcodes named prefix followed by n, e.g. 2
+// for DUP2.
+func opcodeIndex(op, prefix string) (int, bool) {
+	if !strings.HasPrefix(op, prefix) {
+		return 0, false
+	}
+
+	var n int
+	if _, err := fmt.Sscanf(op[len(prefix):], "%d", &n); err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
+
+// stackEffect returns the number of values an opcode, other than DUP and SWAP
+// opcodes, pops from the stack and pushes on it.
+func stackEffect(op string) (int, int, bool) {
+	if _, ok := opcodeIndex(op, "PUSH"); ok {
+		return 0, 1, true
+	}
+
+	effect, ok := stackEffects[op]
+	return effect[0], effect[1], ok
+}
+
+// stackEffects are the numbers of values the opcodes pop from the stack and
+// push on it, by the names burrow gives them.
+var stackEffects = map[string][2]int{
+	"STOP": {0, 0}, "ADD": {2, 1}, "MUL": {2, 1}, "SUB": {2, 1}, "DIV": {2, 1},
+	"SDIV": {2, 1}, "MOD": {2, 1}, "SMOD": {2, 1}, "ADDMOD": {3, 1},
+	"MULMOD": {3, 1}, "EXP": {2, 1}, "SIGNEXTEND": {2, 1},
+
+	"LT": {2, 1}, "GT": {2, 1}, "SLT": {2, 1}, "SGT": {2, 1}, "EQ": {2, 1},
+	"ISZERO": {1, 1}, "AND": {2, 1}, "OR": {2, 1}, "XOR": {2, 1},
+	"NOT": {1, 1}, "BYTE": {2, 1}, "SHL": {2, 1}, "SHR": {2, 1}, "SAR": {2, 1},
+
+	"SHA3": {2, 1},
+
+	"ADDRESS": {0, 1}, "BALANCE": {1, 1}, "ORIGIN": {0, 1}, "CALLER": {0, 1},
+	"CALLVALUE": {0, 1}, "CALLDATALOAD": {1, 1}, "CALLDATASIZE": {0, 1},
+	"CALLDATACOPY": {3, 0}, "CODESIZE": {0, 1}, "CODECOPY": {3, 0},
+	"GASPRICE": {0, 1}, "EXTCODESIZE": {1, 1}, "EXTCODECOPY": {4, 0},
+	"RETURNDATASIZE": {0, 1}, "RETURNDATACOPY": {3, 0}, "EXTCODEHASH": {1, 1},
+
+	"BLOCKHASH": {1, 1}, "COINBASE": {0, 1}, "TIMESTAMP": {0, 1},
+	"NUMBER": {0, 1}, "DIFFICULTY": {0, 1}, "GASLIMIT": {0, 1},
+
+	"POP": {1, 0}, "MLOAD": {1, 1}, "MSTORE": {2, 0}, "MSTORE8": {2, 0},
+	"SLOAD": {1, 1}, "SSTORE": {2, 0}, "JUMP": {1, 0}, "JUMPI": {2, 0},
+	"PC": {0, 1}, "MSIZE": {0, 1}, "GAS": {0, 1}, "JUMPDEST": {0, 0},
+
+	"LOG0": {2, 0}, "LOG1": {3, 0}, "LOG2": {4, 0}, "LOG3": {5, 0},
+	"LOG4": {6, 0},
+
+	"CREATE": {3, 1}, "CALL": {7, 1}, "CALLCODE": {7, 1}, "RETURN": {2, 0},
+	"DELEGATECALL": {6, 1}, "CREATE2": {4, 1}, "STATICCALL": {6, 1},
+	"REVERT": {2, 0}, "INVALID": {0, 0}, "SELFDESTRUCT": {1, 0},
+}
+
+func (t *tracer) last() *traceStep {
+	if len(t.result.Steps) == 0 {
+		return nil
+	}
+	return t.result.Steps[len(t.result.Steps)-1]
+}
+
+func (t *tracer) storage(op string, address crypto.Address, key, value binary.Word256) {
+	if last := t.last(); last != nil {
+		last.Storage = append(last.Storage, storageAccess{
+			Op:      op,
+			Address: hex.EncodeToString(address.Bytes()),
+			Key:     hex.EncodeToString(key.Bytes()),
+			Value:   hex.EncodeToString(value.Bytes()),
+		})
+	}
+}
+
+// finish returns the trace of the call. A failed execution is reported in the
+// trace rather than as a chaincode error.
+func (t *tracer) finish(output []byte, gasUsed uint64, err error) pb.Response {
+	t.result.Gas = gasUsed
+	t.result.ReturnValue = hex.EncodeToString(output)
+	if err != nil {
+		t.result.Failed = true
+		t.result.Error = err.Error()
+	}
+
+	doc, err := json.Marshal(t.result)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to marshal trace: %s", err.Error()))
+	}
+
+	return shim.Success(doc)
+}
+
+// tracingState reports the storage accesses of the vm to the tracer.
+type tracingState struct {
+	statemanager.StateManager
+	tracer *tracer
+}
+
+func (t *tracer) wrap(st statemanager.StateManager) statemanager.StateManager {
+	return &tracingState{StateManager: st, tracer: t}
+}
+
+func (s *tracingState) NewCache(cacheOptions ...state.CacheOption) evm.Interface {
+	return s.tracer.wrap(s.StateManager.NewCache(cacheOptions...).(statemanager.StateManager))
+}
+
+func (s *tracingState) GetStorage(address crypto.Address, key binary.Word256) binary.Word256 {
+	value := s.StateManager.GetStorage(address, key)
+	s.tracer.storage("read", address, key, value)
+	return value
+}
+
+func (s *tracingState) SetStorage(address crypto.Address, key, value binary.Word256) {
+	s.StateManager.SetStorage(address, key, value)
+	s.tracer.storage("write", address, key, value)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	keccak "github.com/hyperledger/burrow/execution/evm/sha3"
+	"github.com/hyperledger/fabric-chaincode-evm/envelope"
+	"github.com/hyperledger/fabric-chaincode-evm/evmerrors"
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// UpgradeEventName is the name of the chaincode event emitted when the code
+// of a contract is upgraded.
+const UpgradeEventName = "evmcc.upgrade"
+
+// codeUpgrade is the payload of the upgrade event. Hashes are hex encoded
+// Keccak-256 hashes of the runtime code.
+type codeUpgrade struct {
+	Address          string `json:"address"`
+	PreviousCodeHash string `json:"previousCodeHash"`
+	CodeHash         string `json:"codeHash"`
+}
+
+// upgradeCode replaces the runtime code of a contract, keeping its storage,
+// and records the hash of the code it replaces in the contract metadata. It
+// is restricted to the admins of the instance.
+func (evmcc *EvmChaincode) upgradeCode(state statemanager.StateManager, stub shim.ChaincodeStubInterface, cfg *Config, address, code []byte) pb.Response {
+	if err := checkAdmin(stub, cfg); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	addr, err := parseAddress(address)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	newCode, err := envelope.ParseData(string(code))
+	if err != nil {
+		return errorResponse(evmerrors.CodeDecoding, "failed to decode code: %s", err.Error())
+	}
+
+	if len(newCode) == 0 {
+		return errorResponse(evmerrors.CodeInvalidRequest, "the code of a contract cannot be removed by an upgrade")
+	}
+
+	previousCode := state.GetCode(addr)
+	if len(previousCode) == 0 {
+		return errorResponse(evmerrors.CodeNotFound, "no contract at %s", addr)
+	}
+
+	metadata, err := state.GetMetadata(addr)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to get metadata: %s", err.Error()))
+	}
+
+	if metadata == nil {
+		metadata = &statemanager.ContractMetadata{}
+	}
+
+	upgrade := codeUpgrade{
+		Address:          hex.EncodeToString(addr.Bytes()),
+		PreviousCodeHash: hex.EncodeToString(keccak.Sha3(previousCode)),
+		CodeHash:         hex.EncodeToString(keccak.Sha3(newCode)),
+	}
+	metadata.PreviousCodeHashes = append(metadata.PreviousCodeHashes, upgrade.PreviousCodeHash)
+
+	state.UpgradeCode(addr, newCode)
+	if err = state.Error(); err != nil {
+		return shim.Error(fmt.Sprintf("failed to upgrade code: %s", err.Error()))
+	}
+
+	if err = state.SetMetadata(addr, metadata); err != nil {
+		return shim.Error(fmt.Sprintf("failed to set contract metadata: %s", err.Error()))
+	}
+
+	payload, err := json.Marshal(upgrade)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("failed to marshal upgrade: %s", err.Error()))
+	}
+
+	if err = stub.SetEvent(UpgradeEventName, payload); err != nil {
+		return shim.Error(fmt.Sprintf("failed to set upgrade event: %s", err.Error()))
+	}
+
+	return shim.Success(payload)
+}
@@ -0,0 +1,90 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package evmerrors
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Code classifies an Error, so that clients can handle a failure without
+// matching its message.
+type Code string
+
+const (
+	// CodeNotFound is returned when an account or a contract does not exist.
+	CodeNotFound Code = "not-found"
+	// CodeRevert is returned when the execution of a contract is reverted.
+	CodeRevert Code = "revert"
+	// CodeOutOfGas is returned when the execution of a contract runs out of
+	// gas.
+	CodeOutOfGas Code = "out-of-gas"
+	// CodeInvalidAddress is returned when an address cannot be decoded.
+	CodeInvalidAddress Code = "invalid-address"
+	// CodePermissionDenied is returned when the creator of the transaction is
+	// not allowed to perform it.
+	CodePermissionDenied Code = "permission-denied"
+	// CodeDecoding is returned when an argument of the transaction cannot be
+	// decoded.
+	CodeDecoding Code = "decoding"
+	// CodeInvalidRequest is returned when the arguments of the transaction are
+	// decoded but cannot be served together or by the instance, such as a
+	// deployment option given to a contract call, or value sent to an instance
+	// without balances.
+	CodeInvalidRequest Code = "invalid-request"
+	// CodeAlreadyExists is returned when the transaction would create
+	// something that already exists.
+	CodeAlreadyExists Code = "already-exists"
+)
+
+// Error is returned by the EVM chaincode, JSON encoded as the message of a
+// failed response, when a transaction fails for a known reason, such as a
+// reverted execution. Fab3 recovers it from the error returned by the Fabric
+// SDK so that it can hand the code and the revert data back to the client.
+type Error struct {
+	Code    Code   `json:"code,omitempty"`
+	Message string `json:"message"`
+	// Data is the hex encoded return data of a reverted execution. It holds
+	// the ABI encoded `Error(string)` or custom error of the contract.
+	Data string `json:"data,omitempty"`
+	// Reason is the decoded `Error(string)` revert reason, when present.
+	Reason string `json:"reason,omitempty"`
+}
+
+// New returns an Error with the given code and formatted message.
+func New(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return e.Message
+	}
+	return string(b)
+}
+
+// Parse looks for an Error encoded in msg. msg can either be the message of
+// the chaincode response, or an error built around it, such as the ones
+// returned by the Fabric SDK.
+func Parse(msg string) (*Error, bool) {
+	for i := strings.Index(msg, "{"); i >= 0; {
+		e := &Error{}
+		if err := json.NewDecoder(strings.NewReader(msg[i:])).Decode(e); err == nil && e.Message != "" {
+			return e, true
+		}
+
+		next := strings.Index(msg[i+1:], "{")
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+
+	return nil, false
+}
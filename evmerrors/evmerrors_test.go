@@ -0,0 +1,61 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package evmerrors_test
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-evm/evmerrors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Evmerrors", func() {
+	var evmErr *evmerrors.Error
+
+	BeforeEach(func() {
+		evmErr = &evmerrors.Error{
+			Message: "execution reverted: not the owner",
+			Data:    "08c379a0",
+			Reason:  "not the owner",
+		}
+	})
+
+	Describe("Parse", func() {
+		It("decodes the message of a chaincode response", func() {
+			parsed, ok := evmerrors.Parse(evmErr.Error())
+			Expect(ok).To(BeTrue())
+			Expect(parsed).To(Equal(evmErr))
+		})
+
+		Context("when the message has been wrapped", func() {
+			It("finds the encoded error", func() {
+				wrapped := fmt.Sprintf("Transaction processing for endorser [peer0:7051]: Chaincode status Code: (500) UNKNOWN. Description: %s", evmErr.Error())
+
+				parsed, ok := evmerrors.Parse(wrapped)
+				Expect(ok).To(BeTrue())
+				Expect(parsed).To(Equal(evmErr))
+			})
+		})
+
+		Context("when the error has a code", func() {
+			It("decodes the code", func() {
+				parsed, ok := evmerrors.Parse(evmerrors.New(evmerrors.CodeNotFound, "no contract at %s", "fab0").Error())
+				Expect(ok).To(BeTrue())
+				Expect(parsed).To(Equal(&evmerrors.Error{Code: evmerrors.CodeNotFound, Message: "no contract at fab0"}))
+			})
+		})
+
+		Context("when the message does not contain an error", func() {
+			It("returns false", func() {
+				_, ok := evmerrors.Parse("failed to decode input bytes: {not json}")
+				Expect(ok).To(BeFalse())
+			})
+		})
+	})
+})
@@ -30,6 +30,7 @@ const usage = `Fab3 uses environment variables to be able to start communicating
 
 	Other Environment Variables:
 	  PORT - Port the Fab3 will be running on. Default is 5000
+	  FABPROXY_DEBUG - Set to true to serve the debug namespace, e.g. debug_traceCall
 	`
 
 var logger *zap.SugaredLogger
@@ -44,6 +45,7 @@ func main() {
 	ch := grabEnvVar("FABPROXY_CHANNEL", true)
 	ccid := grabEnvVar("FABPROXY_CCID", true)
 	port := grabEnvVar("PORT", false)
+	debug := grabEnvVar("FABPROXY_DEBUG", false)
 
 	portNumber := 5000
 	if port != "" {
@@ -79,6 +81,10 @@ func main() {
 
 	logger.Infof("Starting Fab3 on port %d\n", portNumber)
 	proxy := fabproxy.NewFabProxy(ethService)
+	if debug == "true" {
+		logger.Info("Serving the debug namespace")
+		proxy.EnableDebug(fabproxy.NewDebugService(client, ccid, logger))
+	}
 	err = proxy.Start(portNumber)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error starting Fab3: %s", err)
@@ -0,0 +1,53 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package fabproxy
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
+)
+
+// DebugService serves the debug json-rpc namespace, which exposes the
+// execution of contracts to developers.
+type DebugService struct {
+	channelClient ChannelClient
+	ccid          string
+	logger        *zap.SugaredLogger
+}
+
+func NewDebugService(channelClient ChannelClient, ccid string, logger *zap.SugaredLogger) *DebugService {
+	return &DebugService{channelClient: channelClient, ccid: ccid, logger: logger}
+}
+
+// TraceCall runs a contract call on a discarded copy of the state of the EVM
+// chaincode and returns the trace of its execution, opcode by opcode, with the
+// storage each opcode read or wrote. A failed execution is reported in the
+// trace.
+func (s *DebugService) TraceCall(r *http.Request, args *EthArgs, reply *json.RawMessage) error {
+	fcn, ccArgs, err := evmRequest(args.To, args)
+	if err != nil {
+		return err
+	}
+
+	response, err := s.channelClient.Query(channel.Request{
+		ChaincodeID: s.ccid,
+		Fcn:         "trace",
+		Args:        append([][]byte{[]byte(fcn)}, ccArgs...),
+	})
+	if err != nil {
+		s.logger.Debug(err)
+		return executionError("Failed to trace the call", err)
+	}
+
+	*reply = json.RawMessage(response.Payload)
+
+	return nil
+}
@@ -0,0 +1,79 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package fabproxy_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/hyperledger/fabric-chaincode-evm/fabproxy"
+	fabproxy_mocks "github.com/hyperledger/fabric-chaincode-evm/mocks/fabproxy"
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DebugService", func() {
+	var (
+		debugservice *fabproxy.DebugService
+		mockChClient *fabproxy_mocks.MockChannelClient
+		sampleArgs   *fabproxy.EthArgs
+		sampleTrace  []byte
+	)
+	rawLogger, _ := zap.NewProduction()
+	logger := rawLogger.Sugar()
+
+	BeforeEach(func() {
+		mockChClient = &fabproxy_mocks.MockChannelClient{}
+		debugservice = fabproxy.NewDebugService(mockChClient, evmcc, logger)
+
+		sampleTrace = []byte(`{"gas":3,"failed":false,"returnValue":"","structLogs":[{"pc":0,"op":"PUSH1","gas":100,"depth":1,"stackSize":0}]}`)
+		mockChClient.QueryReturns(channel.Response{Payload: sampleTrace}, nil)
+
+		sampleArgs = &fabproxy.EthArgs{
+			To:   "0x1234567123",
+			Data: "0xsample-data",
+		}
+	})
+
+	Describe("TraceCall", func() {
+		It("returns the trace of the call run by the EVM chaincode", func() {
+			var reply json.RawMessage
+
+			err := debugservice.TraceCall(&http.Request{}, sampleArgs, &reply)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(mockChClient.QueryCallCount()).To(Equal(1))
+			chReq, _ := mockChClient.QueryArgsForCall(0)
+			Expect(chReq).To(Equal(channel.Request{
+				ChaincodeID: evmcc,
+				Fcn:         "trace",
+				Args:        [][]byte{[]byte("1234567123"), []byte("sample-data")},
+			}))
+
+			Expect(reply).To(MatchJSON(sampleTrace))
+		})
+
+		Context("when the query fails", func() {
+			BeforeEach(func() {
+				mockChClient.QueryReturns(channel.Response{}, errors.New("boom!"))
+			})
+
+			It("returns a corresponding error", func() {
+				var reply json.RawMessage
+
+				err := debugservice.TraceCall(&http.Request{}, sampleArgs, &reply)
+				Expect(err).To(MatchError(ContainSubstring("Failed to trace the call")))
+				Expect(reply).To(BeEmpty())
+			})
+		})
+	})
+})
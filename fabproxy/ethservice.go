@@ -17,9 +17,12 @@ import (
 	"strings"
 
 	"github.com/gogo/protobuf/proto"
+	"github.com/gorilla/rpc/v2/json2"
 	"github.com/hyperledger/burrow/execution/exec"
 	"go.uber.org/zap"
 
+	"github.com/hyperledger/fabric-chaincode-evm/envelope"
+	"github.com/hyperledger/fabric-chaincode-evm/evmerrors"
 	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
 	"github.com/hyperledger/fabric-sdk-go/pkg/client/ledger"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
@@ -29,6 +32,24 @@ import (
 
 var ZeroAddress = make([]byte, 20)
 
+// RevertErrorCode is the json-rpc error code used by Ethereum clients to
+// report a reverted execution, the revert data is sent in the error data.
+const RevertErrorCode json2.ErrorCode = 3
+
+// errorCodes maps the codes of the errors returned by the EVM chaincode to
+// json-rpc error codes, -32000 for requests that could not be served and
+// -32602 for invalid parameters. The evmerrors code is sent in the error
+// data.
+var errorCodes = map[evmerrors.Code]json2.ErrorCode{
+	evmerrors.CodeNotFound:         json2.E_SERVER,
+	evmerrors.CodeOutOfGas:         json2.E_SERVER,
+	evmerrors.CodePermissionDenied: json2.E_SERVER,
+	evmerrors.CodeAlreadyExists:    json2.E_SERVER,
+	evmerrors.CodeInvalidAddress:   json2.E_BAD_PARAMS,
+	evmerrors.CodeDecoding:         json2.E_BAD_PARAMS,
+	evmerrors.CodeInvalidRequest:   json2.E_BAD_PARAMS,
+}
+
 //go:generate counterfeiter -o ../mocks/fabproxy/mockchannelclient.go --fake-name MockChannelClient ./ ChannelClient
 type ChannelClient interface {
 	Query(request channel.Request, options ...channel.RequestOption) (channel.Response, error)
@@ -52,13 +73,15 @@ type LedgerClient interface {
 //
 //go:generate counterfeiter -o ../mocks/fabproxy/mockethservice.go --fake-name MockEthService ./ EthService
 type EthService interface {
-	GetCode(r *http.Request, arg *string, reply *string) error
+	GetCode(r *http.Request, p *[]string, reply *string) error
 	Call(r *http.Request, args *EthArgs, reply *string) error
 	SendTransaction(r *http.Request, args *EthArgs, reply *string) error
 	GetTransactionReceipt(r *http.Request, arg *string, reply *TxReceipt) error
 	Accounts(r *http.Request, arg *string, reply *[]string) error
 	EstimateGas(r *http.Request, args *EthArgs, reply *string) error
 	GetBalance(r *http.Request, p *[]string, reply *string) error
+	GetStorageAt(r *http.Request, p *[]string, reply *string) error
+	GetTransactionCount(r *http.Request, p *[]string, reply *string) error
 	GetBlockByNumber(r *http.Request, p *[]interface{}, reply *Block) error
 	GetTransactionByHash(r *http.Request, txID *string, reply *Transaction) error
 }
@@ -129,6 +152,7 @@ type Block struct {
 	Number     string `json:"number"`     // number: QUANTITY - the block number. null when its pending block.
 	Hash       string `json:"hash"`       // hash: DATA, 32 Bytes - hash of the block. null when its pending block.
 	ParentHash string `json:"parentHash"` // parentHash: DATA, 32 Bytes - hash of the parent block.
+	GasUsed    string `json:"gasUsed"`    // gasUsed: QUANTITY - the total used gas by all transactions in this block.
 	// size: QUANTITY - integer the size of this block in bytes.
 	// timestamp: QUANTITY - the unix timestamp for when the block was collated.
 	Transactions []interface{} `json:"transactions"` // transactions: Array - Array of transaction objects, or 32 Bytes transaction hashes depending on the last given parameter.
@@ -138,13 +162,18 @@ func NewEthService(channelClient ChannelClient, ledgerClient LedgerClient, chann
 	return &ethService{channelClient: channelClient, ledgerClient: ledgerClient, channelID: channelID, ccid: ccid, logger: logger.Named("ethservice")}
 }
 
-func (s *ethService) GetCode(r *http.Request, arg *string, reply *string) error {
-	strippedAddr := strip0x(*arg)
+// GetCode takes an address and an optional block, and returns the code of the
+// address at that block.
+func (s *ethService) GetCode(r *http.Request, p *[]string, reply *string) error {
+	params := *p
+	if len(params) < 1 {
+		return fmt.Errorf("need at least 1 param, got %d", len(params))
+	}
 
-	response, err := s.query(s.ccid, "getCode", [][]byte{[]byte(strippedAddr)})
+	response, err := s.queryAt(blockParam(params, 1), "getCode", []byte(strip0x(params[0])))
 
 	if err != nil {
-		return errors.New(fmt.Sprintf("Failed to query the ledger: %s", err.Error()))
+		return executionError("Failed to query the ledger", err)
 	}
 
 	*reply = string(response.Payload)
@@ -152,11 +181,18 @@ func (s *ethService) GetCode(r *http.Request, arg *string, reply *string) error
 	return nil
 }
 
+// Call runs a contract call on a read-only state of the EVM chaincode, which
+// fails when the call attempts to modify the state.
 func (s *ethService) Call(r *http.Request, args *EthArgs, reply *string) error {
-	response, err := s.query(s.ccid, strip0x(args.To), [][]byte{[]byte(strip0x(args.Data))})
+	fcn, ccArgs, err := evmRequest(args.To, args)
+	if err != nil {
+		return err
+	}
+
+	response, err := s.query(s.ccid, "query", append([][]byte{[]byte(fcn)}, ccArgs...))
 
 	if err != nil {
-		return errors.New(fmt.Sprintf("Failed to query the ledger: %s", err.Error()))
+		return executionError("Failed to query the ledger", err)
 	}
 
 	// Clients expect the prefix to present in responses
@@ -170,14 +206,19 @@ func (s *ethService) SendTransaction(r *http.Request, args *EthArgs, reply *stri
 		args.To = hex.EncodeToString(ZeroAddress)
 	}
 
+	fcn, ccArgs, err := evmRequest(args.To, args)
+	if err != nil {
+		return err
+	}
+
 	response, err := s.channelClient.Execute(channel.Request{
 		ChaincodeID: s.ccid,
-		Fcn:         strip0x(args.To),
-		Args:        [][]byte{[]byte(strip0x(args.Data))},
+		Fcn:         fcn,
+		Args:        ccArgs,
 	})
 
 	if err != nil {
-		return errors.New(fmt.Sprintf("Failed to execute transaction: %s", err.Error()))
+		return executionError("Failed to execute transaction", err)
 	}
 	*reply = string(response.TransactionID)
 	return nil
@@ -196,11 +237,9 @@ func (s *ethService) GetTransactionReceipt(r *http.Request, txID *string, reply
 	transactionsFilter := block.GetMetadata().GetMetadata()[common.BlockMetadataIndex_TRANSACTIONS_FILTER]
 
 	receipt := TxReceipt{
-		TransactionHash:   "0x" + strippedTxID,
-		BlockHash:         "0x" + hex.EncodeToString(blkHeader.GetDataHash()),
-		BlockNumber:       "0x" + strconv.FormatUint(blkHeader.GetNumber(), 16),
-		GasUsed:           0,
-		CumulativeGasUsed: 0,
+		TransactionHash: "0x" + strippedTxID,
+		BlockHash:       "0x" + hex.EncodeToString(blkHeader.GetDataHash()),
+		BlockNumber:     "0x" + strconv.FormatUint(blkHeader.GetNumber(), 16),
 	}
 
 	index, txPayload, err := findTransaction(strippedTxID, block.GetData().GetData())
@@ -213,7 +252,14 @@ func (s *ethService) GetTransactionReceipt(r *http.Request, txID *string, reply
 	// for fabric transactions, 0 is valid, 1 is invalid, the opposite of how ethereum
 	receipt.Status = "0x" + strconv.FormatUint(((1+uint64(transactionsFilter[indexU]))%2), 16)
 
+	cumulativeGasUsed, err := s.blockGasUsed(block.GetData().GetData()[:indexU+1], transactionsFilter)
+	if err != nil {
+		return fmt.Errorf("Failed parsing the transactions in the block: %s", err.Error())
+	}
+	receipt.CumulativeGasUsed = int(cumulativeGasUsed)
+
 	to, _, respPayload, err := getTransactionInformation(txPayload)
+	receipt.GasUsed = int(getGasUsed(respPayload))
 
 	if to != "" {
 		callee, err := hex.DecodeString(to)
@@ -280,27 +326,107 @@ func (s *ethService) Accounts(r *http.Request, arg *string, reply *[]string) err
 }
 
 // EstimateGas accepts the same arguments as Call but all arguments are
-// optional.  This implementation ignores all arguments and returns a zero
-// estimate.
+// optional. When no recipient is given the estimate is the one of a contract
+// deployment.
 //
-// The intention is to estimate how much gas is necessary to allow a transaction
-// to complete.
-//
-// EVM-chaincode does not require gas to run transactions. The chaincode will
-// give enough gas per transaction.
-func (s *ethService) EstimateGas(r *http.Request, _ *EthArgs, reply *string) error {
+// The transaction is simulated by the EVM chaincode, which reports how much
+// gas it used to complete.
+func (s *ethService) EstimateGas(r *http.Request, args *EthArgs, reply *string) error {
 	s.logger.Debug("EstimateGas called")
-	*reply = "0x0"
+
+	to := strip0x(args.To)
+	if to == "" {
+		to = hex.EncodeToString(ZeroAddress)
+	}
+
+	fcn, ccArgs, err := evmRequest(to, args)
+	if err != nil {
+		return err
+	}
+
+	response, err := s.query(s.ccid, fcn, ccArgs)
+	if err != nil {
+		return executionError("Failed to query the ledger", err)
+	}
+
+	var gasUsed uint64
+	if len(response.Responses) > 0 && response.Responses[0] != nil {
+		gasUsed = parseGasUsed(response.Responses[0].ProposalResponse.GetResponse().GetMessage())
+	}
+
+	*reply = "0x" + strconv.FormatUint(gasUsed, 16)
 	return nil
 }
 
-// GetBalance takes an address and a block, but this implementation
-// does not check or use either parameter.
+// GetBalance takes an address and an optional block, and returns the balance
+// of the address at that block.
 //
-// Always returns zero.
+// Balances are zero unless the EVM chaincode instance enables them.
 func (s *ethService) GetBalance(r *http.Request, p *[]string, reply *string) error {
 	s.logger.Debug("GetBalance called")
-	*reply = "0x0"
+
+	params := *p
+	if len(params) < 1 {
+		return fmt.Errorf("need at least 1 param, got %d", len(params))
+	}
+
+	response, err := s.queryAt(blockParam(params, 1), "getBalance", []byte(strip0x(params[0])))
+	if err != nil {
+		return executionError("Failed to query the ledger", err)
+	}
+
+	balance, err := strconv.ParseUint(string(response.Payload), 10, 64)
+	if err != nil {
+		return fmt.Errorf("Failed to parse balance: %s", err.Error())
+	}
+
+	*reply = "0x" + strconv.FormatUint(balance, 16)
+	return nil
+}
+
+// GetStorageAt takes an address, a storage position and an optional block,
+// and returns the value of the storage position at that block.
+func (s *ethService) GetStorageAt(r *http.Request, p *[]string, reply *string) error {
+	s.logger.Debug("GetStorageAt called")
+
+	params := *p
+	if len(params) < 2 {
+		return fmt.Errorf("need at least 2 params, got %d", len(params))
+	}
+
+	response, err := s.queryAt(blockParam(params, 2), "getStorageAt", []byte(strip0x(params[0])), []byte(strip0x(params[1])))
+	if err != nil {
+		return executionError("Failed to query the ledger", err)
+	}
+
+	*reply = "0x" + string(response.Payload)
+	return nil
+}
+
+// GetTransactionCount takes an address and an optional block, and returns
+// the count at that block.
+//
+// The count is the sequence of the account in the EVM chaincode, the number
+// of contracts it deployed, which is the nonce expected in an envelope.
+func (s *ethService) GetTransactionCount(r *http.Request, p *[]string, reply *string) error {
+	s.logger.Debug("GetTransactionCount called")
+
+	params := *p
+	if len(params) < 1 {
+		return fmt.Errorf("need at least 1 param, got %d", len(params))
+	}
+
+	response, err := s.queryAt(blockParam(params, 1), "getNonce", []byte(strip0x(params[0])))
+	if err != nil {
+		return executionError("Failed to query the ledger", err)
+	}
+
+	nonce, err := strconv.ParseUint(string(response.Payload), 10, 64)
+	if err != nil {
+		return fmt.Errorf("Failed to parse nonce: %s", err.Error())
+	}
+
+	*reply = "0x" + strconv.FormatUint(nonce, 16)
 	return nil
 }
 
@@ -348,6 +474,11 @@ func (s *ethService) GetBlockByNumber(r *http.Request, p *[]interface{}, reply *
 	data := block.GetData().GetData()
 	txns := make([]interface{}, len(data))
 
+	gasUsed, err := s.blockGasUsed(data, block.GetMetadata().GetMetadata()[common.BlockMetadataIndex_TRANSACTIONS_FILTER])
+	if err != nil {
+		return err
+	}
+
 	// drill into the block to find the transaction ids it contains
 	for index, transactionData := range data {
 		if transactionData == nil {
@@ -396,6 +527,7 @@ func (s *ethService) GetBlockByNumber(r *http.Request, p *[]interface{}, reply *
 		Number:       blockNumber,
 		Hash:         blockHash,
 		ParentHash:   "0x" + hex.EncodeToString(blkHeader.GetPreviousHash()),
+		GasUsed:      "0x" + strconv.FormatUint(gasUsed, 16),
 		Transactions: txns,
 	}
 	s.logger.Debug("asked for block", number, "found block", blk)
@@ -467,6 +599,41 @@ func (s *ethService) query(ccid, function string, queryArgs [][]byte) (channel.R
 	})
 }
 
+// queryAt queries an inspection function of the EVM chaincode on the state
+// at a block. The latest state is read for the latest and pending blocks,
+// and the state of earlier blocks from the history of the world state, once
+// every transaction of the block was committed.
+func (s *ethService) queryAt(block, function string, args ...[]byte) (channel.Response, error) {
+	if block == "latest" || block == "pending" {
+		return s.query(s.ccid, function, args)
+	}
+
+	number, err := s.parseBlockNum(strip0x(block))
+	if err != nil {
+		return channel.Response{}, err
+	}
+
+	blockchainInfo, err := s.ledgerClient.QueryInfo()
+	if err != nil {
+		return channel.Response{}, fmt.Errorf("Failed to query the ledger: %v", err)
+	}
+
+	if number >= blockchainInfo.BCI.GetHeight() {
+		return channel.Response{}, fmt.Errorf("block %d has not been committed", number)
+	}
+
+	return s.query(s.ccid, "at", append([][]byte{[]byte(strconv.FormatUint(number, 10)), []byte(function)}, args...))
+}
+
+// blockParam returns the block parameter at index i, the latest block when
+// it is not given.
+func blockParam(params []string, i int) string {
+	if len(params) <= i || params[i] == "" {
+		return "latest"
+	}
+	return params[i]
+}
+
 // https://github.com/ethereum/wiki/wiki/JSON-RPC#the-default-block-parameter
 func (s *ethService) parseBlockNum(input string) (uint64, error) {
 	// check if it's one of the named-blocks
@@ -496,6 +663,35 @@ func (s *ethService) parseBlockNum(input string) (uint64, error) {
 	}
 }
 
+// executionError translates the error returned when a request to evmcc
+// fails. Reverted executions are turned into a json-rpc error carrying the
+// revert data, the way web3 and ethers expect them, and other coded errors
+// into the json-rpc error matching their code.
+func executionError(msg string, err error) error {
+	evmErr, ok := evmerrors.Parse(err.Error())
+	if !ok {
+		return fmt.Errorf("%s: %s", msg, err.Error())
+	}
+
+	if evmErr.Code == evmerrors.CodeRevert || evmErr.Data != "" {
+		return &json2.Error{
+			Code:    RevertErrorCode,
+			Message: evmErr.Message,
+			Data:    "0x" + evmErr.Data,
+		}
+	}
+
+	if code, ok := errorCodes[evmErr.Code]; ok {
+		return &json2.Error{
+			Code:    code,
+			Message: fmt.Sprintf("%s: %s", msg, evmErr.Message),
+			Data:    evmErr.Code,
+		}
+	}
+
+	return fmt.Errorf("%s: %s", msg, err.Error())
+}
+
 func strip0x(addr string) string {
 	//Not checking for malformed addresses just stripping `0x` prefix where applicable
 	if len(addr) > 2 && addr[0:2] == "0x" {
@@ -560,20 +756,36 @@ func getTransactionInformation(payload *common.Payload) (string, string, *peer.C
 		return "", "", nil, fmt.Errorf("Failed to unmarshal transaction: %s", err.Error())
 	}
 
-	// callee, input data is standard case, also handle getcode & account cases
+	// callee, input data (and optionally gas) or an envelope is standard
+	// case, also handle getcode, account and admin cases
 	args := invokeSpec.GetChaincodeSpec().GetInput().Args
 
-	if len(args) != 2 || string(args[0]) == "getCode" {
+	// Transactions run on an instance other than the default one are
+	// prefixed with 'instance' and its id
+	if len(args) > 2 && string(args[0]) == "instance" {
+		args = args[2:]
+	}
+
+	if len(args) == 0 || envelope.IsFunction(string(args[0])) {
 		// no more data available to fill the transaction
 		return "", "", respPayload, nil
 	}
 
+	env, err := envelope.FromArgs(args)
+	if err != nil {
+		// not a contract deployment or invocation
+		return "", "", respPayload, nil
+	}
+
 	// At this point, this is either an EVM Contract Deploy,
 	// or an EVM Contract Invoke. We don't care about the
 	// specific case, fill in the fields directly.
+	to := strip0x(env.To)
+	if to == "" {
+		to = hex.EncodeToString(ZeroAddress)
+	}
 
-	// First arg is to and second arg is the input data
-	return string(args[0]), string(args[1]), respPayload, nil
+	return to, strip0x(env.Data), respPayload, nil
 }
 
 // findTransaction takes in the txId and  block data from block.GetData().GetData() where block is of type *common.Block
@@ -610,6 +822,114 @@ func findTransaction(txID string, blockData [][]byte) (string, *common.Payload,
 	return "", &common.Payload{}, nil
 }
 
+// evmRequest returns the function and arguments of an EVM chaincode
+// invocation. Transactions sending value are sent as an envelope, which is
+// the only form able to carry it.
+func evmRequest(to string, args *EthArgs) (string, [][]byte, error) {
+	value, err := envelope.ParseQuantity(args.Value)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to decode value: %s", err.Error())
+	}
+
+	if value == 0 {
+		return strip0x(to), evmArgs(args), nil
+	}
+
+	env := envelope.New()
+	env.To = strip0x(to)
+	env.Data = strip0x(args.Data)
+	env.Value = strip0x(args.Value)
+	env.Gas = strip0x(args.Gas)
+
+	envArgs, err := env.Args()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return string(envArgs[0]), envArgs[1:], nil
+}
+
+// evmArgs returns the arguments of an EVM chaincode invocation, the input
+// data followed by the gas limit when one is given.
+func evmArgs(args *EthArgs) [][]byte {
+	evmArgs := [][]byte{[]byte(strip0x(args.Data))}
+	if args.Gas != "" {
+		evmArgs = append(evmArgs, []byte(strip0x(args.Gas)))
+	}
+	return evmArgs
+}
+
+// evmccResult is the message of the response of the EVM chaincode to
+// contract deployments and invocations.
+type evmccResult struct {
+	GasUsed uint64 `json:"gasUsed"`
+}
+
+func parseGasUsed(msg string) uint64 {
+	result := evmccResult{}
+	if err := json.Unmarshal([]byte(msg), &result); err != nil {
+		return 0
+	}
+	return result.GasUsed
+}
+
+// getGasUsed returns the gas the EVM chaincode used to run a transaction, or
+// 0 for other transactions.
+func getGasUsed(respPayload *peer.ChaincodeAction) uint64 {
+	return parseGasUsed(respPayload.GetResponse().GetMessage())
+}
+
+// blockGasUsed adds up the gas used by the valid endorser transactions of
+// the EVM chaincode in blockData. transactionsFilter holds the validation
+// code of each transaction of the block.
+func (s *ethService) blockGasUsed(blockData [][]byte, transactionsFilter []byte) (uint64, error) {
+	var gasUsed uint64
+	for index, transactionData := range blockData {
+		if transactionData == nil {
+			continue
+		}
+
+		if index >= len(transactionsFilter) || peer.TxValidationCode(transactionsFilter[index]) != peer.TxValidationCode_VALID {
+			continue
+		}
+
+		env := &common.Envelope{}
+		if err := proto.Unmarshal(transactionData, env); err != nil {
+			return 0, err
+		}
+
+		payload := &common.Payload{}
+		if err := proto.Unmarshal(env.GetPayload(), payload); err != nil {
+			return 0, err
+		}
+
+		chdr := &common.ChannelHeader{}
+		if err := proto.Unmarshal(payload.GetHeader().GetChannelHeader(), chdr); err != nil {
+			return 0, err
+		}
+
+		if chdr.Type != int32(common.HeaderType_ENDORSER_TRANSACTION) {
+			continue
+		}
+
+		ext := &peer.ChaincodeHeaderExtension{}
+		if err := proto.Unmarshal(chdr.GetExtension(), ext); err != nil {
+			return 0, err
+		}
+
+		if ext.GetChaincodeId().GetName() != s.ccid {
+			continue
+		}
+
+		_, _, respPayload, err := getTransactionInformation(payload)
+		if err != nil {
+			return 0, err
+		}
+		gasUsed += getGasUsed(respPayload)
+	}
+	return gasUsed, nil
+}
+
 func getChaincodeEvents(respPayload *peer.ChaincodeAction) (*peer.ChaincodeEvent, error) {
 	eBytes := respPayload.Events
 	chaincodeEvent := &peer.ChaincodeEvent{}
@@ -20,8 +20,11 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/gogo/protobuf/proto"
+	"github.com/gorilla/rpc/v2/json2"
 	"github.com/hyperledger/burrow/binary"
 	"github.com/hyperledger/burrow/execution/exec"
+	"github.com/hyperledger/fabric-chaincode-evm/envelope"
+	"github.com/hyperledger/fabric-chaincode-evm/evmerrors"
 	"github.com/hyperledger/fabric-chaincode-evm/fabproxy"
 	fabproxy_mocks "github.com/hyperledger/fabric-chaincode-evm/mocks/fabproxy"
 	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
@@ -71,7 +74,7 @@ var _ = Describe("Ethservice", func() {
 		It("returns the code associated to that address", func() {
 			var reply string
 
-			err := ethservice.GetCode(&http.Request{}, &sampleAddress, &reply)
+			err := ethservice.GetCode(&http.Request{}, &[]string{sampleAddress}, &reply)
 			Expect(err).ToNot(HaveOccurred())
 
 			Expect(mockChClient.QueryCallCount()).To(Equal(1))
@@ -94,7 +97,7 @@ var _ = Describe("Ethservice", func() {
 			It("returns the code associated with that address", func() {
 				var reply string
 
-				err := ethservice.GetCode(&http.Request{}, &sampleAddress, &reply)
+				err := ethservice.GetCode(&http.Request{}, &[]string{sampleAddress}, &reply)
 				Expect(err).ToNot(HaveOccurred())
 
 				Expect(mockChClient.QueryCallCount()).To(Equal(1))
@@ -119,12 +122,70 @@ var _ = Describe("Ethservice", func() {
 			It("returns a corresponding error", func() {
 				var reply string
 
-				err := ethservice.GetCode(&http.Request{}, &sampleAddress, &reply)
+				err := ethservice.GetCode(&http.Request{}, &[]string{sampleAddress}, &reply)
 				Expect(err).To(MatchError(ContainSubstring("Failed to query the ledger")))
 
 				Expect(reply).To(BeEmpty())
 			})
 		})
+
+		Context("when a block number is given", func() {
+			BeforeEach(func() {
+				mockLedgerClient.QueryInfoReturns(&fab.BlockchainInfoResponse{BCI: &common.BlockchainInfo{Height: 40}}, nil)
+			})
+
+			It("returns the code once the transactions of the block were committed", func() {
+				var reply string
+
+				err := ethservice.GetCode(&http.Request{}, &[]string{sampleAddress, "0x1f"}, &reply)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(mockChClient.QueryCallCount()).To(Equal(1))
+				chReq, _ := mockChClient.QueryArgsForCall(0)
+				Expect(chReq).To(Equal(channel.Request{
+					ChaincodeID: evmcc,
+					Fcn:         "at",
+					Args:        [][]byte{[]byte("31"), []byte("getCode"), []byte(sampleAddress)},
+				}))
+
+				Expect(reply).To(Equal(string(sampleCode)))
+			})
+
+			Context("when the block has not been committed", func() {
+				BeforeEach(func() {
+					mockLedgerClient.QueryInfoReturns(&fab.BlockchainInfoResponse{BCI: &common.BlockchainInfo{Height: 31}}, nil)
+				})
+
+				It("returns an error without querying the chaincode", func() {
+					var reply string
+
+					err := ethservice.GetCode(&http.Request{}, &[]string{sampleAddress, "0x1f"}, &reply)
+					Expect(err).To(MatchError(ContainSubstring("block 31 has not been committed")))
+					Expect(mockChClient.QueryCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the ledger cannot be queried", func() {
+				BeforeEach(func() {
+					mockLedgerClient.QueryInfoReturns(nil, errors.New("boom!"))
+				})
+
+				It("returns an error without querying the chaincode", func() {
+					var reply string
+
+					err := ethservice.GetCode(&http.Request{}, &[]string{sampleAddress, "0x1f"}, &reply)
+					Expect(err).To(HaveOccurred())
+					Expect(mockChClient.QueryCallCount()).To(Equal(0))
+				})
+			})
+		})
+
+		It("returns an error when no address is given", func() {
+			var reply string
+
+			err := ethservice.GetCode(&http.Request{}, &[]string{}, &reply)
+			Expect(err).To(HaveOccurred())
+		})
 	})
 
 	Describe("Call", func() {
@@ -158,8 +219,8 @@ var _ = Describe("Ethservice", func() {
 			chReq, reqOpts := mockChClient.QueryArgsForCall(0)
 			Expect(chReq).To(Equal(channel.Request{
 				ChaincodeID: evmcc,
-				Fcn:         sampleArgs.To,
-				Args:        [][]byte{[]byte(sampleArgs.Data)},
+				Fcn:         "query",
+				Args:        [][]byte{[]byte(sampleArgs.To), []byte(sampleArgs.Data)},
 			}))
 
 			Expect(reqOpts).To(HaveLen(0))
@@ -181,6 +242,53 @@ var _ = Describe("Ethservice", func() {
 			})
 		})
 
+		Context("when the contract execution is reverted", func() {
+			BeforeEach(func() {
+				revertErr := &evmerrors.Error{Message: "execution reverted: boom", Data: "08c379a0", Reason: "boom"}
+				mockChClient.QueryReturns(channel.Response{}, fmt.Errorf("Chaincode status Code: (500) UNKNOWN. Description: %s", revertErr.Error()))
+			})
+
+			It("returns a json-rpc error carrying the revert data", func() {
+				var reply string
+
+				err := ethservice.Call(&http.Request{}, sampleArgs, &reply)
+				Expect(err).To(Equal(&json2.Error{
+					Code:    fabproxy.RevertErrorCode,
+					Message: "execution reverted: boom",
+					Data:    "0x08c379a0",
+				}))
+				Expect(reply).To(BeEmpty())
+			})
+		})
+
+		Context("when the chaincode returns a coded error", func() {
+			It("returns a server error for a missing contract", func() {
+				evmErr := evmerrors.New(evmerrors.CodeNotFound, "no contract at 1234567123")
+				mockChClient.QueryReturns(channel.Response{}, fmt.Errorf("Description: %s", evmErr.Error()))
+
+				var reply string
+				err := ethservice.Call(&http.Request{}, sampleArgs, &reply)
+				Expect(err).To(Equal(&json2.Error{
+					Code:    json2.E_SERVER,
+					Message: "Failed to query the ledger: no contract at 1234567123",
+					Data:    evmerrors.CodeNotFound,
+				}))
+			})
+
+			It("returns an invalid params error for a malformed address", func() {
+				evmErr := evmerrors.New(evmerrors.CodeInvalidAddress, "failed to get callee address")
+				mockChClient.QueryReturns(channel.Response{}, fmt.Errorf("Description: %s", evmErr.Error()))
+
+				var reply string
+				err := ethservice.Call(&http.Request{}, sampleArgs, &reply)
+				Expect(err).To(Equal(&json2.Error{
+					Code:    json2.E_BAD_PARAMS,
+					Message: "Failed to query the ledger: failed to get callee address",
+					Data:    evmerrors.CodeInvalidAddress,
+				}))
+			})
+		})
+
 		Context("when the address has a `0x` prefix", func() {
 			BeforeEach(func() {
 				sampleArgs.To = "0x" + sampleArgs.To
@@ -195,8 +303,8 @@ var _ = Describe("Ethservice", func() {
 				chReq, reqOpts := mockChClient.QueryArgsForCall(0)
 				Expect(chReq).To(Equal(channel.Request{
 					ChaincodeID: evmcc,
-					Fcn:         sampleArgs.To[2:],
-					Args:        [][]byte{[]byte(sampleArgs.Data)},
+					Fcn:         "query",
+					Args:        [][]byte{[]byte(sampleArgs.To[2:]), []byte(sampleArgs.Data)},
 				}))
 
 				Expect(reqOpts).To(HaveLen(0))
@@ -220,8 +328,8 @@ var _ = Describe("Ethservice", func() {
 				chReq, reqOpts := mockChClient.QueryArgsForCall(0)
 				Expect(chReq).To(Equal(channel.Request{
 					ChaincodeID: evmcc,
-					Fcn:         sampleArgs.To,
-					Args:        [][]byte{[]byte(sampleArgs.Data[2:])},
+					Fcn:         "query",
+					Args:        [][]byte{[]byte(sampleArgs.To), []byte(sampleArgs.Data[2:])},
 				}))
 
 				Expect(reqOpts).To(HaveLen(0))
@@ -268,6 +376,50 @@ var _ = Describe("Ethservice", func() {
 			Expect(reply).To(Equal(string(sampleResponse.TransactionID)))
 		})
 
+		Context("when a gas limit is given", func() {
+			BeforeEach(func() {
+				sampleArgs.Gas = "0x5000"
+			})
+
+			It("passes the gas limit to the evmscc", func() {
+				var reply string
+				err := ethservice.SendTransaction(&http.Request{}, sampleArgs, &reply)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(mockChClient.ExecuteCallCount()).To(Equal(1))
+				chReq, _ := mockChClient.ExecuteArgsForCall(0)
+				Expect(chReq).To(Equal(channel.Request{
+					ChaincodeID: evmcc,
+					Fcn:         sampleArgs.To,
+					Args:        [][]byte{[]byte(sampleArgs.Data), []byte("5000")},
+				}))
+			})
+		})
+
+		Context("when value is sent", func() {
+			BeforeEach(func() {
+				sampleArgs.To = "0x1234567123"
+				sampleArgs.Value = "0x2a"
+			})
+
+			It("sends the transaction as an envelope", func() {
+				var reply string
+				err := ethservice.SendTransaction(&http.Request{}, sampleArgs, &reply)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(mockChClient.ExecuteCallCount()).To(Equal(1))
+				chReq, _ := mockChClient.ExecuteArgsForCall(0)
+				Expect(chReq.Fcn).To(Equal(envelope.Function))
+				Expect(chReq.Args).To(HaveLen(1))
+
+				env, err := envelope.Unmarshal(chReq.Args[0])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(env.To).To(Equal("1234567123"))
+				Expect(env.Data).To(Equal(sampleArgs.Data))
+				Expect(env.Value).To(Equal("2a"))
+			})
+		})
+
 		Context("when the transaction is a contract deployment", func() {
 			BeforeEach(func() {
 				sampleArgs.To = ""
@@ -307,6 +459,25 @@ var _ = Describe("Ethservice", func() {
 			})
 		})
 
+		Context("when the contract execution is reverted", func() {
+			BeforeEach(func() {
+				revertErr := &evmerrors.Error{Message: "execution reverted", Data: "cafe"}
+				mockChClient.ExecuteReturns(channel.Response{}, fmt.Errorf("Description: %s", revertErr.Error()))
+			})
+
+			It("returns a json-rpc error carrying the revert data", func() {
+				var reply string
+
+				err := ethservice.SendTransaction(&http.Request{}, sampleArgs, &reply)
+				Expect(err).To(Equal(&json2.Error{
+					Code:    fabproxy.RevertErrorCode,
+					Message: "execution reverted",
+					Data:    "0xcafe",
+				}))
+				Expect(reply).To(BeEmpty())
+			})
+		})
+
 		Context("when the address has a `0x` prefix", func() {
 			BeforeEach(func() {
 				sampleArgs.To = "0x" + sampleArgs.To
@@ -404,6 +575,69 @@ var _ = Describe("Ethservice", func() {
 			}))
 		})
 
+		Context("when the chaincode reports the gas used by the transactions", func() {
+			BeforeEach(func() {
+				var err error
+				sampleTransaction, err = GetSampleEndorserTransaction([][]byte{[]byte(sampleAddress), []byte("sample arg 2")}, &peer.Response{Message: `{"gasUsed":30}`}, sampleTransactionID)
+				Expect(err).ToNot(HaveOccurred())
+
+				otherTransaction, err = GetSampleEndorserTransaction([][]byte{[]byte("1234567"), []byte("sample arg 3")}, &peer.Response{Message: `{"gasUsed":12}`}, "5678")
+				Expect(err).ToNot(HaveOccurred())
+
+				sampleBlock = GetSampleBlockWithTransaction(31, []byte("12345abcd"), otherTransaction, sampleTransaction)
+				mockLedgerClient.QueryBlockByTxIDReturns(sampleBlock, nil)
+			})
+
+			It("returns the gas used in the transaction receipt", func() {
+				var reply fabproxy.TxReceipt
+
+				err := ethservice.GetTransactionReceipt(&http.Request{}, &sampleTransactionID, &reply)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(reply.GasUsed).To(Equal(30))
+				Expect(reply.CumulativeGasUsed).To(Equal(42))
+			})
+
+			Context("when an earlier transaction is invalid", func() {
+				BeforeEach(func() {
+					otherTransaction.ValidationCode = int32(peer.TxValidationCode_MVCC_READ_CONFLICT)
+					sampleBlock = GetSampleBlockWithTransaction(31, []byte("12345abcd"), otherTransaction, sampleTransaction)
+					mockLedgerClient.QueryBlockByTxIDReturns(sampleBlock, nil)
+				})
+
+				It("leaves it out of the cumulative gas used", func() {
+					var reply fabproxy.TxReceipt
+
+					err := ethservice.GetTransactionReceipt(&http.Request{}, &sampleTransactionID, &reply)
+					Expect(err).ToNot(HaveOccurred())
+
+					Expect(reply.GasUsed).To(Equal(30))
+					Expect(reply.CumulativeGasUsed).To(Equal(30))
+				})
+			})
+
+			Context("when an earlier transaction is of another chaincode", func() {
+				BeforeEach(func() {
+					var err error
+					otherTransaction, err = GetSampleChaincodeTransaction([][]byte{[]byte("1234567"), []byte("sample arg 3")}, &peer.Response{Message: `{"gasUsed":12}`}, "5678", "othercc")
+					Expect(err).ToNot(HaveOccurred())
+
+					sampleBlock = GetSampleBlockWithTransaction(31, []byte("12345abcd"), otherTransaction, sampleTransaction)
+					mockLedgerClient.QueryBlockByTxIDReturns(sampleBlock, nil)
+				})
+
+				It("leaves it out of the cumulative gas used", func() {
+					var reply fabproxy.TxReceipt
+
+					err := ethservice.GetTransactionReceipt(&http.Request{}, &sampleTransactionID, &reply)
+					Expect(err).ToNot(HaveOccurred())
+
+					Expect(reply.GasUsed).To(Equal(30))
+					Expect(reply.CumulativeGasUsed).To(Equal(30))
+				})
+			})
+		})
+
 		Context("when the transaction has associated events", func() {
 			var (
 				msg          exec.LogEvent
@@ -556,6 +790,27 @@ var _ = Describe("Ethservice", func() {
 					}))
 				})
 			})
+
+			Context("when the contract is deployed on an instance", func() {
+				BeforeEach(func() {
+					zeroAddress := make([]byte, hex.EncodedLen(len(fabproxy.ZeroAddress)))
+					hex.Encode(zeroAddress, fabproxy.ZeroAddress)
+
+					tx, err := GetSampleTransaction([][]byte{[]byte("instance"), []byte("network-a"), zeroAddress, []byte("sample arg 2")}, contractAddress, []byte{}, sampleTransactionID)
+					Expect(err).ToNot(HaveOccurred())
+					*sampleTransaction = *tx
+
+					*sampleBlock = *GetSampleBlockWithTransaction(31, []byte("12345abcd"), sampleTransaction, otherTransaction)
+				})
+
+				It("returns the contract address in the transaction receipt", func() {
+					var reply fabproxy.TxReceipt
+
+					err := ethservice.GetTransactionReceipt(&http.Request{}, &sampleTransactionID, &reply)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(reply.ContractAddress).To(Equal(string(contractAddress)))
+				})
+			})
 		})
 
 		Context("when requested transaction is not an evm smart contract transaction", func() {
@@ -573,7 +828,7 @@ var _ = Describe("Ethservice", func() {
 				tooFewArgsTransaction, err = GetSampleTransaction([][]byte{[]byte("82373458")}, []byte("sample-response"), []byte{}, txnID1)
 				Expect(err).ToNot(HaveOccurred())
 
-				tooManyArgsTransaction, err = GetSampleTransaction([][]byte{[]byte("82373458"), []byte("sample-arg2"), []byte("sample-arg3")}, []byte("sample-response"), []byte{}, txnID2)
+				tooManyArgsTransaction, err = GetSampleTransaction([][]byte{[]byte("82373458"), []byte("sample-arg2"), []byte("sample-arg3"), []byte("sample-arg4")}, []byte("sample-response"), []byte{}, txnID2)
 				Expect(err).ToNot(HaveOccurred())
 
 				getCodeTransaction, err = GetSampleTransaction([][]byte{[]byte("getCode"), []byte("sample-arg")}, []byte("sample-response 2"), []byte{}, txnID3)
@@ -601,7 +856,7 @@ var _ = Describe("Ethservice", func() {
 				}))
 			})
 
-			It("does not provide to field when the requested tx has more than 2 args", func() {
+			It("does not provide to field when the requested tx has more than 3 args", func() {
 				var reply fabproxy.TxReceipt
 				err := ethservice.GetTransactionReceipt(&http.Request{}, &txnID2, &reply)
 				Expect(err).ToNot(HaveOccurred())
@@ -697,21 +952,189 @@ var _ = Describe("Ethservice", func() {
 	})
 
 	Describe("EstimateGas", func() {
-		It("always returns zero", func() {
+		var sampleArgs *fabproxy.EthArgs
+
+		BeforeEach(func() {
+			mockChClient.QueryReturns(channel.Response{
+				Responses: []*fab.TransactionProposalResponse{{
+					ProposalResponse: &peer.ProposalResponse{
+						Response: &peer.Response{Message: `{"gasUsed":1234}`},
+					},
+				}},
+			}, nil)
+
+			sampleArgs = &fabproxy.EthArgs{
+				To:   "0x1234567123",
+				Data: "0xsample-data",
+				Gas:  "0x5000",
+			}
+		})
+
+		It("simulates the transaction and returns the gas it used", func() {
 			var reply string
-			err := ethservice.EstimateGas(&http.Request{}, &fabproxy.EthArgs{}, &reply)
+			err := ethservice.EstimateGas(&http.Request{}, sampleArgs, &reply)
 			Expect(err).ToNot(HaveOccurred())
-			Expect(reply).To(Equal("0x0"))
+			Expect(reply).To(Equal("0x4d2"))
+
+			Expect(mockChClient.QueryCallCount()).To(Equal(1))
+			chReq, _ := mockChClient.QueryArgsForCall(0)
+			Expect(chReq).To(Equal(channel.Request{
+				ChaincodeID: evmcc,
+				Fcn:         "1234567123",
+				Args:        [][]byte{[]byte("sample-data"), []byte("5000")},
+			}))
+		})
+
+		Context("when no recipient is given", func() {
+			BeforeEach(func() {
+				sampleArgs.To = ""
+			})
+
+			It("estimates the gas of a contract deployment", func() {
+				var reply string
+				err := ethservice.EstimateGas(&http.Request{}, sampleArgs, &reply)
+				Expect(err).ToNot(HaveOccurred())
+
+				chReq, _ := mockChClient.QueryArgsForCall(0)
+				Expect(chReq.Fcn).To(Equal(hex.EncodeToString(fabproxy.ZeroAddress)))
+			})
+		})
+
+		Context("when the chaincode does not report the gas used", func() {
+			BeforeEach(func() {
+				mockChClient.QueryReturns(channel.Response{}, nil)
+			})
+
+			It("returns zero", func() {
+				var reply string
+				err := ethservice.EstimateGas(&http.Request{}, &fabproxy.EthArgs{}, &reply)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(reply).To(Equal("0x0"))
+			})
 		})
 	})
 
 	Describe("GetBalance", func() {
-		It("always returns zero", func() {
-			arg := make([]string, 2)
+		BeforeEach(func() {
+			mockChClient.QueryReturns(channel.Response{Payload: []byte("42")}, nil)
+		})
+
+		It("returns the balance of the address", func() {
+			arg := []string{"0x1234567123", "latest"}
+			var reply string
+			err := ethservice.GetBalance(&http.Request{}, &arg, &reply)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reply).To(Equal("0x2a"))
+
+			Expect(mockChClient.QueryCallCount()).To(Equal(1))
+			chReq, _ := mockChClient.QueryArgsForCall(0)
+			Expect(chReq).To(Equal(channel.Request{
+				ChaincodeID: evmcc,
+				Fcn:         "getBalance",
+				Args:        [][]byte{[]byte("1234567123")},
+			}))
+		})
+
+		It("returns an error when no address is given", func() {
+			var arg []string
 			var reply string
 			err := ethservice.GetBalance(&http.Request{}, &arg, &reply)
+			Expect(err).To(HaveOccurred())
+		})
+
+		Context("when the ledger cannot be queried", func() {
+			BeforeEach(func() {
+				mockChClient.QueryReturns(channel.Response{}, errors.New("boom!"))
+			})
+
+			It("returns an error", func() {
+				arg := []string{"0x1234567123", "latest"}
+				var reply string
+				err := ethservice.GetBalance(&http.Request{}, &arg, &reply)
+				Expect(err).To(MatchError(ContainSubstring("Failed to query the ledger")))
+			})
+		})
+	})
+
+	Describe("GetStorageAt", func() {
+		BeforeEach(func() {
+			mockChClient.QueryReturns(channel.Response{Payload: []byte("000000000000000000000000000000000000000000000000000000000000002a")}, nil)
+		})
+
+		It("returns the value of the storage position", func() {
+			arg := []string{"0x1234567123", "0x0", "latest"}
+			var reply string
+			err := ethservice.GetStorageAt(&http.Request{}, &arg, &reply)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reply).To(Equal("0x000000000000000000000000000000000000000000000000000000000000002a"))
+
+			Expect(mockChClient.QueryCallCount()).To(Equal(1))
+			chReq, _ := mockChClient.QueryArgsForCall(0)
+			Expect(chReq).To(Equal(channel.Request{
+				ChaincodeID: evmcc,
+				Fcn:         "getStorageAt",
+				Args:        [][]byte{[]byte("1234567123"), []byte("0")},
+			}))
+		})
+
+		It("returns an error when no position is given", func() {
+			arg := []string{"0x1234567123"}
+			var reply string
+			err := ethservice.GetStorageAt(&http.Request{}, &arg, &reply)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("returns the value of the storage position at the block given", func() {
+			mockLedgerClient.QueryInfoReturns(&fab.BlockchainInfoResponse{BCI: &common.BlockchainInfo{Height: 3}}, nil)
+
+			arg := []string{"0x1234567123", "0x0", "0x2"}
+			var reply string
+			err := ethservice.GetStorageAt(&http.Request{}, &arg, &reply)
 			Expect(err).ToNot(HaveOccurred())
-			Expect(reply).To(Equal("0x0"))
+			Expect(reply).To(Equal("0x000000000000000000000000000000000000000000000000000000000000002a"))
+
+			Expect(mockChClient.QueryCallCount()).To(Equal(1))
+			chReq, _ := mockChClient.QueryArgsForCall(0)
+			Expect(chReq).To(Equal(channel.Request{
+				ChaincodeID: evmcc,
+				Fcn:         "at",
+				Args:        [][]byte{[]byte("2"), []byte("getStorageAt"), []byte("1234567123"), []byte("0")},
+			}))
+		})
+	})
+
+	Describe("GetTransactionCount", func() {
+		BeforeEach(func() {
+			mockChClient.QueryReturns(channel.Response{Payload: []byte("10")}, nil)
+		})
+
+		It("returns the nonce of the address", func() {
+			arg := []string{"0x1234567123", "latest"}
+			var reply string
+			err := ethservice.GetTransactionCount(&http.Request{}, &arg, &reply)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reply).To(Equal("0xa"))
+
+			Expect(mockChClient.QueryCallCount()).To(Equal(1))
+			chReq, _ := mockChClient.QueryArgsForCall(0)
+			Expect(chReq).To(Equal(channel.Request{
+				ChaincodeID: evmcc,
+				Fcn:         "getNonce",
+				Args:        [][]byte{[]byte("1234567123")},
+			}))
+		})
+
+		Context("when the ledger cannot be queried", func() {
+			BeforeEach(func() {
+				mockChClient.QueryReturns(channel.Response{}, errors.New("boom!"))
+			})
+
+			It("returns an error", func() {
+				arg := []string{"0x1234567123", "latest"}
+				var reply string
+				err := ethservice.GetTransactionCount(&http.Request{}, &arg, &reply)
+				Expect(err).To(MatchError(ContainSubstring("Failed to query the ledger")))
+			})
 		})
 	})
 
@@ -823,6 +1246,7 @@ var _ = Describe("Ethservice", func() {
 						Expect(reply.Number).To(Equal("0x"+requestedBlockNumber), "block number")
 						Expect(reply.Hash).To(Equal("0x"+hex.EncodeToString(sampleBlock.Header.DataHash)), "block data hash")
 						Expect(reply.ParentHash).To(Equal("0x"+hex.EncodeToString(sampleBlock.Header.PreviousHash)), "block parent hash")
+						Expect(reply.GasUsed).To(Equal("0x0"), "block gas used")
 						txns := reply.Transactions
 						Expect(txns).To(HaveLen(2))
 						Expect(txns[0]).To(BeEquivalentTo("0x5678"))
@@ -989,6 +1413,32 @@ var _ = Describe("Ethservice", func() {
 			Expect(reply.Input).To(Equal("0xsample arg 2"))
 		})
 
+		Context("when the transaction was sent as an envelope", func() {
+			var txID string
+
+			BeforeEach(func() {
+				txID = "0xabcd"
+
+				env := envelope.New()
+				env.To = "0x98765432"
+				env.Data = "0x6d4ce63c"
+				envArgs, err := env.Args()
+				Expect(err).ToNot(HaveOccurred())
+
+				tx, err := GetSampleTransaction(envArgs, []byte("sample-response"), []byte{}, "abcd")
+				Expect(err).ToNot(HaveOccurred())
+
+				mockLedgerClient.QueryBlockByTxIDReturns(GetSampleBlockWithTransaction(1, []byte("12345abcd"), tx), nil)
+			})
+
+			It("decodes the recipient and input from the envelope", func() {
+				err := ethservice.GetTransactionByHash(&http.Request{}, &txID, &reply)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(reply.To).To(Equal("0x98765432"))
+				Expect(reply.Input).To(Equal("0x6d4ce63c"))
+			})
+		})
+
 		Context("when requested transaction is not an evm smart contract transaction", func() {
 			var (
 				tooFewArgsTransaction, tooManyArgsTransaction, getCodeTransaction *peer.ProcessedTransaction
@@ -1005,7 +1455,7 @@ var _ = Describe("Ethservice", func() {
 				tooFewArgsTransaction, err = GetSampleTransaction([][]byte{[]byte("82373458")}, []byte("sample-response"), []byte{}, txnID1)
 				Expect(err).ToNot(HaveOccurred())
 
-				tooManyArgsTransaction, err = GetSampleTransaction([][]byte{[]byte("82373458"), []byte("sample-arg2"), []byte("sample-arg3")}, []byte("sample-response"), []byte{}, txnID2)
+				tooManyArgsTransaction, err = GetSampleTransaction([][]byte{[]byte("82373458"), []byte("sample-arg2"), []byte("sample-arg3"), []byte("sample-arg4")}, []byte("sample-response"), []byte{}, txnID2)
 				Expect(err).ToNot(HaveOccurred())
 
 				getCodeTransaction, err = GetSampleTransaction([][]byte{[]byte("getCode"), []byte("sample-arg")}, []byte("sample-response 2"), []byte{}, txnID3)
@@ -1030,7 +1480,7 @@ var _ = Describe("Ethservice", func() {
 				}))
 			})
 
-			It("does not provide to field when the requested transaction has more than 2 args", func() {
+			It("does not provide to field when the requested transaction has more than 3 args", func() {
 				var reply fabproxy.Transaction
 				err := ethservice.GetTransactionByHash(&http.Request{}, &txnID2, &reply)
 				Expect(err).ToNot(HaveOccurred())
@@ -1091,7 +1541,7 @@ func GetSampleBlockWithTransaction(blockNumber uint64, blkHash []byte, txns ...*
 		Expect(err).ToNot(HaveOccurred())
 
 		blockData = append(blockData, txn)
-		transactionsFilter = append(transactionsFilter, '0')
+		transactionsFilter = append(transactionsFilter, byte(tx.ValidationCode))
 	}
 
 	blockMetadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER] = transactionsFilter
@@ -1115,6 +1565,33 @@ func GetSampleTransaction(inputArgs [][]byte, txResponse, eventBytes []byte, txI
 		},
 	}
 
+	return getSampleTransaction(inputArgs, respPayload, &common.ChannelHeader{TxId: txId})
+}
+
+// GetSampleEndorserTransaction returns an endorser transaction whose
+// chaincode response is the one given.
+func GetSampleEndorserTransaction(inputArgs [][]byte, response *peer.Response, txId string) (*peer.ProcessedTransaction, error) {
+	return GetSampleChaincodeTransaction(inputArgs, response, txId, evmcc)
+}
+
+// GetSampleChaincodeTransaction returns an endorser transaction of the
+// chaincode named ccName whose chaincode response is the one given.
+func GetSampleChaincodeTransaction(inputArgs [][]byte, response *peer.Response, txId, ccName string) (*peer.ProcessedTransaction, error) {
+	ext, err := proto.Marshal(&peer.ChaincodeHeaderExtension{ChaincodeId: &peer.ChaincodeID{Name: ccName}})
+	if err != nil {
+		return &peer.ProcessedTransaction{}, err
+	}
+
+	chdr := &common.ChannelHeader{
+		TxId:      txId,
+		Type:      int32(common.HeaderType_ENDORSER_TRANSACTION),
+		Extension: ext,
+	}
+
+	return getSampleTransaction(inputArgs, &peer.ChaincodeAction{Response: response}, chdr)
+}
+
+func getSampleTransaction(inputArgs [][]byte, respPayload *peer.ChaincodeAction, chdr *common.ChannelHeader) (*peer.ProcessedTransaction, error) {
 	ext, err := proto.Marshal(respPayload)
 	if err != nil {
 		return &peer.ProcessedTransaction{}, err
@@ -1177,7 +1654,6 @@ func GetSampleTransaction(inputArgs [][]byte, txResponse, eventBytes []byte, txI
 		return &peer.ProcessedTransaction{}, err
 	}
 
-	chdr := &common.ChannelHeader{TxId: txId}
 	chdrBytes, err := proto.Marshal(chdr)
 	if err != nil {
 		return &peer.ProcessedTransaction{}, err
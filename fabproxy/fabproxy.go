@@ -39,6 +39,14 @@ func NewFabProxy(service EthService) *FabProxy {
 	return proxy
 }
 
+// EnableDebug serves the debug namespace, which exposes the execution of
+// contracts and should only be reachable by developers.
+func (p *FabProxy) EnableDebug(service *DebugService) {
+	if err := p.rpcServer.RegisterService(service, "debug"); err != nil {
+		panic("this panic indicates a programming error, and is unreachable")
+	}
+}
+
 func (p *FabProxy) Start(port int) error {
 	r := mux.NewRouter()
 	r.Handle("/", p.rpcServer)
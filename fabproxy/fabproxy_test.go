@@ -63,7 +63,7 @@ var _ = Describe("Fabproxy", func() {
 			return err
 		}).Should(Succeed())
 
-		mockEthService.GetCodeStub = func(r *http.Request, arg *string, reply *string) error {
+		mockEthService.GetCodeStub = func(r *http.Request, arg *[]string, reply *string) error {
 			*reply = "0x11110"
 			return nil
 		}
@@ -74,11 +74,11 @@ type MockEthService struct {
 	getBlockByNumberReturnsOnCall map[int]struct {
 		result1 error
 	}
-	GetCodeStub        func(*http.Request, *string, *string) error
+	GetCodeStub        func(*http.Request, *[]string, *string) error
 	getCodeMutex       sync.RWMutex
 	getCodeArgsForCall []struct {
 		arg1 *http.Request
-		arg2 *string
+		arg2 *[]string
 		arg3 *string
 	}
 	getCodeReturns struct {
@@ -87,6 +87,19 @@ type MockEthService struct {
 	getCodeReturnsOnCall map[int]struct {
 		result1 error
 	}
+	GetStorageAtStub        func(*http.Request, *[]string, *string) error
+	getStorageAtMutex       sync.RWMutex
+	getStorageAtArgsForCall []struct {
+		arg1 *http.Request
+		arg2 *[]string
+		arg3 *string
+	}
+	getStorageAtReturns struct {
+		result1 error
+	}
+	getStorageAtReturnsOnCall map[int]struct {
+		result1 error
+	}
 	GetTransactionByHashStub        func(*http.Request, *string, *fabproxy.Transaction) error
 	getTransactionByHashMutex       sync.RWMutex
 	getTransactionByHashArgsForCall []struct {
@@ -100,6 +113,19 @@ type MockEthService struct {
 	getTransactionByHashReturnsOnCall map[int]struct {
 		result1 error
 	}
+	GetTransactionCountStub        func(*http.Request, *[]string, *string) error
+	getTransactionCountMutex       sync.RWMutex
+	getTransactionCountArgsForCall []struct {
+		arg1 *http.Request
+		arg2 *[]string
+		arg3 *string
+	}
+	getTransactionCountReturns struct {
+		result1 error
+	}
+	getTransactionCountReturnsOnCall map[int]struct {
+		result1 error
+	}
 	GetTransactionReceiptStub        func(*http.Request, *string, *fabproxy.TxReceipt) error
 	getTransactionReceiptMutex       sync.RWMutex
 	getTransactionReceiptArgsForCall []struct {
@@ -440,12 +466,12 @@ func (fake *MockEthService) GetBlockByNumberReturnsOnCall(i int, result1 error)
 	}{result1}
 }
 
-func (fake *MockEthService) GetCode(arg1 *http.Request, arg2 *string, arg3 *string) error {
+func (fake *MockEthService) GetCode(arg1 *http.Request, arg2 *[]string, arg3 *string) error {
 	fake.getCodeMutex.Lock()
 	ret, specificReturn := fake.getCodeReturnsOnCall[len(fake.getCodeArgsForCall)]
 	fake.getCodeArgsForCall = append(fake.getCodeArgsForCall, struct {
 		arg1 *http.Request
-		arg2 *string
+		arg2 *[]string
 		arg3 *string
 	}{arg1, arg2, arg3})
 	fake.recordInvocation("GetCode", []interface{}{arg1, arg2, arg3})
@@ -466,13 +492,13 @@ func (fake *MockEthService) GetCodeCallCount() int {
 	return len(fake.getCodeArgsForCall)
 }
 
-func (fake *MockEthService) GetCodeCalls(stub func(*http.Request, *string, *string) error) {
+func (fake *MockEthService) GetCodeCalls(stub func(*http.Request, *[]string, *string) error) {
 	fake.getCodeMutex.Lock()
 	defer fake.getCodeMutex.Unlock()
 	fake.GetCodeStub = stub
 }
 
-func (fake *MockEthService) GetCodeArgsForCall(i int) (*http.Request, *string, *string) {
+func (fake *MockEthService) GetCodeArgsForCall(i int) (*http.Request, *[]string, *string) {
 	fake.getCodeMutex.RLock()
 	defer fake.getCodeMutex.RUnlock()
 	argsForCall := fake.getCodeArgsForCall[i]
@@ -502,6 +528,68 @@ func (fake *MockEthService) GetCodeReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
+func (fake *MockEthService) GetStorageAt(arg1 *http.Request, arg2 *[]string, arg3 *string) error {
+	fake.getStorageAtMutex.Lock()
+	ret, specificReturn := fake.getStorageAtReturnsOnCall[len(fake.getStorageAtArgsForCall)]
+	fake.getStorageAtArgsForCall = append(fake.getStorageAtArgsForCall, struct {
+		arg1 *http.Request
+		arg2 *[]string
+		arg3 *string
+	}{arg1, arg2, arg3})
+	fake.recordInvocation("GetStorageAt", []interface{}{arg1, arg2, arg3})
+	fake.getStorageAtMutex.Unlock()
+	if fake.GetStorageAtStub != nil {
+		return fake.GetStorageAtStub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	fakeReturns := fake.getStorageAtReturns
+	return fakeReturns.result1
+}
+
+func (fake *MockEthService) GetStorageAtCallCount() int {
+	fake.getStorageAtMutex.RLock()
+	defer fake.getStorageAtMutex.RUnlock()
+	return len(fake.getStorageAtArgsForCall)
+}
+
+func (fake *MockEthService) GetStorageAtCalls(stub func(*http.Request, *[]string, *string) error) {
+	fake.getStorageAtMutex.Lock()
+	defer fake.getStorageAtMutex.Unlock()
+	fake.GetStorageAtStub = stub
+}
+
+func (fake *MockEthService) GetStorageAtArgsForCall(i int) (*http.Request, *[]string, *string) {
+	fake.getStorageAtMutex.RLock()
+	defer fake.getStorageAtMutex.RUnlock()
+	argsForCall := fake.getStorageAtArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *MockEthService) GetStorageAtReturns(result1 error) {
+	fake.getStorageAtMutex.Lock()
+	defer fake.getStorageAtMutex.Unlock()
+	fake.GetStorageAtStub = nil
+	fake.getStorageAtReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *MockEthService) GetStorageAtReturnsOnCall(i int, result1 error) {
+	fake.getStorageAtMutex.Lock()
+	defer fake.getStorageAtMutex.Unlock()
+	fake.GetStorageAtStub = nil
+	if fake.getStorageAtReturnsOnCall == nil {
+		fake.getStorageAtReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.getStorageAtReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *MockEthService) GetTransactionByHash(arg1 *http.Request, arg2 *string, arg3 *fabproxy.Transaction) error {
 	fake.getTransactionByHashMutex.Lock()
 	ret, specificReturn := fake.getTransactionByHashReturnsOnCall[len(fake.getTransactionByHashArgsForCall)]
@@ -523,8 +611,12 @@ func (fake *MockEthService) GetTransactionByHash(arg1 *http.Request, arg2 *strin
 }
 
 func (fake *MockEthService) GetTransactionByHashCallCount() int {
+	fake.getStorageAtMutex.RLock()
+	defer fake.getStorageAtMutex.RUnlock()
 	fake.getTransactionByHashMutex.RLock()
 	defer fake.getTransactionByHashMutex.RUnlock()
+	fake.getTransactionCountMutex.RLock()
+	defer fake.getTransactionCountMutex.RUnlock()
 	return len(fake.getTransactionByHashArgsForCall)
 }
 
@@ -535,8 +627,12 @@ func (fake *MockEthService) GetTransactionByHashCalls(stub func(*http.Request, *
 }
 
 func (fake *MockEthService) GetTransactionByHashArgsForCall(i int) (*http.Request, *string, *fabproxy.Transaction) {
+	fake.getStorageAtMutex.RLock()
+	defer fake.getStorageAtMutex.RUnlock()
 	fake.getTransactionByHashMutex.RLock()
 	defer fake.getTransactionByHashMutex.RUnlock()
+	fake.getTransactionCountMutex.RLock()
+	defer fake.getTransactionCountMutex.RUnlock()
 	argsForCall := fake.getTransactionByHashArgsForCall[i]
 	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
 }
@@ -564,6 +660,68 @@ func (fake *MockEthService) GetTransactionByHashReturnsOnCall(i int, result1 err
 	}{result1}
 }
 
+func (fake *MockEthService) GetTransactionCount(arg1 *http.Request, arg2 *[]string, arg3 *string) error {
+	fake.getTransactionCountMutex.Lock()
+	ret, specificReturn := fake.getTransactionCountReturnsOnCall[len(fake.getTransactionCountArgsForCall)]
+	fake.getTransactionCountArgsForCall = append(fake.getTransactionCountArgsForCall, struct {
+		arg1 *http.Request
+		arg2 *[]string
+		arg3 *string
+	}{arg1, arg2, arg3})
+	fake.recordInvocation("GetTransactionCount", []interface{}{arg1, arg2, arg3})
+	fake.getTransactionCountMutex.Unlock()
+	if fake.GetTransactionCountStub != nil {
+		return fake.GetTransactionCountStub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	fakeReturns := fake.getTransactionCountReturns
+	return fakeReturns.result1
+}
+
+func (fake *MockEthService) GetTransactionCountCallCount() int {
+	fake.getTransactionCountMutex.RLock()
+	defer fake.getTransactionCountMutex.RUnlock()
+	return len(fake.getTransactionCountArgsForCall)
+}
+
+func (fake *MockEthService) GetTransactionCountCalls(stub func(*http.Request, *[]string, *string) error) {
+	fake.getTransactionCountMutex.Lock()
+	defer fake.getTransactionCountMutex.Unlock()
+	fake.GetTransactionCountStub = stub
+}
+
+func (fake *MockEthService) GetTransactionCountArgsForCall(i int) (*http.Request, *[]string, *string) {
+	fake.getTransactionCountMutex.RLock()
+	defer fake.getTransactionCountMutex.RUnlock()
+	argsForCall := fake.getTransactionCountArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *MockEthService) GetTransactionCountReturns(result1 error) {
+	fake.getTransactionCountMutex.Lock()
+	defer fake.getTransactionCountMutex.Unlock()
+	fake.GetTransactionCountStub = nil
+	fake.getTransactionCountReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *MockEthService) GetTransactionCountReturnsOnCall(i int, result1 error) {
+	fake.getTransactionCountMutex.Lock()
+	defer fake.getTransactionCountMutex.Unlock()
+	fake.GetTransactionCountStub = nil
+	if fake.getTransactionCountReturnsOnCall == nil {
+		fake.getTransactionCountReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.getTransactionCountReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *MockEthService) GetTransactionReceipt(arg1 *http.Request, arg2 *string, arg3 *fabproxy.TxReceipt) error {
 	fake.getTransactionReceiptMutex.Lock()
 	ret, specificReturn := fake.getTransactionReceiptReturnsOnCall[len(fake.getTransactionReceiptArgsForCall)]
@@ -703,8 +861,12 @@ func (fake *MockEthService) Invocations() map[string][][]interface{} {
 	defer fake.getBlockByNumberMutex.RUnlock()
 	fake.getCodeMutex.RLock()
 	defer fake.getCodeMutex.RUnlock()
+	fake.getStorageAtMutex.RLock()
+	defer fake.getStorageAtMutex.RUnlock()
 	fake.getTransactionByHashMutex.RLock()
 	defer fake.getTransactionByHashMutex.RUnlock()
+	fake.getTransactionCountMutex.RLock()
+	defer fake.getTransactionCountMutex.RUnlock()
 	fake.getTransactionReceiptMutex.RLock()
 	defer fake.getTransactionReceiptMutex.RUnlock()
 	fake.sendTransactionMutex.RLock()
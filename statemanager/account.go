@@ -0,0 +1,178 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package statemanager
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/burrow/acm"
+	"github.com/hyperledger/burrow/crypto"
+	keccak "github.com/hyperledger/burrow/execution/evm/sha3"
+	"github.com/hyperledger/burrow/permission"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const (
+	// AccountEncodingProto stores accounts as burrow protobuf messages.
+	AccountEncodingProto = "proto"
+	// AccountEncodingJSON stores accounts as JSON documents, so that they
+	// can be queried with CouchDB rich queries.
+	AccountEncodingJSON = "json"
+)
+
+// AccountObjectType is the type of the JSON encoded accounts, to tell them
+// apart from the other documents of the world state in rich queries.
+const AccountObjectType = "evmcc.account"
+
+// AccountIndexObjectType is the object type of the composite keys indexing
+// the accounts, made of the address of the account, so that the accounts are
+// enumerated without scanning the other keys of the world state.
+const AccountIndexObjectType = "evmcc.accountIndex"
+
+// accountIndexKey returns the composite key indexing the account at key.
+func accountIndexKey(key string) (string, error) {
+	return shim.CreateCompositeKey(AccountIndexObjectType, []string{key})
+}
+
+// accountRecordVersion is the version of the JSON encoding of accounts.
+const accountRecordVersion = 1
+
+// accountRecord is the JSON encoding of an account. Code and CodeHash, the
+// Keccak-256 hash of the code, are hex encoded. Deployer is the hex encoded
+// address of the account that deployed a contract, copied from its metadata
+// so that contracts can be queried by deployer.
+type accountRecord struct {
+	Version int    `json:"version"`
+	Type    string `json:"type"`
+	// Namespace is the namespace of the account, empty for the default one.
+	Namespace string `json:"namespace,omitempty"`
+	Address   string `json:"address"`
+	Balance   uint64 `json:"balance"`
+	Sequence  uint64 `json:"sequence"`
+	Code      string `json:"code,omitempty"`
+	CodeHash  string `json:"codeHash,omitempty"`
+	Deployer  string `json:"deployer,omitempty"`
+	// Permissions are the base permissions set on the account by name.
+	Permissions map[string]bool `json:"permissions,omitempty"`
+	Roles       []string        `json:"roles,omitempty"`
+}
+
+// Option configures a StateManager.
+type Option func(*stateManager)
+
+// WithAccountEncoding sets how the StateManager encodes the accounts it
+// writes, AccountEncodingProto or AccountEncodingJSON. Accounts are read
+// whatever their encoding.
+func WithAccountEncoding(encoding string) Option {
+	return func(st *stateManager) {
+		st.jsonAccounts = encoding == AccountEncodingJSON
+	}
+}
+
+func (st *stateManager) encodeAccount(acc *acm.Account) ([]byte, error) {
+	if !st.jsonAccounts {
+		return acc.Marshal()
+	}
+
+	record := accountRecord{
+		Version:     accountRecordVersion,
+		Type:        AccountObjectType,
+		Namespace:   StubNamespace(st.stub),
+		Address:     hex.EncodeToString(acc.Address.Bytes()),
+		Balance:     acc.Balance,
+		Sequence:    acc.Sequence,
+		Permissions: basePermissions(acc.Permissions.Base),
+		Roles:       acc.Permissions.Roles,
+	}
+
+	if len(acc.Code) != 0 {
+		record.Code = hex.EncodeToString(acc.Code)
+		record.CodeHash = hex.EncodeToString(keccak.Sha3(acc.Code))
+
+		metadata, err := st.GetMetadata(acc.Address)
+		if err != nil {
+			return nil, err
+		}
+		if metadata != nil {
+			record.Deployer = metadata.Deployer
+		}
+	}
+
+	return json.Marshal(record)
+}
+
+// decodeAccount decodes an account in either encoding. Protobuf messages
+// never start with '{', which would be the deprecated group wire type.
+func decodeAccount(address crypto.Address, data []byte) (*acm.Account, error) {
+	acc := &acm.Account{Address: address}
+
+	if data[0] != '{' {
+		if err := acc.Unmarshal(data); err != nil {
+			return nil, err
+		}
+		return acc, nil
+	}
+
+	var record accountRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal account: %s", err)
+	}
+
+	if record.Version != accountRecordVersion {
+		return nil, fmt.Errorf("unsupported account version %d", record.Version)
+	}
+
+	code, err := hex.DecodeString(record.Code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode code: %s", err)
+	}
+
+	acc.Balance = record.Balance
+	acc.Sequence = record.Sequence
+	acc.Code = code
+	acc.Permissions.Roles = record.Roles
+	if acc.Permissions.Roles == nil {
+		acc.Permissions.Roles = []string{}
+	}
+
+	for name, value := range record.Permissions {
+		flag, err := permission.PermStringToFlag(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = acc.Permissions.Base.Set(flag, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return acc, nil
+}
+
+// basePermissions returns the base permissions set on an account by name.
+// Permissions that are not set are inherited and left out.
+func basePermissions(base permission.BasePermissions) map[string]bool {
+	var perms map[string]bool
+
+	for i := uint(0); i < permission.NumPermissions; i++ {
+		flag := permission.PermFlag(1) << i
+
+		value, err := base.Get(flag)
+		if err != nil {
+			continue
+		}
+
+		if perms == nil {
+			perms = make(map[string]bool)
+		}
+		perms[permission.PermFlagToString(flag)] = value
+	}
+
+	return perms
+}
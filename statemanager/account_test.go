@@ -0,0 +1,141 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package statemanager_test
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/hyperledger/burrow/acm"
+	"github.com/hyperledger/burrow/crypto"
+	keccak "github.com/hyperledger/burrow/execution/evm/sha3"
+	"github.com/hyperledger/burrow/permission"
+
+	"github.com/hyperledger/fabric-chaincode-evm/mocks/evmcc"
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Account encoding", func() {
+
+	var (
+		sm         statemanager.StateManager
+		mockStub   *evmcc.MockStub
+		addr       crypto.Address
+		fakeLedger map[string][]byte
+	)
+
+	BeforeEach(func() {
+		mockStub = &evmcc.MockStub{}
+		sm = statemanager.NewStateManager(mockStub, statemanager.WithAccountEncoding(statemanager.AccountEncodingJSON))
+
+		var err error
+		addr, err = crypto.AddressFromBytes([]byte("0000000000000address"))
+		Expect(err).ToNot(HaveOccurred())
+
+		fakeLedger = make(map[string][]byte)
+
+		mockStub.PutStateStub = func(key string, value []byte) error {
+			fakeLedger[key] = value
+			return nil
+		}
+
+		mockStub.GetStateStub = func(key string) ([]byte, error) {
+			return fakeLedger[key], nil
+		}
+	})
+
+	It("writes accounts as versioned JSON documents", func() {
+		sm.CreateAccount(addr)
+		sm.InitCode(addr, []byte{0x60, 0x01})
+		sm.AddRole(addr, "registry")
+		sm.IncSequence(addr)
+		Expect(sm.Error()).ToNot(HaveOccurred())
+
+		Expect(fakeLedger[addr.String()]).To(MatchJSON(fmt.Sprintf(`{
+			"version":1,
+			"type":"evmcc.account",
+			"address":"%s",
+			"balance":0,
+			"sequence":1,
+			"code":"6001",
+			"codeHash":"%s",
+			"permissions":{"call":true,"send":true},
+			"roles":["registry"]
+		}`, hex.EncodeToString(addr.Bytes()), hex.EncodeToString(keccak.Sha3([]byte{0x60, 0x01})))))
+	})
+
+	It("records the deployer of a contract from its metadata", func() {
+		deployer := "00000000000000000000000000000000000000aa"
+		sm.CreateAccount(addr)
+		Expect(sm.SetMetadata(addr, &statemanager.ContractMetadata{Deployer: deployer})).To(Succeed())
+		sm.InitCode(addr, []byte{0x60, 0x01})
+		Expect(sm.Error()).ToNot(HaveOccurred())
+
+		Expect(fakeLedger[addr.String()]).To(MatchJSON(fmt.Sprintf(`{
+			"version":1,
+			"type":"evmcc.account",
+			"address":"%s",
+			"balance":0,
+			"sequence":0,
+			"code":"6001",
+			"codeHash":"%s",
+			"deployer":"%s",
+			"permissions":{"call":true,"send":true}
+		}`, hex.EncodeToString(addr.Bytes()), hex.EncodeToString(keccak.Sha3([]byte{0x60, 0x01})), deployer)))
+	})
+
+	It("reads back the accounts it writes", func() {
+		sm.CreateAccount(addr)
+		sm.InitCode(addr, []byte{0x60, 0x01})
+		sm.SetPermission(addr, permission.Send, false)
+		Expect(sm.Error()).ToNot(HaveOccurred())
+
+		acc, err := statemanager.NewStateManager(mockStub).GetAccount(addr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(acc.Code.Bytes()).To(Equal([]byte{0x60, 0x01}))
+
+		send, err := acc.Permissions.Base.Get(permission.Send)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(send).To(BeFalse())
+
+		call, err := acc.Permissions.Base.Get(permission.Call)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(call).To(BeTrue())
+	})
+
+	Context("when an account was written in protobuf", func() {
+		BeforeEach(func() {
+			legacy := acm.Account{Address: addr, Balance: 10, Permissions: statemanager.ContractPerms}
+			serialized, err := legacy.Marshal()
+			Expect(err).ToNot(HaveOccurred())
+			fakeLedger[addr.String()] = serialized
+		})
+
+		It("reads it and rewrites it in JSON when it is updated", func() {
+			Expect(sm.GetBalance(addr)).To(Equal(uint64(10)))
+
+			sm.AddToBalance(addr, 5)
+			Expect(sm.Error()).ToNot(HaveOccurred())
+			Expect(fakeLedger[addr.String()]).To(HavePrefix("{"))
+			Expect(sm.GetBalance(addr)).To(Equal(uint64(15)))
+		})
+	})
+
+	Context("when an account has an unknown version", func() {
+		BeforeEach(func() {
+			fakeLedger[addr.String()] = []byte(`{"version":2,"type":"evmcc.account"}`)
+		})
+
+		It("returns an error", func() {
+			_, err := sm.GetAccount(addr)
+			Expect(err).To(MatchError("unsupported account version 2"))
+		})
+	})
+})
@@ -0,0 +1,194 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package statemanager
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/burrow/acm"
+	"github.com/hyperledger/burrow/binary"
+	"github.com/hyperledger/burrow/crypto"
+	"github.com/hyperledger/burrow/permission"
+	"github.com/hyperledger/fabric-chaincode-evm/envelope"
+)
+
+// Genesis describes the accounts an EVM instance starts with.
+type Genesis struct {
+	Accounts []GenesisAccount `json:"accounts,omitempty"`
+}
+
+// GenesisAccount is an account created by the genesis. Addresses, code,
+// storage keys and values are hex encoded.
+type GenesisAccount struct {
+	Address string `json:"address"`
+	Balance uint64 `json:"balance,omitempty"`
+	// Code is the runtime bytecode of a pre-deployed contract.
+	Code    string            `json:"code,omitempty"`
+	Storage map[string]string `json:"storage,omitempty"`
+	// Permissions sets or unsets base permissions by name, e.g. "call".
+	// Accounts otherwise get the ContractPerms.
+	Permissions map[string]bool `json:"permissions,omitempty"`
+	Roles       []string        `json:"roles,omitempty"`
+}
+
+type genesisAccount struct {
+	address     crypto.Address
+	balance     uint64
+	sequence    uint64
+	code        []byte
+	storageKeys []binary.Word256
+	storage     map[binary.Word256]binary.Word256
+	permissions map[permission.PermFlag]bool
+	roles       []string
+}
+
+// Apply creates the accounts of the genesis. The whole genesis is validated
+// before the state is modified, and any error is returned, so that the
+// transaction can be aborted and the genesis applied atomically.
+func (g *Genesis) Apply(st StateManager) error {
+	accounts, err := g.parse()
+	if err != nil {
+		return err
+	}
+	return applyAccounts(st, accounts)
+}
+
+func (g *Genesis) parse() ([]*genesisAccount, error) {
+	accounts := make([]*genesisAccount, 0, len(g.Accounts))
+	seen := make(map[crypto.Address]bool)
+
+	for _, ga := range g.Accounts {
+		acc, err := ga.parse()
+		if err != nil {
+			return nil, fmt.Errorf("invalid genesis account %s: %s", ga.Address, err)
+		}
+
+		if seen[acc.address] {
+			return nil, fmt.Errorf("duplicate genesis account %s", acc.address)
+		}
+		seen[acc.address] = true
+
+		accounts = append(accounts, acc)
+	}
+
+	return accounts, nil
+}
+
+// applyAccounts writes each account once, then its storage.
+func applyAccounts(st StateManager, accounts []*genesisAccount) error {
+	for _, acc := range accounts {
+		account := &acm.Account{
+			Address:     acc.address,
+			Balance:     acc.balance,
+			Sequence:    acc.sequence,
+			Permissions: ContractPerms,
+		}
+
+		if len(acc.code) != 0 {
+			account.Code = acc.code
+		}
+
+		for flag, value := range acc.permissions {
+			account.Permissions.Base.Set(flag, value)
+		}
+
+		for _, role := range acc.roles {
+			account.Permissions.AddRole(role)
+		}
+
+		st.ImportAccount(account)
+
+		for _, key := range acc.storageKeys {
+			st.SetStorage(acc.address, key, acc.storage[key])
+		}
+
+		if err := st.Error(); err != nil {
+			return fmt.Errorf("failed to apply genesis account %s: %s", acc.address, err)
+		}
+	}
+
+	return nil
+}
+
+// NewGenesisAccount returns the genesis form of an account, listing the base
+// permissions set on it. The storage of the account is left out.
+func NewGenesisAccount(acc *acm.Account) GenesisAccount {
+	return GenesisAccount{
+		Address:     hex.EncodeToString(acc.Address.Bytes()),
+		Balance:     acc.Balance,
+		Code:        hex.EncodeToString(acc.Code),
+		Permissions: basePermissions(acc.Permissions.Base),
+		Roles:       acc.Permissions.Roles,
+	}
+}
+
+func (ga *GenesisAccount) parse() (*genesisAccount, error) {
+	address, err := crypto.AddressFromHexString(envelope.Strip0x(ga.Address))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode address: %s", err)
+	}
+
+	code, err := hex.DecodeString(envelope.Strip0x(ga.Code))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode code: %s", err)
+	}
+
+	acc := &genesisAccount{
+		address:     address,
+		balance:     ga.Balance,
+		code:        code,
+		storage:     make(map[binary.Word256]binary.Word256),
+		permissions: make(map[permission.PermFlag]bool),
+		roles:       ga.Roles,
+	}
+
+	for k, v := range ga.Storage {
+		key, err := parseWord256(k)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode storage key %s: %s", k, err)
+		}
+
+		value, err := parseWord256(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode storage value %s: %s", v, err)
+		}
+
+		acc.storageKeys = append(acc.storageKeys, key)
+		acc.storage[key] = value
+	}
+
+	// Write storage in a deterministic order
+	sort.Slice(acc.storageKeys, func(i, j int) bool {
+		return bytes.Compare(acc.storageKeys[i].Bytes(), acc.storageKeys[j].Bytes()) < 0
+	})
+
+	for name, value := range ga.Permissions {
+		flag, err := permission.PermStringToFlag(name)
+		if err != nil {
+			return nil, err
+		}
+		acc.permissions[flag] = value
+	}
+
+	return acc, nil
+}
+
+func parseWord256(s string) (binary.Word256, error) {
+	b, err := hex.DecodeString(envelope.Strip0x(s))
+	if err != nil {
+		return binary.Zero256, err
+	}
+
+	if len(b) > 32 {
+		return binary.Zero256, fmt.Errorf("more than 32 bytes")
+	}
+
+	return binary.LeftPadWord256(b), nil
+}
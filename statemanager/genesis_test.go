@@ -0,0 +1,126 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package statemanager_test
+
+import (
+	"encoding/hex"
+
+	"github.com/hyperledger/burrow/acm"
+	"github.com/hyperledger/burrow/binary"
+	"github.com/hyperledger/burrow/crypto"
+	"github.com/hyperledger/burrow/permission"
+
+	"github.com/hyperledger/fabric-chaincode-evm/mocks/evmcc"
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Genesis", func() {
+
+	var (
+		sm         statemanager.StateManager
+		mockStub   *evmcc.MockStub
+		addr       crypto.Address
+		fakeLedger map[string][]byte
+	)
+
+	BeforeEach(func() {
+		mockStub = &evmcc.MockStub{}
+		sm = statemanager.NewStateManager(mockStub)
+
+		var err error
+		addr, err = crypto.AddressFromBytes([]byte("0000000000000address"))
+		Expect(err).ToNot(HaveOccurred())
+
+		fakeLedger = make(map[string][]byte)
+
+		mockStub.PutStateStub = func(key string, value []byte) error {
+			fakeLedger[key] = value
+			return nil
+		}
+
+		mockStub.GetStateStub = func(key string) ([]byte, error) {
+			return fakeLedger[key], nil
+		}
+	})
+
+	Describe("Apply", func() {
+		It("creates the accounts of the genesis", func() {
+			genesis := &statemanager.Genesis{
+				Accounts: []statemanager.GenesisAccount{
+					{
+						Address:     "0x" + addr.String(),
+						Balance:     100,
+						Code:        "6001",
+						Storage:     map[string]string{"0x01": "0x2a"},
+						Permissions: map[string]bool{"send": false},
+						Roles:       []string{"registry"},
+					},
+				},
+			}
+
+			Expect(genesis.Apply(sm)).To(Succeed())
+
+			account := acm.Account{}
+			Expect(account.Unmarshal(fakeLedger[addr.String()])).To(Succeed())
+			Expect(account.Balance).To(Equal(uint64(100)))
+			Expect(account.Code.Bytes()).To(Equal([]byte{0x60, 0x01}))
+			Expect(account.Permissions.HasRole("registry")).To(BeTrue())
+
+			send, err := account.Permissions.Base.Get(permission.Send)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(send).To(BeFalse())
+
+			key, err := shim.CreateCompositeKey(statemanager.StorageObjectType, []string{addr.String(), hex.EncodeToString(binary.LeftPadWord256([]byte{1}).Bytes())})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeLedger[key]).To(Equal(binary.LeftPadWord256([]byte{0x2a}).Bytes()))
+		})
+
+		Context("when an account is invalid", func() {
+			It("returns an error without modifying the state", func() {
+				genesis := &statemanager.Genesis{
+					Accounts: []statemanager.GenesisAccount{
+						{Address: addr.String(), Balance: 100},
+						{Address: addr.String(), Permissions: map[string]bool{"fly": true}},
+					},
+				}
+
+				Expect(genesis.Apply(sm)).To(MatchError(ContainSubstring("invalid genesis account")))
+				Expect(mockStub.PutStateCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when an account is listed twice", func() {
+			It("returns an error without modifying the state", func() {
+				genesis := &statemanager.Genesis{
+					Accounts: []statemanager.GenesisAccount{
+						{Address: addr.String()},
+						{Address: "0x" + addr.String()},
+					},
+				}
+
+				Expect(genesis.Apply(sm)).To(MatchError(ContainSubstring("duplicate genesis account")))
+				Expect(mockStub.PutStateCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when an account already exists", func() {
+			It("returns an error", func() {
+				fakeLedger[addr.String()], _ = (&acm.Account{Address: addr}).Marshal()
+
+				genesis := &statemanager.Genesis{
+					Accounts: []statemanager.GenesisAccount{{Address: addr.String()}},
+				}
+
+				Expect(genesis.Apply(sm)).To(MatchError(ContainSubstring("failed to apply genesis account")))
+			})
+		})
+	})
+})
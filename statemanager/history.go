@@ -0,0 +1,125 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package statemanager
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+)
+
+// Position is the position of a transaction in the ledger: the number of the
+// block that committed it and its index in the block.
+type Position struct {
+	Block uint64
+	Index int
+}
+
+// EndOfBlock returns the position following every transaction of a block.
+func EndOfBlock(block uint64) Position {
+	return Position{Block: block, Index: math.MaxInt32}
+}
+
+func (p Position) after(q Position) bool {
+	return p.Block > q.Block || (p.Block == q.Block && p.Index > q.Index)
+}
+
+// Locator returns the position of a transaction in the ledger from its id.
+type Locator func(txID string) (Position, error)
+
+// historyStub reads the public world state as it was at a position in the
+// ledger, from the history of its keys. The history of private data is not
+// kept by Fabric.
+type historyStub struct {
+	shim.ChaincodeStubInterface
+	at        Position
+	locate    Locator
+	positions map[string]Position
+}
+
+// NewHistoricalStateManager returns a read-only StateManager reading the
+// state once the transaction at the position given, and the ones before it,
+// were committed. The transactions that wrote the keys read are located with
+// locate.
+func NewHistoricalStateManager(stub shim.ChaincodeStubInterface, at Position, locate Locator, opts ...Option) StateManager {
+	return NewReadOnlyStateManager(&historyStub{
+		ChaincodeStubInterface: stub,
+		at:                     at,
+		locate:                 locate,
+		positions:              make(map[string]Position),
+	}, opts...)
+}
+
+func (s *historyStub) GetState(key string) ([]byte, error) {
+	return GetStateAt(s.ChaincodeStubInterface, key, s.at, s.position)
+}
+
+func (s *historyStub) GetPrivateData(collection, key string) ([]byte, error) {
+	return nil, fmt.Errorf("the history of collection %s is not available", collection)
+}
+
+// position locates a transaction once, the keys read often share the
+// transactions that wrote them.
+func (s *historyStub) position(txID string) (Position, error) {
+	if pos, ok := s.positions[txID]; ok {
+		return pos, nil
+	}
+
+	pos, err := s.locate(txID)
+	if err != nil {
+		return Position{}, err
+	}
+
+	s.positions[txID] = pos
+	return pos, nil
+}
+
+// GetStateAt returns the value of key once the transaction at position at,
+// and the ones before it, were committed, or nil if the key did not exist
+// then.
+//
+// Fabric returns the history of a key in the order its transactions were
+// committed, so the last modification committed by then is searched for,
+// locating the transactions of the modifications probed with locate.
+func GetStateAt(stub shim.ChaincodeStubInterface, key string, at Position, locate Locator) ([]byte, error) {
+	iter, err := stub.GetHistoryForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var mods []*queryresult.KeyModification
+	for iter.HasNext() {
+		mod, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		mods = append(mods, mod)
+	}
+
+	var locateErr error
+	n := sort.Search(len(mods), func(i int) bool {
+		if locateErr != nil {
+			return true
+		}
+
+		var pos Position
+		pos, locateErr = locate(mods[i].GetTxId())
+		return pos.after(at)
+	})
+	if locateErr != nil {
+		return nil, fmt.Errorf("failed to locate the transactions of %s: %s", key, locateErr)
+	}
+
+	if n == 0 || mods[n-1].GetIsDelete() {
+		return nil, nil
+	}
+	return mods[n-1].GetValue(), nil
+}
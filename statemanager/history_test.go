@@ -0,0 +1,148 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package statemanager_test
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/burrow/acm"
+	"github.com/hyperledger/burrow/crypto"
+
+	"github.com/hyperledger/fabric-chaincode-evm/mocks/evmcc"
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("History", func() {
+
+	var (
+		mockStub  *evmcc.MockStub
+		addr      crypto.Address
+		ledger    map[string][]*queryresult.KeyModification
+		positions map[string]statemanager.Position
+		located   []string
+		locate    statemanager.Locator
+	)
+
+	BeforeEach(func() {
+		mockStub = &evmcc.MockStub{}
+		ledger = make(map[string][]*queryresult.KeyModification)
+		positions = make(map[string]statemanager.Position)
+		located = nil
+
+		var err error
+		addr, err = crypto.AddressFromBytes([]byte("0000000000000address"))
+		Expect(err).ToNot(HaveOccurred())
+
+		mockStub.GetHistoryForKeyStub = func(key string) (shim.HistoryQueryIteratorInterface, error) {
+			return history(ledger[key]...), nil
+		}
+
+		locate = func(txID string) (statemanager.Position, error) {
+			located = append(located, txID)
+			pos, ok := positions[txID]
+			if !ok {
+				return statemanager.Position{}, fmt.Errorf("transaction %s not found", txID)
+			}
+			return pos, nil
+		}
+	})
+
+	Describe("GetStateAt", func() {
+		BeforeEach(func() {
+			ledger["key"] = []*queryresult.KeyModification{
+				{TxId: "1", Value: []byte("first")},
+				{TxId: "2", Value: []byte("second")},
+				{TxId: "3", IsDelete: true},
+			}
+			positions["1"] = statemanager.Position{Block: 1}
+			positions["2"] = statemanager.Position{Block: 2, Index: 1}
+			positions["3"] = statemanager.Position{Block: 3}
+		})
+
+		It("returns the value written by the last transaction committed by the end of a block", func() {
+			Expect(statemanager.GetStateAt(mockStub, "key", statemanager.EndOfBlock(2), locate)).To(Equal([]byte("second")))
+			Expect(statemanager.GetStateAt(mockStub, "key", statemanager.EndOfBlock(1), locate)).To(Equal([]byte("first")))
+		})
+
+		It("includes the writes of the transaction at the position", func() {
+			Expect(statemanager.GetStateAt(mockStub, "key", statemanager.Position{Block: 2, Index: 1}, locate)).To(Equal([]byte("second")))
+			Expect(statemanager.GetStateAt(mockStub, "key", statemanager.Position{Block: 2}, locate)).To(Equal([]byte("first")))
+		})
+
+		It("returns nil before the key was written", func() {
+			Expect(statemanager.GetStateAt(mockStub, "key", statemanager.EndOfBlock(0), locate)).To(BeNil())
+		})
+
+		It("returns nil once the key was deleted", func() {
+			Expect(statemanager.GetStateAt(mockStub, "key", statemanager.EndOfBlock(5), locate)).To(BeNil())
+		})
+
+		It("returns an error when a transaction cannot be located", func() {
+			delete(positions, "2")
+			_, err := statemanager.GetStateAt(mockStub, "key", statemanager.EndOfBlock(5), locate)
+			Expect(err).To(MatchError(ContainSubstring("transaction 2 not found")))
+		})
+	})
+
+	Describe("NewHistoricalStateManager", func() {
+		BeforeEach(func() {
+			var mods []*queryresult.KeyModification
+			for i, balance := range []uint64{10, 20} {
+				acc := acm.Account{Address: addr, Balance: balance, Permissions: statemanager.ContractPerms}
+				serialized, err := acc.Marshal()
+				Expect(err).ToNot(HaveOccurred())
+
+				txID := fmt.Sprintf("tx%d", i)
+				mods = append(mods, &queryresult.KeyModification{TxId: txID, Value: serialized})
+				positions[txID] = statemanager.Position{Block: uint64(i+1) * 100}
+			}
+			ledger[addr.String()] = mods
+		})
+
+		It("reads the accounts as they were at the position", func() {
+			sm := statemanager.NewHistoricalStateManager(mockStub, statemanager.EndOfBlock(150), locate)
+			Expect(sm.GetBalance(addr)).To(Equal(uint64(10)))
+
+			sm = statemanager.NewHistoricalStateManager(mockStub, statemanager.EndOfBlock(200), locate)
+			Expect(sm.GetBalance(addr)).To(Equal(uint64(20)))
+
+			Expect(mockStub.GetStateCallCount()).To(Equal(0))
+		})
+
+		It("locates each transaction once", func() {
+			sm := statemanager.NewHistoricalStateManager(mockStub, statemanager.EndOfBlock(150), locate)
+			Expect(sm.GetBalance(addr)).To(Equal(uint64(10)))
+			Expect(sm.GetSequence(addr)).To(Equal(uint64(0)))
+
+			Expect(located).To(ConsistOf("tx0", "tx1"))
+		})
+
+		It("does not write to the state", func() {
+			sm := statemanager.NewHistoricalStateManager(mockStub, statemanager.EndOfBlock(150), locate)
+			sm.AddToBalance(addr, 5)
+			Expect(sm.Error()).To(HaveOccurred())
+			Expect(mockStub.PutStateCallCount()).To(Equal(0))
+		})
+	})
+})
+
+// history returns a history query iterator over mods.
+func history(mods ...*queryresult.KeyModification) *evmcc.MockHistoryQueryIterator {
+	iter := &evmcc.MockHistoryQueryIterator{}
+	iter.HasNextStub = func() bool {
+		return iter.NextCallCount() < len(mods)
+	}
+	iter.NextStub = func() (*queryresult.KeyModification, error) {
+		return mods[iter.NextCallCount()-1], nil
+	}
+	return iter
+}
@@ -0,0 +1,142 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package statemanager
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/burrow/crypto"
+)
+
+// metadataSuffix is appended to the address of a contract to build the key
+// of its metadata. It cannot collide with the hex encoded storage keys.
+const metadataSuffix = ".metadata"
+
+// ContractMetadata holds the Fabric specific settings of a contract. It is
+// stored, JSON encoded, alongside the account of the contract and stays in
+// the public world state.
+type ContractMetadata struct {
+	// Collection is the private data collection holding the storage of the
+	// contract. The storage is public when empty.
+	Collection string `json:"collection,omitempty"`
+	// ACL restricts the creators allowed to call the contract. Anyone can
+	// call the contract when it is nil.
+	ACL *ACL `json:"acl,omitempty"`
+	// PreviousCodeHashes are the hex encoded Keccak-256 hashes of the code
+	// the contract had before each upgrade, oldest first.
+	PreviousCodeHashes []string `json:"previousCodeHashes,omitempty"`
+	// Deployer is the hex encoded address of the account that deployed the
+	// contract, recorded by instances storing accounts in JSON and copied to
+	// the document of the account.
+	Deployer string `json:"deployer,omitempty"`
+}
+
+// ACL is the access control list of a contract. A creator is allowed when it
+// satisfies every restriction that is set.
+type ACL struct {
+	// MSPIDs are the MSPs the creator can belong to.
+	MSPIDs []string `json:"mspids,omitempty"`
+	// OUs are the organizational units, e.g. the NodeOU roles, the
+	// certificate of the creator needs one of.
+	OUs []string `json:"ous,omitempty"`
+	// Attributes are the attributes, and their values, the certificate of
+	// the creator needs all of.
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+func metadataKey(address crypto.Address) string {
+	return address.String() + metadataSuffix
+}
+
+func (st *stateManager) GetMetadata(address crypto.Address) (*ContractMetadata, error) {
+	key := metadataKey(address)
+
+	root := st
+	for frame := st; frame != nil; frame = frame.parent {
+		if metadata, ok := frame.metadataCache[key]; ok {
+			return metadata, nil
+		}
+		root = frame
+	}
+
+	val, err := st.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata *ContractMetadata
+	if len(val) != 0 {
+		metadata = &ContractMetadata{}
+		if err = json.Unmarshal(val, metadata); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal metadata of %s: %s", address, err)
+		}
+	}
+
+	root.metadataCache[key] = metadata
+	return metadata, nil
+}
+
+func (st *stateManager) SetMetadata(address crypto.Address, metadata *ContractMetadata) error {
+	if st.readonly {
+		return fmt.Errorf("tried to set the metadata of %s in a read-only state", address)
+	}
+
+	if metadata == nil {
+		return fmt.Errorf("tried to set nil metadata for %s", address)
+	}
+
+	return st.putMetadata(metadataKey(address), metadata)
+}
+
+// putMetadata writes the metadata of a contract to the frame, nil metadata
+// removes it.
+func (st *stateManager) putMetadata(key string, metadata *ContractMetadata) error {
+	if st.parent == nil {
+		if metadata == nil {
+			if err := st.stub.DelState(key); err != nil {
+				return err
+			}
+		} else {
+			val, err := json.Marshal(metadata)
+			if err != nil {
+				return fmt.Errorf("failed to marshal metadata %s: %s", key, err)
+			}
+
+			if err = st.stub.PutState(key, val); err != nil {
+				return err
+			}
+		}
+	}
+
+	st.metadataCache[key] = metadata
+	return nil
+}
+
+// collection returns the private data collection holding the storage of a
+// contract, or an empty string if its storage is public.
+func (st *stateManager) collection(address crypto.Address) (string, error) {
+	metadata, err := st.GetMetadata(address)
+	if err != nil {
+		return "", err
+	}
+
+	if metadata == nil {
+		return "", nil
+	}
+
+	return metadata.Collection, nil
+}
+
+func (st *stateManager) removeMetadata(address crypto.Address) error {
+	metadata, err := st.GetMetadata(address)
+	if err != nil || metadata == nil {
+		return err
+	}
+
+	return st.putMetadata(metadataKey(address), nil)
+}
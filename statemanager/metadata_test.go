@@ -0,0 +1,189 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package statemanager_test
+
+import (
+	"encoding/hex"
+
+	"github.com/hyperledger/burrow/acm"
+	"github.com/hyperledger/burrow/binary"
+	"github.com/hyperledger/burrow/crypto"
+
+	"github.com/hyperledger/fabric-chaincode-evm/mocks/evmcc"
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metadata", func() {
+
+	var (
+		sm          statemanager.StateManager
+		mockStub    *evmcc.MockStub
+		addr        crypto.Address
+		fakeLedger  map[string][]byte
+		fakePrivate map[string][]byte
+	)
+
+	BeforeEach(func() {
+		mockStub = &evmcc.MockStub{}
+		sm = statemanager.NewStateManager(mockStub)
+
+		var err error
+		addr, err = crypto.AddressFromBytes([]byte("0000000000000address"))
+		Expect(err).ToNot(HaveOccurred())
+
+		fakeLedger = make(map[string][]byte)
+		fakePrivate = make(map[string][]byte)
+
+		mockStub.PutStateStub = func(key string, value []byte) error {
+			fakeLedger[key] = value
+			return nil
+		}
+
+		mockStub.GetStateStub = func(key string) ([]byte, error) {
+			return fakeLedger[key], nil
+		}
+
+		mockStub.DelStateStub = func(key string) error {
+			delete(fakeLedger, key)
+			return nil
+		}
+
+		mockStub.PutPrivateDataStub = func(collection, key string, value []byte) error {
+			fakePrivate[collection+"/"+key] = value
+			return nil
+		}
+
+		mockStub.GetPrivateDataStub = func(collection, key string) ([]byte, error) {
+			return fakePrivate[collection+"/"+key], nil
+		}
+
+		mockStub.GetStateByRangeReturns(&evmcc.MockStateQueryIterator{}, nil)
+		mockStub.GetPrivateDataByRangeReturns(&evmcc.MockStateQueryIterator{}, nil)
+		mockStub.GetStateByPartialCompositeKeyReturns(&evmcc.MockStateQueryIterator{}, nil)
+		mockStub.GetPrivateDataByPartialCompositeKeyReturns(&evmcc.MockStateQueryIterator{}, nil)
+	})
+
+	Describe("GetMetadata", func() {
+		It("returns nil for contracts without metadata", func() {
+			metadata, err := sm.GetMetadata(addr)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(metadata).To(BeNil())
+		})
+
+		It("returns the metadata stored alongside the account", func() {
+			fakeLedger[addr.String()+".metadata"] = []byte(`{"collection":"secrets"}`)
+
+			metadata, err := sm.GetMetadata(addr)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(metadata).To(Equal(&statemanager.ContractMetadata{Collection: "secrets"}))
+		})
+	})
+
+	Describe("SetMetadata", func() {
+		It("stores the metadata in the public state", func() {
+			err := sm.SetMetadata(addr, &statemanager.ContractMetadata{Collection: "secrets"})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(mockStub.PutStateCallCount()).To(Equal(1))
+			key, val := mockStub.PutStateArgsForCall(0)
+			Expect(key).To(Equal(addr.String() + ".metadata"))
+			Expect(val).To(MatchJSON(`{"collection":"secrets"}`))
+		})
+	})
+
+	Context("when the contract is bound to a collection", func() {
+		var (
+			key, val binary.Word256
+			compKey  string
+		)
+
+		BeforeEach(func() {
+			Expect(sm.SetMetadata(addr, &statemanager.ContractMetadata{Collection: "secrets"})).To(Succeed())
+
+			key = binary.LeftPadWord256([]byte("key"))
+			val = binary.LeftPadWord256([]byte("storage-value"))
+			var err error
+			compKey, err = shim.CreateCompositeKey(statemanager.StorageObjectType, []string{addr.String(), hex.EncodeToString(key.Bytes())})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("writes the storage to the collection", func() {
+			sm.SetStorage(addr, key, val)
+			Expect(sm.Error()).ToNot(HaveOccurred())
+
+			Expect(mockStub.PutPrivateDataCallCount()).To(Equal(1))
+			collection, putKey, putVal := mockStub.PutPrivateDataArgsForCall(0)
+			Expect(collection).To(Equal("secrets"))
+			Expect(putKey).To(Equal(compKey))
+			Expect(putVal).To(Equal(val.Bytes()))
+
+			Expect(fakeLedger).ToNot(HaveKey(compKey))
+		})
+
+		It("reads the storage from the collection", func() {
+			fakePrivate["secrets/"+compKey] = val.Bytes()
+
+			// A new state does not have the storage cached
+			sm = statemanager.NewStateManager(mockStub)
+			Expect(sm.GetStorage(addr, key)).To(Equal(val))
+			Expect(sm.Error()).ToNot(HaveOccurred())
+
+			Expect(mockStub.GetPrivateDataCallCount()).To(Equal(1))
+		})
+
+		It("removes the metadata with the account", func() {
+			fakeLedger[addr.String()], _ = (&acm.Account{Address: addr}).Marshal()
+
+			sm.RemoveAccount(addr)
+			Expect(sm.Error()).ToNot(HaveOccurred())
+
+			Expect(fakeLedger).ToNot(HaveKey(addr.String() + ".metadata"))
+			Expect(fakeLedger).ToNot(HaveKey(addr.String()))
+		})
+
+		It("removes the storage from the collection without querying it", func() {
+			ledger := map[string][]byte{}
+			private := map[string]map[string][]byte{}
+
+			sm = statemanager.NewStateManager(privateLedgerStub(ledger, private))
+			Expect(sm.SetMetadata(addr, &statemanager.ContractMetadata{Collection: "secrets"})).To(Succeed())
+			ledger[addr.String()], _ = (&acm.Account{Address: addr}).Marshal()
+			sm.SetStorage(addr, key, val)
+			Expect(sm.Error()).ToNot(HaveOccurred())
+			Expect(private["secrets"]).To(HaveKey(compKey))
+
+			// The transaction removing the account writes before, which
+			// rules out queries of the collection
+			sm = statemanager.NewStateManager(privateLedgerStub(ledger, private))
+			sm.SetStorage(addr, binary.LeftPadWord256([]byte("other")), val)
+			sm.RemoveAccount(addr)
+			Expect(sm.Error()).ToNot(HaveOccurred())
+
+			Expect(private["secrets"]).To(BeEmpty())
+			for key := range ledger {
+				Expect(key).ToNot(ContainSubstring(statemanager.CollectionSlotObjectType))
+			}
+		})
+
+		It("indexes the slots set in the collection", func() {
+			sm.SetStorage(addr, key, val)
+			Expect(sm.Error()).ToNot(HaveOccurred())
+
+			indexKey, err := shim.CreateCompositeKey(statemanager.CollectionSlotObjectType, []string{addr.String(), hex.EncodeToString(key.Bytes())})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeLedger).To(HaveKey(indexKey))
+
+			sm.SetStorage(addr, key, binary.Zero256)
+			Expect(sm.Error()).ToNot(HaveOccurred())
+			Expect(fakeLedger).ToNot(HaveKey(indexKey))
+		})
+	})
+})
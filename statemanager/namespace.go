@@ -0,0 +1,296 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package statemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// namespacePrefix starts the keys of the namespaces. It sorts after the hex
+// digits and the other keys of the default namespace, so that the scans of
+// the slot keys of the default namespace do not walk the keys of the
+// namespaces.
+const namespacePrefix = "~"
+
+// namespaceSeparator separates a namespace from the keys it prefixes. It is
+// not a hex digit, so namespaced keys never collide with the keys of the
+// default namespace.
+const namespaceSeparator = "/"
+
+// namespaceStub isolates the world state of a namespace: every key read or
+// written is prefixed with it. Composite keys are prefixed in their object
+// type, so that they are still composite keys, and range and rich queries
+// are confined to the namespace.
+type namespaceStub struct {
+	shim.ChaincodeStubInterface
+	namespace string
+}
+
+// NewNamespaceStub returns a stub reading and writing the world state of
+// namespace. The empty namespace is the world state as it is.
+func NewNamespaceStub(stub shim.ChaincodeStubInterface, namespace string) shim.ChaincodeStubInterface {
+	if namespace == "" {
+		return stub
+	}
+	return &namespaceStub{ChaincodeStubInterface: stub, namespace: namespace}
+}
+
+// StubNamespace returns the namespace the stub reads and writes, empty for
+// the default namespace.
+func StubNamespace(stub shim.ChaincodeStubInterface) string {
+	switch s := stub.(type) {
+	case *namespaceStub:
+		return s.namespace
+	case *historyStub:
+		return StubNamespace(s.ChaincodeStubInterface)
+	}
+	return ""
+}
+
+// ValidateNamespace checks that namespace is a non empty name of lower case
+// letters, digits, '-' and '_', of at most 64 characters.
+func ValidateNamespace(namespace string) error {
+	if namespace == "" || len(namespace) > 64 {
+		return fmt.Errorf("namespace must be 1 to 64 characters long, got %d", len(namespace))
+	}
+
+	for _, c := range namespace {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("invalid character %q in namespace %s", c, namespace)
+		}
+	}
+
+	return nil
+}
+
+func (s *namespaceStub) key(key string) string {
+	if strings.HasPrefix(key, compositeKeySeparator) {
+		return compositeKeySeparator + s.objectType(key[len(compositeKeySeparator):])
+	}
+	return namespacePrefix + s.namespace + namespaceSeparator + key
+}
+
+func (s *namespaceStub) unkey(key string) string {
+	if strings.HasPrefix(key, compositeKeySeparator) {
+		return compositeKeySeparator + strings.TrimPrefix(key[len(compositeKeySeparator):], s.namespace+namespaceSeparator)
+	}
+	return strings.TrimPrefix(key, namespacePrefix+s.namespace+namespaceSeparator)
+}
+
+func (s *namespaceStub) objectType(objectType string) string {
+	return s.namespace + namespaceSeparator + objectType
+}
+
+// keyRange returns the range of the namespace covering the keys from start to
+// end. An empty end is the end of the namespace.
+func (s *namespaceStub) keyRange(start, end string) (string, string) {
+	if end == "" {
+		// The first key after the namespace
+		return s.key(start), namespacePrefix + s.namespace + string(namespaceSeparator[0]+1)
+	}
+	return s.key(start), s.key(end)
+}
+
+func (s *namespaceStub) GetState(key string) ([]byte, error) {
+	return s.ChaincodeStubInterface.GetState(s.key(key))
+}
+
+func (s *namespaceStub) PutState(key string, value []byte) error {
+	return s.ChaincodeStubInterface.PutState(s.key(key), value)
+}
+
+func (s *namespaceStub) DelState(key string) error {
+	return s.ChaincodeStubInterface.DelState(s.key(key))
+}
+
+func (s *namespaceStub) GetStateByRange(start, end string) (shim.StateQueryIteratorInterface, error) {
+	start, end = s.keyRange(start, end)
+	iter, err := s.ChaincodeStubInterface.GetStateByRange(start, end)
+	return s.iterator(iter, err)
+}
+
+func (s *namespaceStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	iter, err := s.ChaincodeStubInterface.GetStateByPartialCompositeKey(s.objectType(objectType), attributes)
+	return s.iterator(iter, err)
+}
+
+func (s *namespaceStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return s.ChaincodeStubInterface.GetHistoryForKey(s.key(key))
+}
+
+func (s *namespaceStub) GetPrivateData(collection, key string) ([]byte, error) {
+	return s.ChaincodeStubInterface.GetPrivateData(collection, s.key(key))
+}
+
+func (s *namespaceStub) PutPrivateData(collection, key string, value []byte) error {
+	return s.ChaincodeStubInterface.PutPrivateData(collection, s.key(key), value)
+}
+
+func (s *namespaceStub) DelPrivateData(collection, key string) error {
+	return s.ChaincodeStubInterface.DelPrivateData(collection, s.key(key))
+}
+
+func (s *namespaceStub) GetPrivateDataByRange(collection, start, end string) (shim.StateQueryIteratorInterface, error) {
+	start, end = s.keyRange(start, end)
+	iter, err := s.ChaincodeStubInterface.GetPrivateDataByRange(collection, start, end)
+	return s.iterator(iter, err)
+}
+
+func (s *namespaceStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	iter, err := s.ChaincodeStubInterface.GetPrivateDataByPartialCompositeKey(collection, s.objectType(objectType), attributes)
+	return s.iterator(iter, err)
+}
+
+func (s *namespaceStub) SetStateValidationParameter(key string, ep []byte) error {
+	return s.ChaincodeStubInterface.SetStateValidationParameter(s.key(key), ep)
+}
+
+func (s *namespaceStub) GetStateValidationParameter(key string) ([]byte, error) {
+	return s.ChaincodeStubInterface.GetStateValidationParameter(s.key(key))
+}
+
+func (s *namespaceStub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	return s.ChaincodeStubInterface.SetPrivateDataValidationParameter(collection, s.key(key), ep)
+}
+
+func (s *namespaceStub) GetPrivateDataValidationParameter(collection, key string) ([]byte, error) {
+	return s.ChaincodeStubInterface.GetPrivateDataValidationParameter(collection, s.key(key))
+}
+
+// The bookmarks of paginated queries are keys, they are given and returned
+// without the namespace like the keys of the results.
+
+func (s *namespaceStub) GetStateByRangeWithPagination(start, end string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	start, end = s.keyRange(start, end)
+	iter, metadata, err := s.ChaincodeStubInterface.GetStateByRangeWithPagination(start, end, pageSize, s.bookmark(bookmark))
+	return s.paginatedIterator(iter, metadata, err)
+}
+
+func (s *namespaceStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	iter, metadata, err := s.ChaincodeStubInterface.GetStateByPartialCompositeKeyWithPagination(s.objectType(objectType), keys, pageSize, s.bookmark(bookmark))
+	return s.paginatedIterator(iter, metadata, err)
+}
+
+// bookmark returns the bookmark of a paginated query in the namespace. An
+// empty bookmark starts the query.
+func (s *namespaceStub) bookmark(bookmark string) string {
+	if bookmark == "" {
+		return ""
+	}
+	return s.key(bookmark)
+}
+
+func (s *namespaceStub) paginatedIterator(iter shim.StateQueryIteratorInterface, metadata *pb.QueryResponseMetadata, err error) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if iter, err = s.iterator(iter, err); err != nil {
+		return nil, nil, err
+	}
+
+	if metadata != nil && metadata.Bookmark != "" {
+		metadata = &pb.QueryResponseMetadata{
+			FetchedRecordsCount: metadata.FetchedRecordsCount,
+			Bookmark:            s.unkey(metadata.Bookmark),
+		}
+	}
+	return iter, metadata, nil
+}
+
+// The selectors of rich queries also select the keys of the namespace. The
+// bookmarks of paginated rich queries are CouchDB bookmarks rather than keys,
+// they are given and returned as they are.
+
+func (s *namespaceStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	query, err := s.query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := s.ChaincodeStubInterface.GetQueryResult(query)
+	return s.iterator(iter, err)
+}
+
+func (s *namespaceStub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	query, err := s.query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := s.ChaincodeStubInterface.GetPrivateDataQueryResult(collection, query)
+	return s.iterator(iter, err)
+}
+
+func (s *namespaceStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	query, err := s.query(query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	iter, metadata, err := s.ChaincodeStubInterface.GetQueryResultWithPagination(query, pageSize, bookmark)
+	if iter, err = s.iterator(iter, err); err != nil {
+		return nil, nil, err
+	}
+	return iter, metadata, nil
+}
+
+// query returns a rich query selecting the documents its selector selects
+// among the keys of the namespace.
+func (s *namespaceStub) query(query string) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(query), &fields); err != nil {
+		return "", fmt.Errorf("invalid rich query: %s", err)
+	}
+
+	selector, ok := fields["selector"]
+	if !ok {
+		return "", fmt.Errorf("invalid rich query: no selector")
+	}
+
+	start, end := s.keyRange("", "")
+	confined, err := json.Marshal(map[string]interface{}{
+		"$and": []interface{}{
+			selector,
+			map[string]interface{}{"_id": map[string]string{"$gte": start, "$lt": end}},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal rich query: %s", err)
+	}
+	fields["selector"] = confined
+
+	doc, err := json.Marshal(fields)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal rich query: %s", err)
+	}
+	return string(doc), nil
+}
+
+func (s *namespaceStub) iterator(iter shim.StateQueryIteratorInterface, err error) (shim.StateQueryIteratorInterface, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &namespaceIterator{StateQueryIteratorInterface: iter, stub: s}, nil
+}
+
+// namespaceIterator returns the keys of a query without their namespace.
+type namespaceIterator struct {
+	shim.StateQueryIteratorInterface
+	stub *namespaceStub
+}
+
+func (i *namespaceIterator) Next() (*queryresult.KV, error) {
+	kv, err := i.StateQueryIteratorInterface.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return &queryresult.KV{Namespace: kv.Namespace, Key: i.stub.unkey(kv.Key), Value: kv.Value}, nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package statemanager_test
+
+import (
+	"strings"
+
+	"github.com/hyperledger/burrow/binary"
+	"github.com/hyperledger/burrow/crypto"
+
+	"github.com/hyperledger/fabric-chaincode-evm/mocks/evmcc"
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Namespace", func() {
+
+	var (
+		ledger map[string][]byte
+		addr   crypto.Address
+	)
+
+	BeforeEach(func() {
+		ledger = make(map[string][]byte)
+
+		var err error
+		addr, err = crypto.AddressFromBytes([]byte("0000000000000address"))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("prefixes every key with the namespace", func() {
+		sm := statemanager.NewStateManager(statemanager.NewNamespaceStub(ledgerStub(ledger), "one"))
+		sm.CreateAccount(addr)
+		sm.SetStorage(addr, binary.LeftPadWord256([]byte{1}), binary.LeftPadWord256([]byte{42}))
+		Expect(sm.Error()).ToNot(HaveOccurred())
+
+		Expect(ledger).To(HaveLen(3))
+		Expect(ledger).To(HaveKey("~one/" + addr.String()))
+		for key := range ledger {
+			if strings.HasPrefix(key, "\x00") {
+				Expect(key).To(HavePrefix("\x00one/"))
+			}
+		}
+	})
+
+	It("isolates the state of the namespaces", func() {
+		one := statemanager.NewStateManager(statemanager.NewNamespaceStub(ledgerStub(ledger), "one"))
+		one.CreateAccount(addr)
+		one.AddToBalance(addr, 10)
+		one.SetStorage(addr, binary.LeftPadWord256([]byte{1}), binary.LeftPadWord256([]byte{42}))
+		Expect(one.Error()).ToNot(HaveOccurred())
+
+		other := statemanager.NewStateManager(statemanager.NewNamespaceStub(ledgerStub(ledger), "other"))
+		Expect(other.Exists(addr)).To(BeFalse())
+		Expect(other.GetStorage(addr, binary.LeftPadWord256([]byte{1}))).To(Equal(binary.Zero256))
+
+		defaultState := statemanager.NewStateManager(ledgerStub(ledger))
+		Expect(defaultState.Exists(addr)).To(BeFalse())
+
+		one = statemanager.NewStateManager(statemanager.NewNamespaceStub(ledgerStub(ledger), "one"))
+		Expect(one.GetBalance(addr)).To(Equal(uint64(10)))
+		Expect(one.GetStorage(addr, binary.LeftPadWord256([]byte{1}))).To(Equal(binary.LeftPadWord256([]byte{42})))
+	})
+
+	It("lists and exports the state of the namespace only", func() {
+		stub := statemanager.NewNamespaceStub(ledgerStub(ledger), "one")
+		sm := statemanager.NewStateManager(stub)
+		sm.CreateAccount(addr)
+		sm.SetStorage(addr, binary.LeftPadWord256([]byte{1}), binary.LeftPadWord256([]byte{42}))
+		Expect(sm.Error()).ToNot(HaveOccurred())
+
+		defaultState := statemanager.NewStateManager(ledgerStub(ledger))
+		other, err := crypto.AddressFromBytes([]byte("0000000000000another"))
+		Expect(err).ToNot(HaveOccurred())
+		defaultState.CreateAccount(other)
+		Expect(defaultState.Error()).ToNot(HaveOccurred())
+
+		slots, _, err := statemanager.ListStorage(stub, addr, "", "", 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(slots).To(HaveLen(1))
+
+		snapshot, err := statemanager.ExportState(statemanager.NewReadOnlyStateManager(stub), "", 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshot.Accounts).To(HaveLen(1))
+		Expect(snapshot.Accounts[0].Address).To(Equal(strings.ToLower(addr.String())))
+	})
+
+	It("keeps the keys of the namespaces out of the scans of the default namespace", func() {
+		sm := statemanager.NewStateManager(statemanager.NewNamespaceStub(ledgerStub(ledger), "ab"))
+		sm.CreateAccount(addr)
+		Expect(sm.Error()).ToNot(HaveOccurred())
+
+		removed, next, err := statemanager.RemoveOrphanedStorage(ledgerStub(ledger), "", "", 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(Equal(0))
+		Expect(next).To(BeEmpty())
+	})
+
+	It("confines rich queries to the namespace", func() {
+		mockStub := &evmcc.MockStub{}
+		mockStub.GetQueryResultReturns(iterator(&queryresult.KV{Key: "~one/" + addr.String()}), nil)
+
+		iter, err := statemanager.NewNamespaceStub(mockStub, "one").GetQueryResult(`{"selector":{"type":"evmcc.account"},"limit":10}`)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(mockStub.GetQueryResultCallCount()).To(Equal(1))
+		Expect(mockStub.GetQueryResultArgsForCall(0)).To(MatchJSON(`{
+			"selector":{"$and":[{"type":"evmcc.account"},{"_id":{"$gte":"~one/","$lt":"~one0"}}]},
+			"limit":10
+		}`))
+
+		Expect(iter.HasNext()).To(BeTrue())
+		kv, err := iter.Next()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(kv.Key).To(Equal(addr.String()))
+	})
+
+	It("rejects rich queries without a selector", func() {
+		_, err := statemanager.NewNamespaceStub(&evmcc.MockStub{}, "one").GetQueryResult(`{"limit":10}`)
+		Expect(err).To(MatchError(ContainSubstring("no selector")))
+	})
+
+	It("records the namespace of JSON encoded accounts", func() {
+		sm := statemanager.NewStateManager(statemanager.NewNamespaceStub(ledgerStub(ledger), "one"),
+			statemanager.WithAccountEncoding(statemanager.AccountEncodingJSON))
+		sm.CreateAccount(addr)
+		Expect(sm.Error()).ToNot(HaveOccurred())
+
+		Expect(string(ledger["~one/"+addr.String()])).To(ContainSubstring(`"namespace":"one"`))
+	})
+
+	Describe("ValidateNamespace", func() {
+		It("accepts lower case names", func() {
+			Expect(statemanager.ValidateNamespace("network-1_a")).To(Succeed())
+		})
+
+		It("rejects empty names, separators and upper case letters", func() {
+			Expect(statemanager.ValidateNamespace("")).ToNot(Succeed())
+			Expect(statemanager.ValidateNamespace("one/two")).ToNot(Succeed())
+			Expect(statemanager.ValidateNamespace("One")).ToNot(Succeed())
+			Expect(statemanager.ValidateNamespace(strings.Repeat("a", 65))).ToNot(Succeed())
+		})
+	})
+})
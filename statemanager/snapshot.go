@@ -0,0 +1,284 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package statemanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/burrow/binary"
+	"github.com/hyperledger/burrow/crypto"
+	"github.com/hyperledger/fabric-chaincode-evm/envelope"
+)
+
+// exportStoragePageSize is the number of storage slots read at once when an
+// account is exported.
+const exportStoragePageSize = 1000
+
+// exportDigestPageSize is the number of accounts read at once when the digest
+// of the state is computed.
+const exportDigestPageSize = 100
+
+var zeroSlot = hex.EncodeToString(binary.Zero256.Bytes())
+
+// Snapshot is a page of the accounts of an instance, exported so that they
+// can be imported by an instance on another channel. Checksum is the hex
+// encoded SHA-256 hash of the JSON encoded accounts, and Next is the address
+// to continue the export from, empty on the last page.
+type Snapshot struct {
+	Accounts []SnapshotAccount `json:"accounts"`
+	Checksum string            `json:"checksum"`
+	Next     string            `json:"next,omitempty"`
+}
+
+// SnapshotAccount is an exported account, along with its storage, sequence
+// and contract metadata.
+type SnapshotAccount struct {
+	GenesisAccount
+	Sequence uint64            `json:"sequence,omitempty"`
+	Metadata *ContractMetadata `json:"metadata,omitempty"`
+}
+
+// ExportState returns the accounts of the public world state from the address
+// start, at most limit of them, in the order of their addresses. The storage of
+// contracts kept in private data collections is read from their collection.
+//
+// The accounts are listed from their index with paginated queries, so
+// ExportState can only be used by transactions that do not write. Next is the
+// address of the account following the page, whenever there is one. Accounts
+// written by versions of the chaincode that did not index them are indexed by
+// MigrateStorage.
+func ExportState(st StateManager, start string, limit int) (*Snapshot, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit must be positive, got %d", limit)
+	}
+
+	bookmark := ""
+	if start != "" {
+		address, err := crypto.AddressFromHexString(envelope.Strip0x(start))
+		if err != nil {
+			return nil, fmt.Errorf("invalid start %s: %s", start, err)
+		}
+
+		if bookmark, err = accountIndexKey(address.String()); err != nil {
+			return nil, err
+		}
+	}
+
+	iter, metadata, err := st.Stub().GetStateByPartialCompositeKeyWithPagination(AccountIndexObjectType, []string{}, int32(limit), bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	snapshot := &Snapshot{Accounts: []SnapshotAccount{}}
+
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		key, ok := splitAccountIndexKey(kv.Key)
+		if !ok {
+			continue
+		}
+
+		acc, err := exportAccount(st, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export account %s: %s", key, err)
+		}
+		snapshot.Accounts = append(snapshot.Accounts, *acc)
+	}
+
+	// The bookmark is the index of the next account, empty once the query is
+	// exhausted
+	snapshot.Next, _ = splitAccountIndexKey(metadata.GetBookmark())
+
+	if snapshot.Checksum, err = snapshot.checksum(); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// StateDigest is the digest of the whole public world state of an instance.
+// Digest is the hex encoded SHA-256 hash of the accounts, exported the way
+// ExportState does and JSON encoded one after the other, so that an instance
+// importing the snapshots of another ends up with the same digest.
+type StateDigest struct {
+	Accounts int    `json:"accounts"`
+	Digest   string `json:"digest"`
+}
+
+// DigestState returns the digest of the accounts of the public world state,
+// read page by page like ExportState reads them.
+func DigestState(st StateManager) (*StateDigest, error) {
+	hash := sha256.New()
+	digest := &StateDigest{}
+
+	start := ""
+	for {
+		snapshot, err := ExportState(st, start, exportDigestPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, acc := range snapshot.Accounts {
+			doc, err := json.Marshal(acc)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal account %s: %s", acc.Address, err)
+			}
+			hash.Write(doc)
+		}
+		digest.Accounts += len(snapshot.Accounts)
+
+		if snapshot.Next == "" {
+			digest.Digest = hex.EncodeToString(hash.Sum(nil))
+			return digest, nil
+		}
+		start = snapshot.Next
+	}
+}
+
+func exportAccount(st StateManager, key string) (*SnapshotAccount, error) {
+	address, err := crypto.AddressFromHexString(key)
+	if err != nil {
+		return nil, err
+	}
+
+	acc, err := st.GetAccount(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if acc == nil {
+		return nil, fmt.Errorf("no account at %s", address)
+	}
+
+	metadata, err := st.GetMetadata(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var collection string
+	if metadata != nil {
+		collection = metadata.Collection
+	}
+
+	exported := &SnapshotAccount{
+		GenesisAccount: NewGenesisAccount(acc),
+		Sequence:       acc.Sequence,
+		Metadata:       metadata,
+	}
+
+	bookmark := ""
+	for {
+		var slots []StorageSlot
+		slots, bookmark, err = ListStorage(st.Stub(), address, collection, bookmark, exportStoragePageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, slot := range slots {
+			// Slots set to zero read the same as the ones deleted
+			if slot.Value == zeroSlot {
+				continue
+			}
+
+			if exported.Storage == nil {
+				exported.Storage = make(map[string]string)
+			}
+			exported.Storage[slot.Slot] = slot.Value
+		}
+
+		if bookmark == "" {
+			return exported, nil
+		}
+	}
+}
+
+// Apply imports the accounts of the snapshot, once its checksum is verified.
+// As for a genesis, an error is returned if any of the accounts is invalid or
+// already exists, so that the transaction can be aborted.
+func (s *Snapshot) Apply(st StateManager) error {
+	checksum, err := s.checksum()
+	if err != nil {
+		return err
+	}
+
+	if checksum != s.Checksum {
+		return fmt.Errorf("checksum %s does not match the accounts, expected %s", s.Checksum, checksum)
+	}
+
+	g := &Genesis{Accounts: make([]GenesisAccount, 0, len(s.Accounts))}
+	for _, acc := range s.Accounts {
+		g.Accounts = append(g.Accounts, acc.GenesisAccount)
+	}
+
+	accounts, err := g.parse()
+	if err != nil {
+		return err
+	}
+
+	for i, acc := range accounts {
+		acc.sequence = s.Accounts[i].Sequence
+	}
+
+	// The metadata is set first, so that the storage of private contracts
+	// is written to their collection
+	for _, acc := range s.Accounts {
+		if acc.Metadata == nil {
+			continue
+		}
+
+		address, err := crypto.AddressFromHexString(envelope.Strip0x(acc.Address))
+		if err != nil {
+			return fmt.Errorf("invalid account %s: %s", acc.Address, err)
+		}
+
+		if err = st.SetMetadata(address, acc.Metadata); err != nil {
+			return fmt.Errorf("failed to set metadata of account %s: %s", acc.Address, err)
+		}
+	}
+
+	return applyAccounts(st, accounts)
+}
+
+func (s *Snapshot) checksum() (string, error) {
+	doc, err := json.Marshal(s.Accounts)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal accounts: %s", err)
+	}
+
+	sum := sha256.Sum256(doc)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// isAccountKey reports whether key is the key of an account, its hex encoded
+// address.
+func isAccountKey(key string) bool {
+	if len(key) != 2*crypto.AddressLength {
+		return false
+	}
+
+	_, err := hex.DecodeString(key)
+	return err == nil
+}
+
+// splitAccountIndexKey returns the key of the account indexed by the
+// composite key key.
+func splitAccountIndexKey(key string) (string, bool) {
+	parts := strings.Split(key, compositeKeySeparator)
+	if len(parts) != 4 || parts[1] != AccountIndexObjectType || !isAccountKey(parts[2]) {
+		return "", false
+	}
+	return parts[2], true
+}
@@ -0,0 +1,189 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package statemanager_test
+
+import (
+	"strings"
+
+	"github.com/hyperledger/burrow/acm"
+	"github.com/hyperledger/burrow/crypto"
+
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Snapshot", func() {
+
+	var (
+		source, target map[string][]byte
+		addr1, addr2   crypto.Address
+	)
+
+	BeforeEach(func() {
+		source = make(map[string][]byte)
+		target = make(map[string][]byte)
+
+		var err error
+		addr1, err = crypto.AddressFromBytes([]byte("0000000000000address"))
+		Expect(err).ToNot(HaveOccurred())
+		addr2, err = crypto.AddressFromBytes([]byte("0000000000000another"))
+		Expect(err).ToNot(HaveOccurred())
+
+		sm := statemanager.NewStateManager(ledgerStub(source))
+		genesis := &statemanager.Genesis{
+			Accounts: []statemanager.GenesisAccount{
+				{
+					Address: addr1.String(),
+					Code:    "6001",
+					Storage: map[string]string{"0x01": "0x2a", "0x02": "0x2b"},
+					Roles:   []string{"registry"},
+				},
+				{
+					Address: addr2.String(),
+					Balance: 100,
+				},
+			},
+		}
+		Expect(genesis.Apply(sm)).To(Succeed())
+
+		sm.IncSequence(addr2)
+		Expect(sm.SetMetadata(addr1, &statemanager.ContractMetadata{PreviousCodeHashes: []string{"abcd"}})).To(Succeed())
+		Expect(sm.Error()).ToNot(HaveOccurred())
+	})
+
+	It("exports the accounts with their storage, sequence and metadata", func() {
+		snapshot, err := statemanager.ExportState(statemanager.NewReadOnlyStateManager(ledgerStub(source)), "", 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshot.Next).To(BeEmpty())
+		Expect(snapshot.Checksum).ToNot(BeEmpty())
+
+		Expect(snapshot.Accounts).To(HaveLen(2))
+		byAddress := map[string]statemanager.SnapshotAccount{}
+		for _, acc := range snapshot.Accounts {
+			byAddress[acc.Address] = acc
+		}
+
+		acc1 := byAddress[strings.ToLower(addr1.String())]
+		Expect(acc1.Code).To(Equal("6001"))
+		Expect(acc1.Storage).To(HaveLen(2))
+		Expect(acc1.Roles).To(ConsistOf("registry"))
+		Expect(acc1.Metadata.PreviousCodeHashes).To(Equal([]string{"abcd"}))
+
+		acc2 := byAddress[strings.ToLower(addr2.String())]
+		Expect(acc2.Balance).To(Equal(uint64(100)))
+		Expect(acc2.Sequence).To(Equal(uint64(1)))
+	})
+
+	It("exports the accounts page by page", func() {
+		state := statemanager.NewReadOnlyStateManager(ledgerStub(source))
+
+		first, err := statemanager.ExportState(state, "", 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(first.Accounts).To(HaveLen(1))
+		Expect(first.Next).ToNot(BeEmpty())
+
+		second, err := statemanager.ExportState(state, first.Next, 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(second.Accounts).To(HaveLen(1))
+		Expect(second.Next).To(BeEmpty())
+
+		Expect(second.Accounts[0].Address).ToNot(Equal(first.Accounts[0].Address))
+	})
+
+	It("imports a snapshot to the same state", func() {
+		snapshot, err := statemanager.ExportState(statemanager.NewReadOnlyStateManager(ledgerStub(source)), "", 10)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(snapshot.Apply(statemanager.NewStateManager(ledgerStub(target)))).To(Succeed())
+
+		imported, err := statemanager.ExportState(statemanager.NewReadOnlyStateManager(ledgerStub(target)), "", 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(imported.Checksum).To(Equal(snapshot.Checksum))
+		Expect(imported.Accounts).To(Equal(snapshot.Accounts))
+	})
+
+	It("writes each imported account once", func() {
+		snapshot, err := statemanager.ExportState(statemanager.NewReadOnlyStateManager(ledgerStub(source)), "", 10)
+		Expect(err).ToNot(HaveOccurred())
+
+		stub := ledgerStub(target)
+		Expect(snapshot.Apply(statemanager.NewStateManager(stub))).To(Succeed())
+
+		writes := 0
+		for i := 0; i < stub.PutStateCallCount(); i++ {
+			if key, _ := stub.PutStateArgsForCall(i); key == addr2.String() {
+				writes++
+			}
+		}
+		Expect(writes).To(Equal(1))
+
+		acc, err := statemanager.NewStateManager(stub).GetAccount(addr2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(acc.Sequence).To(Equal(uint64(1)))
+	})
+
+	It("lists the accounts from their index", func() {
+		stub := ledgerStub(source)
+
+		snapshot, err := statemanager.ExportState(statemanager.NewReadOnlyStateManager(stub), "", 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshot.Accounts).To(HaveLen(2))
+
+		Expect(stub.GetStateByRangeCallCount()).To(Equal(0))
+		objectType, _, pageSize, _ := stub.GetStateByPartialCompositeKeyWithPaginationArgsForCall(0)
+		Expect(objectType).To(Equal(statemanager.AccountIndexObjectType))
+		Expect(pageSize).To(Equal(int32(10)))
+	})
+
+	It("digests the state the same way once imported", func() {
+		digest, err := statemanager.DigestState(statemanager.NewReadOnlyStateManager(ledgerStub(source)))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(digest.Accounts).To(Equal(2))
+
+		snapshot, err := statemanager.ExportState(statemanager.NewReadOnlyStateManager(ledgerStub(source)), "", 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshot.Apply(statemanager.NewStateManager(ledgerStub(target)))).To(Succeed())
+
+		partial, err := statemanager.DigestState(statemanager.NewReadOnlyStateManager(ledgerStub(target)))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(partial.Digest).ToNot(Equal(digest.Digest))
+
+		snapshot, err = statemanager.ExportState(statemanager.NewReadOnlyStateManager(ledgerStub(source)), snapshot.Next, 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshot.Apply(statemanager.NewStateManager(ledgerStub(target)))).To(Succeed())
+
+		imported, err := statemanager.DigestState(statemanager.NewReadOnlyStateManager(ledgerStub(target)))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(imported).To(Equal(digest))
+	})
+
+	It("rejects an account that has only received value", func() {
+		snapshot, err := statemanager.ExportState(statemanager.NewReadOnlyStateManager(ledgerStub(source)), "", 10)
+		Expect(err).ToNot(HaveOccurred())
+
+		target[addr1.String()], err = (&acm.Account{Address: addr1, Balance: 5}).Marshal()
+		Expect(err).ToNot(HaveOccurred())
+
+		err = snapshot.Apply(statemanager.NewStateManager(ledgerStub(target)))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("already exists"))
+	})
+
+	It("rejects a snapshot that does not match its checksum", func() {
+		snapshot, err := statemanager.ExportState(statemanager.NewReadOnlyStateManager(ledgerStub(source)), "", 10)
+		Expect(err).ToNot(HaveOccurred())
+
+		snapshot.Accounts[0].Balance++
+
+		err = snapshot.Apply(statemanager.NewStateManager(ledgerStub(target)))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("does not match the accounts"))
+		Expect(target).To(BeEmpty())
+	})
+})
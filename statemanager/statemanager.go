@@ -7,9 +7,10 @@ SPDX-License-Identifier: Apache-2.0
 package statemanager
 
 import (
-	"encoding/hex"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/go-stack/stack"
 	"github.com/hyperledger/burrow/acm"
@@ -36,34 +37,92 @@ var ContractPerms = permission.AccountPermissions{
 type StateManager interface {
 	evm.Interface
 	GetAccount(address crypto.Address) (*acm.Account, error)
+	// GetMetadata returns the metadata of a contract, or nil if it has none.
+	GetMetadata(address crypto.Address) (*ContractMetadata, error)
+	SetMetadata(address crypto.Address, metadata *ContractMetadata) error
+	// CreateContractAccount creates the account of a contract being deployed,
+	// keeping the account at its address if it has only received value.
+	CreateContractAccount(address crypto.Address)
+	// UpgradeCode replaces the code of a contract, keeping its storage.
+	UpgradeCode(address crypto.Address, code []byte)
+	// ImportAccount creates an account with its balance, code, sequence and
+	// permissions in a single write, as a genesis or a snapshot does. The
+	// address must not be in use.
+	ImportAccount(account *acm.Account)
+	// Stub returns the chaincode stub the state is read from and written to.
+	Stub() shim.ChaincodeStubInterface
+	// ReadOnly tells if the writes of the frame fail, as they do in the frames
+	// of a query or a STATICCALL.
+	ReadOnly() bool
 }
 
+// stateManager is a frame of the state of a transaction. The root frame,
+// returned by NewStateManager, writes through to the stub. The vm runs each
+// call in a child frame, created by NewCache, which keeps its writes until it
+// is synced into its parent, so that the writes of a call that fails are
+// discarded along with its frame. Writes reach the stub once the outermost
+// frame is synced into the root.
+//
+// Frames are single threaded because the statemanager is 1-1 with the evm
+// which is single threaded.
 type stateManager struct {
 	stub shim.ChaincodeStubInterface
-	// We will be looking into adding a storageCache for accounts later
-	// The storageCache can be single threaded because the statemanager is 1-1 with the evm which is single threaded.
-	storageCache map[string]binary.Word256
-	accountCache map[string][]byte
-	error        errors.CodedError
-	readonly     bool
+	// parent is the frame a child frame is synced into, nil for the root.
+	parent *stateManager
+	// The caches hold the writes of the frame, nil accounts and metadata
+	// are removed entries. The root also caches the metadata it reads, nil
+	// entries being contracts without metadata.
+	storageCache  map[string]binary.Word256
+	accountCache  map[string][]byte
+	metadataCache map[string]*ContractMetadata
+	// removedStorage holds the accounts whose storage has been removed by the
+	// frame, with the collection it was kept in.
+	removedStorage map[string]string
+	error          errors.CodedError
+	readonly       bool
+	// jsonAccounts writes accounts as JSON documents rather than protobuf
+	// messages.
+	jsonAccounts bool
 }
 
-func NewStateManager(stub shim.ChaincodeStubInterface) StateManager {
-	return &stateManager{
-		stub:         stub,
-		accountCache: make(map[string][]byte),
-		storageCache: make(map[string]binary.Word256),
+func NewStateManager(stub shim.ChaincodeStubInterface, opts ...Option) StateManager {
+	st := newFrame(stub, nil)
+	for _, opt := range opts {
+		opt(st)
 	}
+	return st
+}
+
+func newFrame(stub shim.ChaincodeStubInterface, parent *stateManager) *stateManager {
+	st := &stateManager{
+		stub:           stub,
+		parent:         parent,
+		accountCache:   make(map[string][]byte),
+		storageCache:   make(map[string]binary.Word256),
+		metadataCache:  make(map[string]*ContractMetadata),
+		removedStorage: make(map[string]string),
+	}
+	if parent != nil {
+		st.jsonAccounts = parent.jsonAccounts
+	}
+	return st
+}
+
+// NewReadOnlyStateManager returns a StateManager failing every write with an
+// illegal write error, to run queries.
+func NewReadOnlyStateManager(stub shim.ChaincodeStubInterface, opts ...Option) StateManager {
+	st := NewStateManager(stub, opts...).(*stateManager)
+	st.readonly = true
+	return st
 }
 
 ///// ----------------------------------
 
+// NewCache returns a child frame of the state, whose writes are discarded
+// unless it is synced.
 func (st *stateManager) NewCache(cacheOptions ...state.CacheOption) evm.Interface {
-	newState := &stateManager{
-		stub:         st.stub,
-		accountCache: st.accountCache,
-		storageCache: st.storageCache,
-	}
+	newState := newFrame(st.stub, st)
+	newState.readonly = st.readonly
 
 	for _, option := range cacheOptions {
 		if reflect.ValueOf(option) == reflect.ValueOf(state.ReadOnly) {
@@ -74,21 +133,75 @@ func (st *stateManager) NewCache(cacheOptions ...state.CacheOption) evm.Interfac
 	return newState
 }
 
-// Stub function
+func (st *stateManager) Stub() shim.ChaincodeStubInterface {
+	return st.stub
+}
+
+func (st *stateManager) ReadOnly() bool {
+	return st.readonly
+}
+
+// Sync writes the frame into its parent, and to the stub when the parent is
+// the root. The root frame has already written through to the stub.
 func (st *stateManager) Sync() errors.CodedError {
 	// Do not sync if we have erred
 	if st.error != nil {
 		return st.error
 	}
 
-	//err := st.storageCache.Sync(st.backend)
-	//if err != nil {
-	//	return errors.AsException(err)
-	//}
+	if st.parent == nil {
+		return nil
+	}
+
+	if err := st.syncInto(st.parent); err != nil {
+		return errors.AsException(err)
+	}
+
+	return nil
+}
+
+// syncInto writes the frame into parent. The metadata is written first, so
+// that the storage of a contract is written to its collection.
+func (st *stateManager) syncInto(parent *stateManager) error {
+	for _, key := range sortedKeys(st.metadataCache) {
+		if err := parent.putMetadata(key, st.metadataCache[key]); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range sortedKeys(st.accountCache) {
+		if err := parent.putAccount(key, st.accountCache[key]); err != nil {
+			return err
+		}
+	}
+
+	for _, address := range sortedKeys(st.removedStorage) {
+		if err := parent.clearStorage(address, st.removedStorage[address]); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range sortedKeys(st.storageCache) {
+		if err := parent.putStorage(key[:2*crypto.AddressLength], key, st.storageCache[key]); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
+// sortedKeys returns the keys of a cache in order, so that every endorser
+// writes the state the same way.
+func sortedKeys(cache interface{}) []string {
+	v := reflect.ValueOf(cache)
+	keys := make([]string, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (st *stateManager) Error() errors.CodedError {
 	if st.error == nil {
 		return nil
@@ -110,20 +223,51 @@ func (st *stateManager) PushError(err error) {
 // Reader
 
 func (s *stateManager) GetStorage(address crypto.Address, key binary.Word256) binary.Word256 {
-	compKey := address.String() + hex.EncodeToString(key.Bytes())
+	slot := slotKey(address, key)
 
-	if val, ok := s.storageCache[compKey]; ok {
-		return val
+	for frame := s; frame != nil; frame = frame.parent {
+		if val, ok := frame.storageCache[slot]; ok {
+			return val
+		}
+		if _, ok := frame.removedStorage[address.String()]; ok {
+			return binary.Zero256
+		}
 	}
 
-	val, err := s.stub.GetState(compKey)
+	collection, err := s.collection(address)
+	if err != nil {
+		s.PushError(err)
 
+		return binary.Zero256
+	}
+
+	compKey, err := storageKey(slot)
 	if err != nil {
 		s.PushError(err)
 
 		return binary.Zero256
 	}
 
+	// Slots that have not been migrated to composite keys are still read
+	var val []byte
+	for _, key := range []string{compKey, slot} {
+		if collection == "" {
+			val, err = s.stub.GetState(key)
+		} else {
+			val, err = s.stub.GetPrivateData(collection, key)
+		}
+
+		if err != nil {
+			s.PushError(err)
+
+			return binary.Zero256
+		}
+
+		if len(val) != 0 {
+			break
+		}
+	}
+
 	return binary.LeftPadWord256(val)
 }
 
@@ -176,9 +320,31 @@ func (st *stateManager) GetSequence(address crypto.Address) uint64 {
 
 // Writer
 
+// CreateAccount creates an account at an address that is not in use. The vm
+// only creates accounts in the frames of a transaction, for the contracts it
+// deploys, which are created the way CreateContractAccount does.
 func (st *stateManager) CreateAccount(address crypto.Address) {
-	if st.Exists(address) {
-		acc, _ := st.GetAccount(address)
+	st.createAccount(address, st.parent != nil)
+}
+
+// CreateContractAccount creates the account of a contract being deployed. An
+// address is in use once it has code or a sequence, so an account that has
+// only received value, e.g. at the address of a contract yet to be deployed,
+// is left as is.
+func (st *stateManager) CreateContractAccount(address crypto.Address) {
+	st.createAccount(address, true)
+}
+
+func (st *stateManager) createAccount(address crypto.Address, contract bool) {
+	if !st.writable(address) {
+		return
+	}
+
+	acc := st.account(address)
+	if acc != nil {
+		if contract && len(acc.Code) == 0 && acc.Sequence == 0 {
+			return
+		}
 
 		st.PushError(errors.ErrorCodef(errors.ErrorCodeDuplicateAddress,
 			"tried to create an account at an address that already exists: %v %v", address, acc))
@@ -188,7 +354,25 @@ func (st *stateManager) CreateAccount(address crypto.Address) {
 	st.updateAccount(&acm.Account{Address: address, Permissions: ContractPerms})
 }
 
+func (st *stateManager) ImportAccount(account *acm.Account) {
+	if !st.writable(account.Address) {
+		return
+	}
+
+	if st.account(account.Address) != nil {
+		st.PushError(errors.ErrorCodef(errors.ErrorCodeDuplicateAddress,
+			"tried to import an account at an address that already exists: %v", account.Address))
+		return
+	}
+
+	st.updateAccount(account)
+}
+
 func (st *stateManager) InitCode(address crypto.Address, code []byte) {
+	if !st.writable(address) {
+		return
+	}
+
 	acc := st.mustAccount(address)
 	if acc == nil {
 		st.PushError(errors.ErrorCodef(errors.ErrorCodeInvalidAddress,
@@ -204,7 +388,29 @@ func (st *stateManager) InitCode(address crypto.Address, code []byte) {
 	st.updateAccount(acc)
 }
 
+func (st *stateManager) UpgradeCode(address crypto.Address, code []byte) {
+	if !st.writable(address) {
+		return
+	}
+
+	acc := st.mustAccount(address)
+	if acc == nil {
+		return
+	}
+	if acc.Code == nil || acc.Code.Size() == 0 {
+		st.PushError(errors.ErrorCodef(errors.ErrorCodeIllegalWrite,
+			"tried to upgrade the code of an account that has none: %v", address))
+		return
+	}
+	acc.Code = code
+	st.updateAccount(acc)
+}
+
 func (st *stateManager) RemoveAccount(address crypto.Address) {
+	if !st.writable(address) {
+		return
+	}
+
 	if !st.Exists(address) {
 		st.PushError(errors.ErrorCodef(errors.ErrorCodeDuplicateAddress,
 			"tried to remove an account at an address that does not exist: %v", address))
@@ -219,18 +425,20 @@ func (st *stateManager) RemoveAccount(address crypto.Address) {
 }
 
 func (s *stateManager) SetStorage(address crypto.Address, key, value binary.Word256) {
-	var err error
-
-	if err = s.stub.PutState(address.String()+hex.EncodeToString(key.Bytes()), value.Bytes()); err == nil {
-		s.storageCache[address.String()+hex.EncodeToString(key.Bytes())] = value
+	if !s.writable(address) {
+		return
 	}
 
-	if err != nil {
+	if err := s.putStorage(address.String(), slotKey(address, key), value); err != nil {
 		s.PushError(err)
 	}
 }
 
 func (st *stateManager) AddToBalance(address crypto.Address, amount uint64) {
+	if !st.writable(address) {
+		return
+	}
+
 	acc := st.mustAccount(address)
 	if acc == nil {
 		return
@@ -246,6 +454,10 @@ func (st *stateManager) AddToBalance(address crypto.Address, amount uint64) {
 }
 
 func (st *stateManager) SubtractFromBalance(address crypto.Address, amount uint64) {
+	if !st.writable(address) {
+		return
+	}
+
 	acc := st.mustAccount(address)
 	if acc == nil {
 		return
@@ -261,6 +473,10 @@ func (st *stateManager) SubtractFromBalance(address crypto.Address, amount uint6
 }
 
 func (st *stateManager) SetPermission(address crypto.Address, permFlag permission.PermFlag, value bool) {
+	if !st.writable(address) {
+		return
+	}
+
 	acc := st.mustAccount(address)
 	if acc == nil {
 		return
@@ -270,6 +486,10 @@ func (st *stateManager) SetPermission(address crypto.Address, permFlag permissio
 }
 
 func (st *stateManager) UnsetPermission(address crypto.Address, permFlag permission.PermFlag) {
+	if !st.writable(address) {
+		return
+	}
+
 	acc := st.mustAccount(address)
 	if acc == nil {
 		return
@@ -279,6 +499,10 @@ func (st *stateManager) UnsetPermission(address crypto.Address, permFlag permiss
 }
 
 func (st *stateManager) AddRole(address crypto.Address, role string) bool {
+	if !st.writable(address) {
+		return false
+	}
+
 	acc := st.mustAccount(address)
 	if acc == nil {
 		return false
@@ -289,6 +513,10 @@ func (st *stateManager) AddRole(address crypto.Address, role string) bool {
 }
 
 func (st *stateManager) RemoveRole(address crypto.Address, role string) bool {
+	if !st.writable(address) {
+		return false
+	}
+
 	acc := st.mustAccount(address)
 	if acc == nil {
 		return false
@@ -299,6 +527,10 @@ func (st *stateManager) RemoveRole(address crypto.Address, role string) bool {
 }
 
 func (st *stateManager) IncSequence(address crypto.Address) {
+	if !st.writable(address) {
+		return
+	}
+
 	acc := st.mustAccount(address)
 	if acc == nil {
 		return
@@ -310,12 +542,9 @@ func (st *stateManager) IncSequence(address crypto.Address) {
 ///// ----------------------------------
 
 func (s *stateManager) GetAccount(address crypto.Address) (*acm.Account, error) {
-	var serializedAccount []byte
-	var err error
-
-	if val, ok := s.accountCache[address.String()]; ok {
-		serializedAccount = val
-	} else {
+	serializedAccount, cached := s.cachedAccount(address.String())
+	if !cached {
+		var err error
 		serializedAccount, err = s.stub.GetState(address.String())
 
 		if err != nil {
@@ -327,17 +556,18 @@ func (s *stateManager) GetAccount(address crypto.Address) (*acm.Account, error)
 		return nil, nil
 	}
 
-	acct := acm.Account{
-		Address: address,
-	}
-
-	err = acct.Unmarshal(serializedAccount)
+	return decodeAccount(address, serializedAccount)
+}
 
-	if err != nil {
-		return nil, err
+// cachedAccount returns the account written by the frame or the frames it
+// belongs to, nil for a removed account.
+func (st *stateManager) cachedAccount(key string) ([]byte, bool) {
+	for frame := st; frame != nil; frame = frame.parent {
+		if val, ok := frame.accountCache[key]; ok {
+			return val, true
+		}
 	}
-
-	return &acct, nil
+	return nil, false
 }
 
 func (st *stateManager) account(address crypto.Address) *acm.Account {
@@ -349,7 +579,7 @@ func (st *stateManager) account(address crypto.Address) *acm.Account {
 }
 
 func (st *stateManager) updateAccount(updatedAccount *acm.Account) {
-	serializedAccount, err := updatedAccount.Marshal()
+	serializedAccount, err := st.encodeAccount(updatedAccount)
 
 	if err != nil {
 		st.PushError(err)
@@ -357,21 +587,267 @@ func (st *stateManager) updateAccount(updatedAccount *acm.Account) {
 		return
 	}
 
-	st.accountCache[updatedAccount.Address.String()] = serializedAccount
-
-	err = st.stub.PutState(updatedAccount.Address.String(), serializedAccount)
+	err = st.putAccount(updatedAccount.Address.String(), serializedAccount)
 
 	if err != nil {
 		st.PushError(err)
 	}
 }
 
+// putAccount writes an account to the frame, a nil account removes it. The
+// root indexes the accounts it writes that are not in the ledger yet, and
+// removes the index of the accounts it removes.
+func (st *stateManager) putAccount(key string, serializedAccount []byte) error {
+	if st.parent == nil {
+		indexKey, err := accountIndexKey(key)
+		if err != nil {
+			return err
+		}
+
+		if serializedAccount == nil {
+			if err = st.stub.DelState(key); err != nil {
+				return err
+			}
+			if err = st.stub.DelState(indexKey); err != nil {
+				return err
+			}
+		} else {
+			committed, err := st.stub.GetState(key)
+			if err != nil {
+				return err
+			}
+			if err = st.stub.PutState(key, serializedAccount); err != nil {
+				return err
+			}
+			if len(committed) == 0 {
+				if err = st.stub.PutState(indexKey, []byte{1}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	st.accountCache[key] = serializedAccount
+	return nil
+}
+
+// putStorage writes a storage slot of account to the frame, the root writes
+// it at its composite key. A slot set to zero is deleted from the ledger,
+// along with the value it may have kept at its slot key, since reading it
+// returns zero either way. The slots kept in a collection are indexed in the
+// public world state while they are set.
+func (st *stateManager) putStorage(account, slot string, value binary.Word256) error {
+	if st.parent == nil {
+		address, err := crypto.AddressFromHexString(account)
+		if err != nil {
+			return err
+		}
+
+		collection, err := st.collection(address)
+		if err != nil {
+			return err
+		}
+
+		compKey, err := storageKey(slot)
+		if err != nil {
+			return err
+		}
+
+		if value == binary.Zero256 {
+			err = st.deleteStorage(collection, compKey, slot)
+			if err == nil && collection != "" {
+				err = unindexSlot(st.stub, slot)
+			}
+		} else if collection == "" {
+			err = st.stub.PutState(compKey, value.Bytes())
+		} else {
+			err = st.stub.PutPrivateData(collection, compKey, value.Bytes())
+			if err == nil {
+				err = indexSlot(st.stub, slot)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	st.storageCache[slot] = value
+	return nil
+}
+
 func (s *stateManager) removeAccount(address crypto.Address) error {
-	if _, ok := s.accountCache[address.String()]; ok {
-		delete(s.accountCache, address.String())
+	// The storage is removed before the metadata locating it
+	collection, err := s.collection(address)
+	if err != nil {
+		return err
+	}
+
+	if err = s.clearStorage(address.String(), collection); err != nil {
+		return err
+	}
+
+	if err = s.removeMetadata(address); err != nil {
+		return err
+	}
+
+	return s.putAccount(address.String(), nil)
+}
+
+// clearStorage removes the storage of account from the frame. The root
+// deletes the slots committed to the ledger as well as the ones written by
+// the transaction, which range queries do not return.
+func (s *stateManager) clearStorage(account, collection string) error {
+	if s.parent == nil {
+		if err := s.removeStorage(account, collection); err != nil {
+			return err
+		}
+	}
+
+	for key := range s.storageCache {
+		if strings.HasPrefix(key, account) {
+			delete(s.storageCache, key)
+		}
+	}
+
+	s.removedStorage[account] = collection
+	return nil
+}
+
+// removeStorage deletes the slots of account at their composite keys, as
+// well as the ones not yet migrated from their slot keys.
+func (s *stateManager) removeStorage(account, collection string) error {
+	if collection != "" {
+		return s.removeCollectionStorage(account, collection)
 	}
 
-	return s.stub.DelState(address.String())
+	address, err := crypto.AddressFromHexString(account)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]bool)
+
+	start, end := storageRange(address)
+	iter, err := s.stub.GetStateByRange(start, end)
+	if err != nil {
+		return err
+	}
+	if err = collectKeys(iter, keys); err != nil {
+		return err
+	}
+
+	iter, err = s.stub.GetStateByPartialCompositeKey(StorageObjectType, []string{account})
+	if err != nil {
+		return err
+	}
+	if err = collectKeys(iter, keys); err != nil {
+		return err
+	}
+
+	for slot := range s.storageCache {
+		if strings.HasPrefix(slot, account) {
+			compKey, err := storageKey(slot)
+			if err != nil {
+				return err
+			}
+			keys[compKey] = true
+		}
+	}
+
+	return s.deleteStorage(collection, sortedKeys(keys)...)
+}
+
+// removeCollectionStorage deletes the slots of account kept in collection,
+// found from their index rather than by querying the collection, which the
+// transaction may have written to. Each slot is deleted at its composite key
+// and at its slot key, along with its index. Slots written to the collection
+// by earlier versions of the chaincode are not indexed, and are left for
+// RemoveOrphanedCollectionStorage.
+func (s *stateManager) removeCollectionStorage(account, collection string) error {
+	slots := make(map[string]bool)
+
+	iter, err := s.stub.GetStateByPartialCompositeKey(CollectionSlotObjectType, []string{account})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return err
+		}
+		if _, slot, ok := splitSlotKey(CollectionSlotObjectType, kv.Key); ok {
+			slots[account+slot] = true
+		}
+	}
+
+	for slot := range s.storageCache {
+		if strings.HasPrefix(slot, account) {
+			slots[slot] = true
+		}
+	}
+
+	for _, slot := range sortedKeys(slots) {
+		compKey, err := storageKey(slot)
+		if err != nil {
+			return err
+		}
+
+		if err = s.deleteStorage(collection, compKey, slot); err != nil {
+			return err
+		}
+
+		if err = unindexSlot(s.stub, slot); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteStorage deletes keys from the public world state, or from collection
+// when it is not empty.
+func (s *stateManager) deleteStorage(collection string, keys ...string) error {
+	for _, key := range keys {
+		var err error
+		if collection == "" {
+			err = s.stub.DelState(key)
+		} else {
+			err = s.stub.DelPrivateData(collection, key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// collectKeys adds the keys returned by iter to keys.
+func collectKeys(iter shim.StateQueryIteratorInterface, keys map[string]bool) error {
+	defer iter.Close()
+
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return err
+		}
+		keys[kv.Key] = true
+	}
+
+	return nil
+}
+
+// writable pushes an illegal write error, and returns false, when the state
+// is read-only.
+func (st *stateManager) writable(address crypto.Address) bool {
+	if st.readonly {
+		st.PushError(errors.ErrorCodef(errors.ErrorCodeIllegalWrite,
+			"tried to modify %v in a read-only state", address))
+		return false
+	}
+	return true
 }
 
 func (st *stateManager) mustAccount(address crypto.Address) *acm.Account {
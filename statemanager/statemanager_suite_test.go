@@ -7,6 +7,15 @@ SPDX-License-Identifier: Apache-2.0
 package statemanager_test
 
 import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-evm/mocks/evmcc"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -17,3 +26,162 @@ func TestStatemanager(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Statemanager Suite")
 }
+
+// ledgerStub returns a stub backed by ledger, answering range and
+// partial composite key queries in the order of the keys, page by page when
+// asked to.
+func ledgerStub(ledger map[string][]byte) *evmcc.MockStub {
+	stub := &evmcc.MockStub{}
+
+	stub.PutStateStub = func(key string, value []byte) error {
+		ledger[key] = value
+		return nil
+	}
+	stub.GetStateStub = func(key string) ([]byte, error) {
+		return ledger[key], nil
+	}
+	stub.DelStateStub = func(key string) error {
+		delete(ledger, key)
+		return nil
+	}
+
+	query := func(match func(key string) bool) []*queryresult.KV {
+		return queryLedger(ledger, match)
+	}
+	page := func(kvs []*queryresult.KV, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+		for len(kvs) > 0 && kvs[0].Key < bookmark {
+			kvs = kvs[1:]
+		}
+
+		metadata := &pb.QueryResponseMetadata{}
+		if len(kvs) > int(pageSize) {
+			metadata.Bookmark = kvs[pageSize].Key
+			kvs = kvs[:pageSize]
+		}
+		metadata.FetchedRecordsCount = int32(len(kvs))
+		return iterator(kvs...), metadata, nil
+	}
+	stub.GetStateByRangeStub = func(start, end string) (shim.StateQueryIteratorInterface, error) {
+		return iterator(query(inRange(start, end))...), nil
+	}
+	stub.GetStateByPartialCompositeKeyStub = func(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+		return iterator(query(withPrefix(objectType, attributes))...), nil
+	}
+	stub.GetStateByRangeWithPaginationStub = func(start, end string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+		return page(query(inRange(start, end)), pageSize, bookmark)
+	}
+	stub.GetStateByPartialCompositeKeyWithPaginationStub = func(objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+		return page(query(withPrefix(objectType, attributes)), pageSize, bookmark)
+	}
+
+	return stub
+}
+
+// privateLedgerStub returns a ledgerStub that also keeps private data, in
+// private by collection. Like Fabric, it fails queries of private data once
+// the transaction has written, and writes once it has queried private data.
+func privateLedgerStub(ledger map[string][]byte, private map[string]map[string][]byte) *evmcc.MockStub {
+	stub := ledgerStub(ledger)
+
+	wrote, queried := false, false
+	checkWrite := func() error {
+		if queried {
+			return errors.New("transaction has already performed queries on pvt data. Writes are not allowed")
+		}
+		wrote = true
+		return nil
+	}
+	checkQuery := func() error {
+		if wrote {
+			return errors.New("queries on pvt data is supported only in a read-only transaction")
+		}
+		queried = true
+		return nil
+	}
+	collection := func(name string) map[string][]byte {
+		if private[name] == nil {
+			private[name] = make(map[string][]byte)
+		}
+		return private[name]
+	}
+
+	putState, delState := stub.PutStateStub, stub.DelStateStub
+	stub.PutStateStub = func(key string, value []byte) error {
+		if err := checkWrite(); err != nil {
+			return err
+		}
+		return putState(key, value)
+	}
+	stub.DelStateStub = func(key string) error {
+		if err := checkWrite(); err != nil {
+			return err
+		}
+		return delState(key)
+	}
+
+	stub.GetPrivateDataStub = func(name, key string) ([]byte, error) {
+		return collection(name)[key], nil
+	}
+	stub.PutPrivateDataStub = func(name, key string, value []byte) error {
+		if err := checkWrite(); err != nil {
+			return err
+		}
+		collection(name)[key] = value
+		return nil
+	}
+	stub.DelPrivateDataStub = func(name, key string) error {
+		if err := checkWrite(); err != nil {
+			return err
+		}
+		delete(collection(name), key)
+		return nil
+	}
+	stub.GetPrivateDataByRangeStub = func(name, start, end string) (shim.StateQueryIteratorInterface, error) {
+		if err := checkQuery(); err != nil {
+			return nil, err
+		}
+		return iterator(queryLedger(collection(name), inRange(start, end))...), nil
+	}
+	stub.GetPrivateDataByPartialCompositeKeyStub = func(name, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+		if err := checkQuery(); err != nil {
+			return nil, err
+		}
+		return iterator(queryLedger(collection(name), withPrefix(objectType, attributes))...), nil
+	}
+
+	return stub
+}
+
+// queryLedger returns the entries of ledger whose keys match, in the order of
+// the keys.
+func queryLedger(ledger map[string][]byte, match func(key string) bool) []*queryresult.KV {
+	keys := []string{}
+	for key := range ledger {
+		if match(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	kvs := make([]*queryresult.KV, 0, len(keys))
+	for _, key := range keys {
+		kvs = append(kvs, &queryresult.KV{Key: key, Value: ledger[key]})
+	}
+	return kvs
+}
+
+// inRange matches the keys of a range query, which leaves out composite keys.
+func inRange(start, end string) func(key string) bool {
+	return func(key string) bool {
+		return !strings.HasPrefix(key, "\x00") && key >= start && (end == "" || key < end)
+	}
+}
+
+// withPrefix matches the keys of a partial composite key query.
+func withPrefix(objectType string, attributes []string) func(key string) bool {
+	prefix, err := shim.CreateCompositeKey(objectType, attributes)
+	Expect(err).ToNot(HaveOccurred())
+	return func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	}
+}
@@ -13,9 +13,12 @@ import (
 	"github.com/hyperledger/burrow/acm"
 	"github.com/hyperledger/burrow/binary"
 	"github.com/hyperledger/burrow/crypto"
+	burrowerrors "github.com/hyperledger/burrow/execution/errors"
 
 	"github.com/hyperledger/fabric-chaincode-evm/mocks/evmcc"
 	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -57,6 +60,9 @@ var _ = Describe("Statemanager", func() {
 			delete(fakePutLedger, key)
 			return nil
 		}
+
+		mockStub.GetStateByRangeReturns(&evmcc.MockStateQueryIterator{}, nil)
+		mockStub.GetStateByPartialCompositeKeyReturns(&evmcc.MockStateQueryIterator{}, nil)
 	})
 
 	Describe("GetAccount", func() {
@@ -117,7 +123,9 @@ var _ = Describe("Statemanager", func() {
 		})
 
 		It("returns the value associated with the key", func() {
-			fakeGetLedger[addr.String()+hex.EncodeToString(key.Bytes())] = expectedVal.Bytes()
+			compKey, err := shim.CreateCompositeKey(statemanager.StorageObjectType, []string{addr.String(), hex.EncodeToString(key.Bytes())})
+			Expect(err).ToNot(HaveOccurred())
+			fakeGetLedger[compKey] = expectedVal.Bytes()
 
 			val := sm.GetStorage(addr, key)
 			Expect(sm.Error()).ToNot(HaveOccurred())
@@ -125,6 +133,17 @@ var _ = Describe("Statemanager", func() {
 			Expect(val).To(Equal(expectedVal))
 		})
 
+		Context("when the slot has not been migrated to a composite key", func() {
+			It("returns the value at the slot key", func() {
+				fakeGetLedger[addr.String()+hex.EncodeToString(key.Bytes())] = expectedVal.Bytes()
+
+				val := sm.GetStorage(addr, key)
+				Expect(sm.Error()).ToNot(HaveOccurred())
+
+				Expect(val).To(Equal(expectedVal))
+			})
+		})
+
 		Context("when GetState returns an error", func() {
 			BeforeEach(func() {
 				mockStub.GetStateReturns(nil, errors.New("boom!"))
@@ -286,7 +305,7 @@ var _ = Describe("Statemanager", func() {
 
 				Expect(sm.Exists(addr)).To(Equal(true))
 
-				Expect(mockStub.PutStateCallCount()).To(Equal(1))
+				Expect(mockStub.PutStateCallCount()).To(Equal(2))
 
 				account, _ := sm.GetAccount(addr)
 
@@ -296,6 +315,9 @@ var _ = Describe("Statemanager", func() {
 
 				Expect(key).To(Equal(addr.String()))
 				Expect(code).To(Equal(serializedAccount))
+
+				key, _ = mockStub.PutStateArgsForCall(1)
+				Expect(key).To(Equal(accountIndexKey(addr)))
 			})
 		})
 
@@ -313,6 +335,37 @@ var _ = Describe("Statemanager", func() {
 			})
 		})
 
+		Context("when the account has only received value", func() {
+			BeforeEach(func() {
+				account := acm.Account{Address: addr, Balance: 10}
+
+				fakeGetLedger[addr.String()], _ = account.Marshal()
+			})
+
+			It("returns an error", func() {
+				sm.CreateAccount(addr)
+
+				Expect(mockStub.PutStateCallCount()).To(Equal(0))
+				Expect(sm.Error()).To(HaveOccurred())
+			})
+
+			It("keeps the account of a contract being deployed", func() {
+				sm.CreateContractAccount(addr)
+
+				Expect(sm.Error()).ToNot(HaveOccurred())
+				Expect(mockStub.PutStateCallCount()).To(Equal(0))
+				Expect(sm.GetBalance(addr)).To(Equal(uint64(10)))
+			})
+
+			It("keeps the account created by the vm", func() {
+				frame := sm.NewCache().(statemanager.StateManager)
+				frame.CreateAccount(addr)
+
+				Expect(frame.Error()).ToNot(HaveOccurred())
+				Expect(frame.GetBalance(addr)).To(Equal(uint64(10)))
+			})
+		})
+
 		Context("when stub throws an error", func() {
 			BeforeEach(func() {
 				mockStub.PutStateReturns(errors.New("boom!"))
@@ -343,7 +396,7 @@ var _ = Describe("Statemanager", func() {
 				sm.CreateAccount(addr)
 
 				Expect(sm.Error()).ToNot(HaveOccurred())
-				Expect(mockStub.PutStateCallCount()).To(Equal(1))
+				Expect(mockStub.PutStateCallCount()).To(Equal(2))
 
 				fakeGetLedger[addr.String()] = fakePutLedger[addr.String()]
 
@@ -354,13 +407,14 @@ var _ = Describe("Statemanager", func() {
 				sm.InitCode(addr, initialCode)
 				Expect(sm.Error()).ToNot(HaveOccurred())
 
-				Expect(mockStub.PutStateCallCount()).To(Equal(2))
+				// The account is in the ledger, its index is not written again
+				Expect(mockStub.PutStateCallCount()).To(Equal(3))
 
 				account.Code = initialCode
 
 				serializedAccount, _ := account.Marshal()
 
-				key, code := mockStub.PutStateArgsForCall(1)
+				key, code := mockStub.PutStateArgsForCall(2)
 
 				Expect(key).To(Equal(addr.String()))
 				Expect(code).To(Equal(serializedAccount))
@@ -404,6 +458,29 @@ var _ = Describe("Statemanager", func() {
 		})
 	})
 
+	Describe("UpgradeCode", func() {
+		Context("when the account has code", func() {
+			It("replaces the code", func() {
+				fakeGetLedger[addr.String()], _ = (&acm.Account{Address: addr, Code: []byte("old code")}).Marshal()
+
+				sm.UpgradeCode(addr, []byte("new code"))
+				Expect(sm.Error()).ToNot(HaveOccurred())
+
+				Expect(sm.GetCode(addr).Bytes()).To(Equal([]byte("new code")))
+			})
+		})
+
+		Context("when the account has no code", func() {
+			It("returns an error", func() {
+				fakeGetLedger[addr.String()], _ = (&acm.Account{Address: addr}).Marshal()
+
+				sm.UpgradeCode(addr, []byte("new code"))
+				Expect(sm.Error()).To(HaveOccurred())
+				Expect(mockStub.PutStateCallCount()).To(Equal(0))
+			})
+		})
+	})
+
 	Describe("RemoveAccount", func() {
 		Context("when the account existed previously", func() {
 			It("removes the account", func() {
@@ -416,9 +493,64 @@ var _ = Describe("Statemanager", func() {
 				sm.RemoveAccount(addr)
 				Expect(sm.Error()).ToNot(HaveOccurred())
 
-				Expect(mockStub.DelStateCallCount()).To(Equal(1))
+				Expect(mockStub.DelStateCallCount()).To(Equal(2))
 				delAddr := mockStub.DelStateArgsForCall(0)
 				Expect(delAddr).To(Equal(addr.String()))
+				Expect(mockStub.DelStateArgsForCall(1)).To(Equal(accountIndexKey(addr)))
+			})
+		})
+
+		Context("when the account has storage", func() {
+			var committedKey, legacyKey, writtenKey string
+
+			BeforeEach(func() {
+				fakeGetLedger[addr.String()], _ = (&acm.Account{Address: addr}).Marshal()
+
+				legacyKey = addr.String() + hex.EncodeToString(binary.LeftPadWord256([]byte{3}).Bytes())
+				legacyIter := &evmcc.MockStateQueryIterator{}
+				legacyIter.HasNextReturnsOnCall(0, true)
+				legacyIter.NextReturns(&queryresult.KV{Key: legacyKey}, nil)
+				mockStub.GetStateByRangeReturns(legacyIter, nil)
+
+				var err error
+				committedKey, err = shim.CreateCompositeKey(statemanager.StorageObjectType, []string{addr.String(), hex.EncodeToString(binary.LeftPadWord256([]byte{1}).Bytes())})
+				Expect(err).ToNot(HaveOccurred())
+				iter := &evmcc.MockStateQueryIterator{}
+				iter.HasNextReturnsOnCall(0, true)
+				iter.NextReturns(&queryresult.KV{Key: committedKey}, nil)
+				mockStub.GetStateByPartialCompositeKeyReturns(iter, nil)
+
+				sm.SetStorage(addr, binary.LeftPadWord256([]byte{2}), binary.LeftPadWord256([]byte{42}))
+				writtenKey, err = shim.CreateCompositeKey(statemanager.StorageObjectType, []string{addr.String(), hex.EncodeToString(binary.LeftPadWord256([]byte{2}).Bytes())})
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("removes the storage with the account", func() {
+				sm.RemoveAccount(addr)
+				Expect(sm.Error()).ToNot(HaveOccurred())
+
+				start, end := mockStub.GetStateByRangeArgsForCall(0)
+				Expect(legacyKey > start && legacyKey < end).To(BeTrue())
+
+				objectType, attributes := mockStub.GetStateByPartialCompositeKeyArgsForCall(0)
+				Expect(objectType).To(Equal(statemanager.StorageObjectType))
+				Expect(attributes).To(Equal([]string{addr.String()}))
+
+				var deleted []string
+				for i := 0; i < mockStub.DelStateCallCount(); i++ {
+					deleted = append(deleted, mockStub.DelStateArgsForCall(i))
+				}
+				Expect(deleted).To(ConsistOf(legacyKey, committedKey, writtenKey, addr.String(), accountIndexKey(addr)))
+			})
+
+			It("reads the removed storage as zero", func() {
+				fakeGetLedger[committedKey] = binary.LeftPadWord256([]byte{42}).Bytes()
+
+				sm.RemoveAccount(addr)
+				Expect(sm.Error()).ToNot(HaveOccurred())
+
+				Expect(sm.GetStorage(addr, binary.LeftPadWord256([]byte{1}))).To(Equal(binary.Zero256))
+				Expect(sm.GetStorage(addr, binary.LeftPadWord256([]byte{2}))).To(Equal(binary.Zero256))
 			})
 		})
 
@@ -453,7 +585,9 @@ var _ = Describe("Statemanager", func() {
 
 			initialVal = binary.LeftPadWord256([]byte("storage-value"))
 			key = binary.LeftPadWord256([]byte("key"))
-			compKey = addr.String() + hex.EncodeToString(key.Bytes())
+			var err error
+			compKey, err = shim.CreateCompositeKey(statemanager.StorageObjectType, []string{addr.String(), hex.EncodeToString(key.Bytes())})
+			Expect(err).ToNot(HaveOccurred())
 		})
 
 		Context("when key already exists", func() {
@@ -485,6 +619,26 @@ var _ = Describe("Statemanager", func() {
 			})
 		})
 
+		Context("when the value is zero", func() {
+			BeforeEach(func() {
+				fakeGetLedger[compKey] = initialVal.Bytes()
+				fakeGetLedger[addr.String()+hex.EncodeToString(key.Bytes())] = initialVal.Bytes()
+			})
+
+			It("deletes the slot instead of writing zero", func() {
+				sm.SetStorage(addr, key, binary.Zero256)
+				Expect(sm.Error()).ToNot(HaveOccurred())
+
+				Expect(mockStub.PutStateCallCount()).To(Equal(0))
+				Expect(mockStub.DelStateCallCount()).To(Equal(2))
+				Expect(mockStub.DelStateArgsForCall(0)).To(Equal(compKey))
+				Expect(mockStub.DelStateArgsForCall(1)).To(Equal(addr.String() + hex.EncodeToString(key.Bytes())))
+
+				Expect(sm.GetStorage(addr, key)).To(Equal(binary.Zero256))
+				Expect(sm.Error()).ToNot(HaveOccurred())
+			})
+		})
+
 		Context("when stub throws an error", func() {
 			BeforeEach(func() {
 				mockStub.PutStateReturns(errors.New("boom!"))
@@ -514,7 +668,7 @@ var _ = Describe("Statemanager", func() {
 
 				sm.AddToBalance(addr, 102345)
 
-				Expect(mockStub.PutStateCallCount()).To(Equal(2))
+				Expect(mockStub.PutStateCallCount()).To(Equal(3))
 
 				fakeGetLedger[addr.String()] = fakePutLedger[addr.String()]
 
@@ -532,13 +686,13 @@ var _ = Describe("Statemanager", func() {
 
 				sm.AddToBalance(addr, 102345)
 
-				Expect(mockStub.PutStateCallCount()).To(Equal(2))
+				Expect(mockStub.PutStateCallCount()).To(Equal(3))
 
 				sm.AddToBalance(addr, 102)
 
 				fakeGetLedger[addr.String()] = fakePutLedger[addr.String()]
 
-				Expect(mockStub.PutStateCallCount()).To(Equal(3))
+				Expect(mockStub.PutStateCallCount()).To(Equal(4))
 
 				Expect(sm.GetBalance(addr)).To(Equal(uint64(102345 + 102)))
 			})
@@ -600,7 +754,7 @@ var _ = Describe("Statemanager", func() {
 
 				Expect(sm.Error()).To(HaveOccurred())
 
-				Expect(mockStub.PutStateCallCount()).To(Equal(1))
+				Expect(mockStub.PutStateCallCount()).To(Equal(2))
 
 				Expect(sm.GetBalance(addr)).To(Equal(uint64(0)))
 			})
@@ -618,13 +772,13 @@ var _ = Describe("Statemanager", func() {
 
 				Expect(sm.Error()).To(HaveOccurred())
 
-				Expect(mockStub.PutStateCallCount()).To(Equal(1))
+				Expect(mockStub.PutStateCallCount()).To(Equal(2))
 
 				sm.SubtractFromBalance(addr, 102)
 
 				Expect(sm.Error()).To(HaveOccurred())
 
-				Expect(mockStub.PutStateCallCount()).To(Equal(1))
+				Expect(mockStub.PutStateCallCount()).To(Equal(2))
 
 				Expect(sm.GetBalance(addr)).To(Equal(uint64(0)))
 			})
@@ -676,4 +830,131 @@ var _ = Describe("Statemanager", func() {
 			})
 		})
 	})
+
+	Describe("NewCache", func() {
+		var (
+			key, value binary.Word256
+			compKey    string
+		)
+
+		BeforeEach(func() {
+			key = binary.LeftPadWord256([]byte("key"))
+			value = binary.LeftPadWord256([]byte("value"))
+			var err error
+			compKey, err = shim.CreateCompositeKey(statemanager.StorageObjectType, []string{addr.String(), hex.EncodeToString(key.Bytes())})
+			Expect(err).ToNot(HaveOccurred())
+
+			fakeGetLedger[addr.String()], _ = (&acm.Account{Address: addr, Balance: 123}).Marshal()
+		})
+
+		It("keeps the writes of the frame until it is synced", func() {
+			cache := sm.NewCache()
+			cache.SetStorage(addr, key, value)
+			cache.AddToBalance(addr, 10)
+			Expect(cache.Error()).ToNot(HaveOccurred())
+
+			Expect(cache.GetStorage(addr, key)).To(Equal(value))
+			Expect(cache.GetBalance(addr)).To(Equal(uint64(133)))
+			Expect(sm.GetStorage(addr, key)).To(Equal(binary.Zero256))
+			Expect(sm.GetBalance(addr)).To(Equal(uint64(123)))
+			Expect(mockStub.PutStateCallCount()).To(Equal(0))
+
+			Expect(cache.Sync()).To(BeNil())
+
+			Expect(sm.GetStorage(addr, key)).To(Equal(value))
+			Expect(sm.GetBalance(addr)).To(Equal(uint64(133)))
+			Expect(fakePutLedger).To(HaveKeyWithValue(compKey, value.Bytes()))
+			Expect(fakePutLedger).To(HaveKey(addr.String()))
+		})
+
+		It("discards the writes of a frame that is not synced", func() {
+			cache := sm.NewCache()
+			cache.SetStorage(addr, key, value)
+			Expect(cache.Error()).ToNot(HaveOccurred())
+
+			Expect(sm.GetStorage(addr, key)).To(Equal(binary.Zero256))
+			Expect(mockStub.PutStateCallCount()).To(Equal(0))
+		})
+
+		It("discards the writes of nested frames along with their parent", func() {
+			cache := sm.NewCache()
+			nested := cache.NewCache()
+			nested.SetStorage(addr, key, value)
+			Expect(nested.Sync()).To(BeNil())
+
+			Expect(cache.GetStorage(addr, key)).To(Equal(value))
+			Expect(sm.GetStorage(addr, key)).To(Equal(binary.Zero256))
+			Expect(mockStub.PutStateCallCount()).To(Equal(0))
+		})
+
+		It("removes an account once the frame is synced", func() {
+			fakeGetLedger[compKey] = value.Bytes()
+
+			cache := sm.NewCache()
+			cache.RemoveAccount(addr)
+			Expect(cache.Error()).ToNot(HaveOccurred())
+
+			Expect(cache.Exists(addr)).To(BeFalse())
+			Expect(cache.GetStorage(addr, key)).To(Equal(binary.Zero256))
+			Expect(sm.Exists(addr)).To(BeTrue())
+			Expect(mockStub.DelStateCallCount()).To(Equal(0))
+
+			Expect(cache.Sync()).To(BeNil())
+
+			Expect(sm.Exists(addr)).To(BeFalse())
+			Expect(sm.GetStorage(addr, key)).To(Equal(binary.Zero256))
+			Expect(mockStub.DelStateCallCount()).To(Equal(2))
+			Expect(mockStub.DelStateArgsForCall(0)).To(Equal(addr.String()))
+			Expect(mockStub.DelStateArgsForCall(1)).To(Equal(accountIndexKey(addr)))
+		})
+
+		It("does not sync a frame that has erred", func() {
+			cache := sm.NewCache()
+			cache.SetStorage(addr, key, value)
+			cache.SubtractFromBalance(addr, 1000)
+			Expect(cache.Error()).To(HaveOccurred())
+
+			Expect(cache.Sync()).To(HaveOccurred())
+			Expect(mockStub.PutStateCallCount()).To(Equal(0))
+		})
+	})
+
+	Describe("NewReadOnlyStateManager", func() {
+		BeforeEach(func() {
+			sm = statemanager.NewReadOnlyStateManager(mockStub)
+			fakeGetLedger[addr.String()], _ = (&acm.Account{Address: addr, Balance: 123}).Marshal()
+		})
+
+		It("reads the state", func() {
+			Expect(sm.GetBalance(addr)).To(Equal(uint64(123)))
+			Expect(sm.Error()).ToNot(HaveOccurred())
+		})
+
+		It("fails every write", func() {
+			sm.AddToBalance(addr, 10)
+			Expect(sm.Error()).To(HaveOccurred())
+			Expect(sm.Error().ErrorCode()).To(Equal(burrowerrors.ErrorCodeIllegalWrite))
+			Expect(mockStub.PutStateCallCount()).To(Equal(0))
+		})
+
+		It("fails to set the metadata of a contract", func() {
+			err := sm.SetMetadata(addr, &statemanager.ContractMetadata{Collection: "secrets"})
+			Expect(err).To(HaveOccurred())
+			Expect(mockStub.PutStateCallCount()).To(Equal(0))
+		})
+
+		It("keeps the caches it creates read-only", func() {
+			cache := sm.NewCache()
+			cache.SetStorage(addr, binary.LeftPadWord256([]byte("key")), binary.LeftPadWord256([]byte("value")))
+			Expect(cache.Error()).To(HaveOccurred())
+			Expect(mockStub.PutStateCallCount()).To(Equal(0))
+		})
+	})
 })
+
+// accountIndexKey returns the composite key indexing the account at address.
+func accountIndexKey(address crypto.Address) string {
+	key, err := shim.CreateCompositeKey(statemanager.AccountIndexObjectType, []string{address.String()})
+	Expect(err).ToNot(HaveOccurred())
+	return key
+}
@@ -0,0 +1,669 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package statemanager
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/burrow/binary"
+	"github.com/hyperledger/burrow/crypto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// StorageObjectType is the object type of the composite keys of storage
+// slots, made of the address of the contract and the hex encoded slot.
+const StorageObjectType = "evmcc.storage"
+
+// CollectionSlotObjectType is the object type of the composite keys indexing
+// the storage slots of the contracts kept in private data collections, made of
+// the address of the contract and the hex encoded slot. The index is kept in
+// the public world state since Fabric does not let a transaction query
+// private data once it has written, so the slots of a contract removed by a
+// transaction are looked up in the index instead. It reveals the slots
+// written, not their values.
+const CollectionSlotObjectType = "evmcc.collectionSlot"
+
+// compositeKeySeparator separates the object type and the attributes of a
+// composite key, and starts the key.
+const compositeKeySeparator = "\x00"
+
+// StorageSlot is a storage slot of a contract, hex encoded.
+type StorageSlot struct {
+	Slot  string `json:"slot"`
+	Value string `json:"value"`
+}
+
+// slotKey returns the key the frames of a transaction keep a storage slot
+// at, the address of the contract followed by the hex encoded slot. Storage
+// slots were kept at the same key in the ledger before composite keys.
+func slotKey(address crypto.Address, key binary.Word256) string {
+	return address.String() + hex.EncodeToString(key.Bytes())
+}
+
+// storageKey returns the composite key of a storage slot in the ledger from
+// its slot key.
+func storageKey(slotKey string) (string, error) {
+	return shim.CreateCompositeKey(StorageObjectType, []string{slotKey[:2*crypto.AddressLength], slotKey[2*crypto.AddressLength:]})
+}
+
+// collectionSlotKey returns the composite key indexing a storage slot kept in
+// a collection from its slot key.
+func collectionSlotKey(slotKey string) (string, error) {
+	return shim.CreateCompositeKey(CollectionSlotObjectType, []string{slotKey[:2*crypto.AddressLength], slotKey[2*crypto.AddressLength:]})
+}
+
+// splitStorageKey returns the account and the hex encoded slot of the
+// composite key of a storage slot.
+func splitStorageKey(key string) (string, string, bool) {
+	return splitSlotKey(StorageObjectType, key)
+}
+
+// splitSlotKey returns the account and the hex encoded slot of a composite
+// key of objectType made of them.
+func splitSlotKey(objectType, key string) (string, string, bool) {
+	parts := strings.Split(key, compositeKeySeparator)
+	if len(parts) != 5 || parts[1] != objectType {
+		return "", "", false
+	}
+	return parts[2], parts[3], true
+}
+
+// storageRange returns the range of slot keys holding the storage of a
+// contract before composite keys. The hex encoded slots sort between the two
+// keys, while the account and the metadata keys sort before.
+func storageRange(address crypto.Address) (string, string) {
+	return address.String() + "0", address.String() + "g"
+}
+
+// isStorageKey reports whether key is the slot key of a storage slot, and
+// returns the key of the account it belongs to.
+func isStorageKey(key string) (string, bool) {
+	if len(key) != 2*crypto.AddressLength+2*binary.Word256Length {
+		return "", false
+	}
+
+	if _, err := hex.DecodeString(key); err != nil {
+		return "", false
+	}
+
+	return key[:2*crypto.AddressLength], true
+}
+
+// legacyStorageRange returns the range of the keys following start that slot
+// keys, made of hex digits only, can be found in. The range starts after
+// start, the last key of a previous scan, or at the first slot key.
+func legacyStorageRange(start string) (string, string) {
+	if start == "" {
+		return "0", "g"
+	}
+	return start + "\x00", "g"
+}
+
+// scanLegacyStorage calls fn with the storage slots kept at slot keys in the
+// public world state, or in collection when it is not empty, scanning at most
+// limit keys after start. It returns the last key scanned, for the next scan
+// to continue after, or an empty string once no key is left. The iterators of
+// the peer are capped by its totalQueryLimit, and end early without saying
+// so, hence the scan is only known to be complete once it finds no key.
+// accountFn, when not nil, is called with the account keys scanned.
+//
+// Fabric only lets transactions that do not write query private data, so fn
+// and accountFn must not write when collection is not empty.
+func scanLegacyStorage(stub shim.ChaincodeStubInterface, collection, start string, limit int, fn func(key string, value []byte, exists bool) error, accountFn func(key string) error) (string, error) {
+	if limit <= 0 {
+		return "", fmt.Errorf("limit must be positive, got %d", limit)
+	}
+
+	from, to := legacyStorageRange(start)
+	var iter shim.StateQueryIteratorInterface
+	var err error
+	if collection == "" {
+		iter, err = stub.GetStateByRange(from, to)
+	} else {
+		iter, err = stub.GetPrivateDataByRange(collection, from, to)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer iter.Close()
+
+	exists := make(map[string]bool)
+	last := ""
+
+	for scanned := 0; scanned < limit && iter.HasNext(); scanned++ {
+		kv, err := iter.Next()
+		if err != nil {
+			return "", err
+		}
+		last = kv.Key
+
+		if accountFn != nil && isAccountKey(kv.Key) {
+			if err = accountFn(kv.Key); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		account, ok := isStorageKey(kv.Key)
+		if !ok {
+			continue
+		}
+
+		// Accounts are public, whichever collection their storage is in
+		found, checked := exists[account]
+		if !checked {
+			val, err := stub.GetState(account)
+			if err != nil {
+				return "", err
+			}
+			found = len(val) != 0
+			exists[account] = found
+		}
+
+		if err = fn(kv.Key, kv.Value, found); err != nil {
+			return "", err
+		}
+	}
+
+	return last, nil
+}
+
+// getSlot, putSlot and delSlot read and write a key of the public world
+// state, or of collection when it is not empty.
+
+func getSlot(stub shim.ChaincodeStubInterface, collection, key string) ([]byte, error) {
+	if collection == "" {
+		return stub.GetState(key)
+	}
+	return stub.GetPrivateData(collection, key)
+}
+
+func putSlot(stub shim.ChaincodeStubInterface, collection, key string, value []byte) error {
+	if collection == "" {
+		return stub.PutState(key, value)
+	}
+	return stub.PutPrivateData(collection, key, value)
+}
+
+func delSlot(stub shim.ChaincodeStubInterface, collection, key string) error {
+	if collection == "" {
+		return stub.DelState(key)
+	}
+	return stub.DelPrivateData(collection, key)
+}
+
+// RemoveOrphanedStorage deletes the storage slots of accounts that no longer
+// exist from the public world state, left behind by contracts that
+// self-destructed before their storage was removed with them.
+//
+// At most limit keys are scanned after start, so that the clean up can be
+// split over several transactions. The last key scanned is returned, to
+// continue after, or an empty string once no key is left.
+func RemoveOrphanedStorage(stub shim.ChaincodeStubInterface, start string, limit int) (int, string, error) {
+	removed := 0
+	next, err := scanLegacyStorage(stub, "", start, limit, func(key string, value []byte, exists bool) error {
+		if exists {
+			return nil
+		}
+
+		removed++
+		return delSlot(stub, "", key)
+	}, nil)
+
+	return removed, next, err
+}
+
+// MigrateStorage moves the storage slots of existing accounts from their
+// slot keys to composite keys in the public world state. A slot already
+// written at its composite key keeps its value, and a slot set to zero is
+// deleted rather than moved. Slots of accounts that no longer exist are left
+// for RemoveOrphanedStorage. The accounts scanned are indexed, for
+// ExportState to list the ones written before accounts were indexed.
+//
+// At most limit keys are scanned after start, the last key scanned is
+// returned, to continue after, or an empty string once no key is left.
+func MigrateStorage(stub shim.ChaincodeStubInterface, start string, limit int) (int, string, error) {
+	indexAccount := func(key string) error {
+		indexKey, err := accountIndexKey(key)
+		if err != nil {
+			return err
+		}
+
+		indexed, err := stub.GetState(indexKey)
+		if err != nil || len(indexed) != 0 {
+			return err
+		}
+		return stub.PutState(indexKey, []byte{1})
+	}
+
+	migrated := 0
+	next, err := scanLegacyStorage(stub, "", start, limit, func(key string, value []byte, exists bool) error {
+		if !exists {
+			return nil
+		}
+
+		migrated++
+		return migrateSlot(stub, "", key, value)
+	}, indexAccount)
+
+	return migrated, next, err
+}
+
+// migrateSlot moves the value of a slot key to its composite key, unless the
+// composite key has been written since, and deletes the slot key. A slot
+// moved to a collection is indexed.
+func migrateSlot(stub shim.ChaincodeStubInterface, collection, key string, value []byte) error {
+	compKey, err := storageKey(key)
+	if err != nil {
+		return err
+	}
+
+	current, err := getSlot(stub, collection, compKey)
+	if err != nil {
+		return err
+	}
+
+	if len(current) == 0 && !isZero(value) {
+		if err = putSlot(stub, collection, compKey, value); err != nil {
+			return err
+		}
+
+		if collection != "" {
+			if err = indexSlot(stub, key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return delSlot(stub, collection, key)
+}
+
+// CollectionStorageScan lists the storage slots kept at slot keys in a
+// collection, by the accounts they belong to. Next is the key to continue
+// the scan after, empty once done.
+type CollectionStorageScan struct {
+	// Orphaned are the slot keys of accounts that no longer exist.
+	Orphaned []string `json:"orphaned"`
+	// Slots are the slot keys of existing accounts, to move to composite keys.
+	Slots []string `json:"slots"`
+	Next  string   `json:"next,omitempty"`
+}
+
+// ScanCollectionStorage lists the storage slots kept at slot keys in
+// collection, scanning at most limit keys after start. Fabric only lets
+// transactions that do not write query private data, so the slots are listed
+// by a query, and passed to RemoveOrphanedCollectionStorage and
+// MigrateCollectionStorage, which read and write them by key.
+func ScanCollectionStorage(stub shim.ChaincodeStubInterface, collection, start string, limit int) (*CollectionStorageScan, error) {
+	if collection == "" {
+		return nil, fmt.Errorf("a collection is required")
+	}
+
+	scan := &CollectionStorageScan{Orphaned: []string{}, Slots: []string{}}
+	next, err := scanLegacyStorage(stub, collection, start, limit, func(key string, value []byte, exists bool) error {
+		if exists {
+			scan.Slots = append(scan.Slots, key)
+		} else {
+			scan.Orphaned = append(scan.Orphaned, key)
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	scan.Next = next
+	return scan, nil
+}
+
+// RemoveOrphanedCollectionStorage deletes the given slot keys from collection
+// when the accounts they belong to no longer exist. The keys are listed by
+// ScanCollectionStorage, keys whose account exists are left as they are. The
+// number of slots deleted is returned.
+func RemoveOrphanedCollectionStorage(stub shim.ChaincodeStubInterface, collection string, keys []string) (int, error) {
+	removed := 0
+	err := forCollectionSlots(stub, collection, keys, func(key string, value []byte, exists bool) error {
+		if exists {
+			return nil
+		}
+
+		removed++
+		return delSlot(stub, collection, key)
+	})
+
+	return removed, err
+}
+
+// MigrateCollectionStorage moves the given slot keys of existing accounts to
+// composite keys in collection, the way MigrateStorage does. The keys are
+// listed by ScanCollectionStorage, keys whose account no longer exists are
+// left for RemoveOrphanedCollectionStorage. The number of slots moved is
+// returned.
+func MigrateCollectionStorage(stub shim.ChaincodeStubInterface, collection string, keys []string) (int, error) {
+	migrated := 0
+	err := forCollectionSlots(stub, collection, keys, func(key string, value []byte, exists bool) error {
+		if !exists {
+			return nil
+		}
+
+		migrated++
+		return migrateSlot(stub, collection, key, value)
+	})
+
+	return migrated, err
+}
+
+// forCollectionSlots calls fn with the slot keys given that are still set in
+// collection, telling if their account exists. The keys are read one by one,
+// without querying the collection.
+func forCollectionSlots(stub shim.ChaincodeStubInterface, collection string, keys []string, fn func(key string, value []byte, exists bool) error) error {
+	if collection == "" {
+		return fmt.Errorf("a collection is required")
+	}
+
+	exists := make(map[string]bool)
+	for _, key := range keys {
+		account, ok := isStorageKey(key)
+		if !ok {
+			return fmt.Errorf("invalid slot key %q", key)
+		}
+
+		value, err := getSlot(stub, collection, key)
+		if err != nil {
+			return err
+		}
+		if len(value) == 0 {
+			continue
+		}
+
+		// Accounts are public, whichever collection their storage is in
+		found, checked := exists[account]
+		if !checked {
+			val, err := stub.GetState(account)
+			if err != nil {
+				return err
+			}
+			found = len(val) != 0
+			exists[account] = found
+		}
+
+		if err = fn(key, value, found); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// indexSlot indexes a slot kept in a collection, unless it is indexed in the
+// ledger already.
+func indexSlot(stub shim.ChaincodeStubInterface, slot string) error {
+	indexKey, err := collectionSlotKey(slot)
+	if err != nil {
+		return err
+	}
+
+	indexed, err := stub.GetState(indexKey)
+	if err != nil || len(indexed) != 0 {
+		return err
+	}
+	return stub.PutState(indexKey, []byte{1})
+}
+
+// unindexSlot removes the index of a slot kept in a collection.
+func unindexSlot(stub shim.ChaincodeStubInterface, slot string) error {
+	indexKey, err := collectionSlotKey(slot)
+	if err != nil {
+		return err
+	}
+	return stub.DelState(indexKey)
+}
+
+// CompactStorage deletes the given storage slots of a contract that are set
+// to zero, which earlier versions of the chaincode kept in the world state
+// rather than deleting them. The slots are hex encoded, and read from
+// collection when it is not empty. Reading a deleted slot returns zero as
+// before. The number of slots deleted is returned.
+//
+// The slots are found with ListStorage, whose paginated queries cannot be run
+// by a transaction that writes, so they are listed by a query beforehand.
+// A slot is compacted at its composite key as well as at the slot key it was
+// kept at before composite keys. Slots that are no longer zero are left as
+// they are.
+func CompactStorage(stub shim.ChaincodeStubInterface, address crypto.Address, collection string, slots []string) (int, error) {
+	removed := 0
+	for _, slot := range slots {
+		b, err := hex.DecodeString(slot)
+		if err != nil || len(b) != binary.Word256Length {
+			return 0, fmt.Errorf("invalid slot %q", slot)
+		}
+
+		legacyKey := address.String() + hex.EncodeToString(b)
+		compKey, err := storageKey(legacyKey)
+		if err != nil {
+			return 0, err
+		}
+
+		compacted := false
+		for _, key := range []string{compKey, legacyKey} {
+			value, err := getSlot(stub, collection, key)
+			if err != nil {
+				return 0, err
+			}
+
+			if len(value) == 0 || !isZero(value) {
+				continue
+			}
+
+			if err = delSlot(stub, collection, key); err != nil {
+				return 0, err
+			}
+
+			if key == compKey && collection != "" {
+				if err = unindexSlot(stub, legacyKey); err != nil {
+					return 0, err
+				}
+			}
+			compacted = true
+		}
+
+		if compacted {
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+// isZero reports whether value is the value of a storage slot set to zero.
+func isZero(value []byte) bool {
+	for _, b := range value {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// legacyBookmark starts the bookmarks of the slots not yet migrated to
+// composite keys, which ListStorage lists once the composite keys are
+// exhausted.
+const legacyBookmark = "legacy:"
+
+// ListStorage returns the storage slots of a contract from bookmark, in order,
+// at most pageSize of them. The storage is read from collection when it is
+// not empty. The bookmark of the next page is returned, or an empty string
+// once every slot has been listed.
+//
+// The public storage is read with paginated queries, so ListStorage can only
+// be used by transactions that do not write. Slots not yet migrated to
+// composite keys are listed after the others, unless their composite key has
+// been written since.
+func ListStorage(stub shim.ChaincodeStubInterface, address crypto.Address, collection, bookmark string, pageSize int) ([]StorageSlot, string, error) {
+	if pageSize <= 0 {
+		return nil, "", fmt.Errorf("page size must be positive, got %d", pageSize)
+	}
+
+	var page []StorageSlot
+	if !strings.HasPrefix(bookmark, legacyBookmark) {
+		var next string
+		var err error
+		page, next, err = listStorage(stub, address, collection, bookmark, pageSize)
+		if err != nil || next != "" {
+			return page, next, err
+		}
+
+		bookmark = legacyBookmark
+		if pageSize -= len(page); pageSize == 0 {
+			return page, bookmark, nil
+		}
+	}
+
+	legacy, next, err := listLegacyStorage(stub, address, collection, bookmark[len(legacyBookmark):], pageSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if next != "" {
+		next = legacyBookmark + next
+	}
+	return append(page, legacy...), next, nil
+}
+
+// listStorage lists the slots of a contract kept at composite keys, from the
+// slot bookmark.
+func listStorage(stub shim.ChaincodeStubInterface, address crypto.Address, collection, bookmark string, pageSize int) ([]StorageSlot, string, error) {
+	slotOf := func(key string) (string, bool) {
+		_, slot, ok := splitStorageKey(key)
+		return slot, ok
+	}
+
+	// Private data cannot be queried page by page, the slots before the
+	// bookmark are skipped
+	if collection != "" {
+		iter, err := stub.GetPrivateDataByPartialCompositeKey(collection, StorageObjectType, []string{address.String()})
+		if err != nil {
+			return nil, "", err
+		}
+		return readStorage(iter, bookmark, pageSize, slotOf)
+	}
+
+	start := ""
+	if bookmark != "" {
+		var err error
+		if start, err = storageKey(address.String() + bookmark); err != nil {
+			return nil, "", err
+		}
+	}
+
+	iter, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(StorageObjectType, []string{address.String()}, int32(pageSize), start)
+	if err != nil {
+		return nil, "", err
+	}
+
+	page, _, err := readStorage(iter, "", pageSize, slotOf)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The bookmark is the key following the page, empty once the query is
+	// exhausted
+	next, _ := slotOf(metadata.GetBookmark())
+	return page, next, nil
+}
+
+// listLegacyStorage lists the slots of a contract kept at slot keys, from the
+// slot bookmark, leaving out the slots written at their composite key since.
+func listLegacyStorage(stub shim.ChaincodeStubInterface, address crypto.Address, collection, bookmark string, pageSize int) ([]StorageSlot, string, error) {
+	slotOf := func(key string) (string, bool) {
+		if _, ok := isStorageKey(key); !ok {
+			return "", false
+		}
+		return key[2*crypto.AddressLength:], true
+	}
+
+	start, end := storageRange(address)
+	if bookmark != "" {
+		start = address.String() + bookmark
+	}
+
+	var page []StorageSlot
+	var next string
+	if collection == "" {
+		iter, metadata, err := stub.GetStateByRangeWithPagination(start, end, int32(pageSize), "")
+		if err != nil {
+			return nil, "", err
+		}
+
+		if page, _, err = readStorage(iter, "", pageSize, slotOf); err != nil {
+			return nil, "", err
+		}
+		next, _ = slotOf(metadata.GetBookmark())
+	} else {
+		iter, err := stub.GetPrivateDataByRange(collection, start, end)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if page, next, err = readStorage(iter, "", pageSize, slotOf); err != nil {
+			return nil, "", err
+		}
+	}
+
+	migrated := page[:0]
+	for _, slot := range page {
+		compKey, err := storageKey(address.String() + slot.Slot)
+		if err != nil {
+			return nil, "", err
+		}
+
+		current, err := getSlot(stub, collection, compKey)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if len(current) == 0 {
+			migrated = append(migrated, slot)
+		}
+	}
+
+	return migrated, next, nil
+}
+
+// readStorage reads the storage slots returned by iter from the slot
+// bookmark, at most pageSize of them, and returns the slot following them,
+// if iter has one. slotOf returns the slot of a key, or false for keys that
+// are not storage slots.
+func readStorage(iter shim.StateQueryIteratorInterface, bookmark string, pageSize int, slotOf func(key string) (string, bool)) ([]StorageSlot, string, error) {
+	defer iter.Close()
+
+	page := []StorageSlot{}
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, "", err
+		}
+
+		slot, ok := slotOf(kv.Key)
+		if !ok || slot < bookmark {
+			continue
+		}
+
+		if len(page) == pageSize {
+			return page, slot, nil
+		}
+
+		page = append(page, StorageSlot{
+			Slot:  slot,
+			Value: hex.EncodeToString(binary.LeftPadWord256(kv.Value).Bytes()),
+		})
+	}
+
+	return page, "", nil
+}
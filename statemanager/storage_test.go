@@ -0,0 +1,510 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package statemanager_test
+
+import (
+	"encoding/hex"
+
+	"github.com/hyperledger/burrow/binary"
+	"github.com/hyperledger/burrow/crypto"
+
+	"github.com/hyperledger/fabric-chaincode-evm/mocks/evmcc"
+	"github.com/hyperledger/fabric-chaincode-evm/statemanager"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RemoveOrphanedStorage", func() {
+
+	var (
+		mockStub              *evmcc.MockStub
+		liveAddr, removedAddr crypto.Address
+		liveSlot, orphanSlot  string
+		fakeLedger            map[string][]byte
+	)
+
+	BeforeEach(func() {
+		mockStub = &evmcc.MockStub{}
+
+		var err error
+		liveAddr, err = crypto.AddressFromBytes([]byte("0000000000000address"))
+		Expect(err).ToNot(HaveOccurred())
+		removedAddr, err = crypto.AddressFromBytes([]byte("0000000000000removed"))
+		Expect(err).ToNot(HaveOccurred())
+
+		slot := hex.EncodeToString(binary.LeftPadWord256([]byte{1}).Bytes())
+		liveSlot = liveAddr.String() + slot
+		orphanSlot = removedAddr.String() + slot
+
+		fakeLedger = map[string][]byte{
+			liveAddr.String():               []byte("account"),
+			liveAddr.String() + ".metadata": []byte("{}"),
+			liveSlot:                        []byte{42},
+			orphanSlot:                      []byte{42},
+		}
+
+		mockStub.GetStateStub = func(key string) ([]byte, error) {
+			return fakeLedger[key], nil
+		}
+
+		keys := []string{liveAddr.String(), liveAddr.String() + ".metadata", liveSlot, orphanSlot}
+		iter := &evmcc.MockStateQueryIterator{}
+		iter.HasNextStub = func() bool {
+			return iter.NextCallCount() < len(keys)
+		}
+		iter.NextStub = func() (*queryresult.KV, error) {
+			return &queryresult.KV{Key: keys[iter.NextCallCount()-1]}, nil
+		}
+		mockStub.GetStateByRangeReturns(iter, nil)
+	})
+
+	It("removes the slots of accounts that do not exist", func() {
+		removed, next, err := statemanager.RemoveOrphanedStorage(mockStub, "", 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(Equal(1))
+		Expect(next).To(Equal(orphanSlot))
+
+		Expect(mockStub.DelStateCallCount()).To(Equal(1))
+		Expect(mockStub.DelStateArgsForCall(0)).To(Equal(orphanSlot))
+
+		start, end := mockStub.GetStateByRangeArgsForCall(0)
+		Expect(start).To(Equal("0"))
+		Expect(end).To(Equal("g"))
+	})
+
+	It("returns the last key scanned once the limit is reached", func() {
+		removed, next, err := statemanager.RemoveOrphanedStorage(mockStub, "", 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(Equal(0))
+		Expect(next).To(Equal(liveAddr.String() + ".metadata"))
+		Expect(mockStub.DelStateCallCount()).To(Equal(0))
+	})
+
+	It("continues after the key given", func() {
+		mockStub.GetStateByRangeReturns(iterator(), nil)
+
+		removed, next, err := statemanager.RemoveOrphanedStorage(mockStub, orphanSlot, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(Equal(0))
+		Expect(next).To(BeEmpty())
+
+		start, _ := mockStub.GetStateByRangeArgsForCall(0)
+		Expect(start).To(Equal(orphanSlot + "\x00"))
+	})
+
+	It("rejects a limit that is not positive", func() {
+		_, _, err := statemanager.RemoveOrphanedStorage(mockStub, "", 0)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("MigrateStorage", func() {
+
+	var (
+		mockStub              *evmcc.MockStub
+		liveAddr, removedAddr crypto.Address
+		liveSlot, orphanSlot  string
+		fakeLedger            map[string][]byte
+	)
+
+	BeforeEach(func() {
+		mockStub = &evmcc.MockStub{}
+
+		var err error
+		liveAddr, err = crypto.AddressFromBytes([]byte("0000000000000address"))
+		Expect(err).ToNot(HaveOccurred())
+		removedAddr, err = crypto.AddressFromBytes([]byte("0000000000000removed"))
+		Expect(err).ToNot(HaveOccurred())
+
+		slot := hex.EncodeToString(binary.LeftPadWord256([]byte{1}).Bytes())
+		liveSlot = liveAddr.String() + slot
+		orphanSlot = removedAddr.String() + slot
+
+		fakeLedger = map[string][]byte{
+			liveAddr.String(): []byte("account"),
+			liveSlot:          []byte{42},
+			orphanSlot:        []byte{42},
+		}
+
+		mockStub.GetStateStub = func(key string) ([]byte, error) {
+			return fakeLedger[key], nil
+		}
+		mockStub.PutStateStub = func(key string, value []byte) error {
+			fakeLedger[key] = value
+			return nil
+		}
+		mockStub.DelStateStub = func(key string) error {
+			delete(fakeLedger, key)
+			return nil
+		}
+
+		mockStub.GetStateByRangeReturns(iterator(
+			&queryresult.KV{Key: liveAddr.String()},
+			&queryresult.KV{Key: liveSlot, Value: []byte{42}},
+			&queryresult.KV{Key: orphanSlot, Value: []byte{42}},
+		), nil)
+	})
+
+	It("moves the slots of existing accounts to composite keys", func() {
+		migrated, next, err := statemanager.MigrateStorage(mockStub, "", 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(migrated).To(Equal(1))
+		Expect(next).To(Equal(orphanSlot))
+
+		compKey, err := shim.CreateCompositeKey(statemanager.StorageObjectType, []string{liveAddr.String(), liveSlot[2*crypto.AddressLength:]})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fakeLedger).To(HaveKeyWithValue(compKey, []byte{42}))
+		Expect(fakeLedger).ToNot(HaveKey(liveSlot))
+		Expect(fakeLedger).To(HaveKey(orphanSlot))
+	})
+
+	It("indexes the accounts scanned", func() {
+		_, _, err := statemanager.MigrateStorage(mockStub, "", 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fakeLedger).To(HaveKey(accountIndexKey(liveAddr)))
+
+		mockStub.GetStateByRangeReturns(iterator(&queryresult.KV{Key: liveAddr.String()}), nil)
+		puts := mockStub.PutStateCallCount()
+
+		_, _, err = statemanager.MigrateStorage(mockStub, "", 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mockStub.PutStateCallCount()).To(Equal(puts))
+	})
+
+	It("keeps the value of a slot already written at its composite key", func() {
+		compKey, err := shim.CreateCompositeKey(statemanager.StorageObjectType, []string{liveAddr.String(), liveSlot[2*crypto.AddressLength:]})
+		Expect(err).ToNot(HaveOccurred())
+		fakeLedger[compKey] = []byte{43}
+
+		_, _, err = statemanager.MigrateStorage(mockStub, "", 10)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(fakeLedger).To(HaveKeyWithValue(compKey, []byte{43}))
+		Expect(fakeLedger).ToNot(HaveKey(liveSlot))
+	})
+
+	It("deletes the slots set to zero", func() {
+		fakeLedger[liveSlot] = binary.Zero256.Bytes()
+		mockStub.GetStateByRangeReturns(iterator(
+			&queryresult.KV{Key: liveAddr.String()},
+			&queryresult.KV{Key: liveSlot, Value: binary.Zero256.Bytes()},
+		), nil)
+
+		migrated, _, err := statemanager.MigrateStorage(mockStub, "", 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(migrated).To(Equal(1))
+
+		// Only the account is indexed
+		Expect(mockStub.PutStateCallCount()).To(Equal(1))
+		key, _ := mockStub.PutStateArgsForCall(0)
+		Expect(key).To(Equal(accountIndexKey(liveAddr)))
+		Expect(fakeLedger).ToNot(HaveKey(liveSlot))
+	})
+})
+
+var _ = Describe("Collection storage", func() {
+
+	var (
+		ledger                map[string][]byte
+		private               map[string]map[string][]byte
+		liveAddr, removedAddr crypto.Address
+		liveSlot, orphanSlot  string
+		compKey               string
+	)
+
+	BeforeEach(func() {
+		var err error
+		liveAddr, err = crypto.AddressFromBytes([]byte("0000000000000address"))
+		Expect(err).ToNot(HaveOccurred())
+		removedAddr, err = crypto.AddressFromBytes([]byte("0000000000000removed"))
+		Expect(err).ToNot(HaveOccurred())
+
+		slot := hex.EncodeToString(binary.LeftPadWord256([]byte{1}).Bytes())
+		liveSlot = liveAddr.String() + slot
+		orphanSlot = removedAddr.String() + slot
+		compKey, err = shim.CreateCompositeKey(statemanager.StorageObjectType, []string{liveAddr.String(), slot})
+		Expect(err).ToNot(HaveOccurred())
+
+		ledger = map[string][]byte{liveAddr.String(): []byte("account")}
+		private = map[string]map[string][]byte{
+			"collection1": {liveSlot: {42}, orphanSlot: {42}},
+		}
+	})
+
+	It("lists the slots by the accounts they belong to", func() {
+		scan, err := statemanager.ScanCollectionStorage(privateLedgerStub(ledger, private), "collection1", "", 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(scan.Orphaned).To(Equal([]string{orphanSlot}))
+		Expect(scan.Slots).To(Equal([]string{liveSlot}))
+		Expect(scan.Next).To(Equal(orphanSlot))
+	})
+
+	It("removes the orphaned slots given without querying the collection", func() {
+		stub := privateLedgerStub(ledger, private)
+		// The transaction writes before, which rules out queries of the
+		// collection
+		Expect(stub.PutState("key", []byte("value"))).To(Succeed())
+
+		removed, err := statemanager.RemoveOrphanedCollectionStorage(stub, "collection1", []string{liveSlot, orphanSlot})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(Equal(1))
+		Expect(private["collection1"]).To(Equal(map[string][]byte{liveSlot: {42}}))
+	})
+
+	It("moves the slots given of existing accounts to indexed composite keys", func() {
+		stub := privateLedgerStub(ledger, private)
+		Expect(stub.PutState("key", []byte("value"))).To(Succeed())
+
+		migrated, err := statemanager.MigrateCollectionStorage(stub, "collection1", []string{liveSlot, orphanSlot})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(migrated).To(Equal(1))
+		Expect(private["collection1"]).To(Equal(map[string][]byte{compKey: {42}, orphanSlot: {42}}))
+
+		indexKey, err := shim.CreateCompositeKey(statemanager.CollectionSlotObjectType, []string{liveAddr.String(), liveSlot[2*crypto.AddressLength:]})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ledger).To(HaveKey(indexKey))
+	})
+
+	It("rejects keys that are not slot keys", func() {
+		_, err := statemanager.MigrateCollectionStorage(privateLedgerStub(ledger, private), "collection1", []string{liveAddr.String()})
+		Expect(err).To(MatchError(ContainSubstring("invalid slot key")))
+	})
+})
+
+var _ = Describe("CompactStorage", func() {
+
+	var (
+		mockStub *evmcc.MockStub
+		ledger   map[string][]byte
+		addr     crypto.Address
+		slots    []string
+		keys     []string
+	)
+
+	BeforeEach(func() {
+		var err error
+		addr, err = crypto.AddressFromBytes([]byte("0000000000000address"))
+		Expect(err).ToNot(HaveOccurred())
+
+		ledger = make(map[string][]byte)
+		slots, keys = nil, nil
+		for i, value := range [][]byte{binary.Zero256.Bytes(), {42}, binary.Zero256.Bytes()} {
+			slot := hex.EncodeToString(binary.LeftPadWord256([]byte{byte(i + 1)}).Bytes())
+			compKey, err := shim.CreateCompositeKey(statemanager.StorageObjectType, []string{addr.String(), slot})
+			Expect(err).ToNot(HaveOccurred())
+			slots = append(slots, slot)
+			keys = append(keys, compKey)
+			ledger[compKey] = value
+		}
+		mockStub = ledgerStub(ledger)
+	})
+
+	It("deletes the slots given that are set to zero", func() {
+		removed, err := statemanager.CompactStorage(mockStub, addr, "", slots)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(Equal(2))
+
+		Expect(ledger).To(Equal(map[string][]byte{keys[1]: {42}}))
+		Expect(mockStub.GetStateByRangeCallCount()).To(Equal(0))
+		Expect(mockStub.GetStateByPartialCompositeKeyCallCount()).To(Equal(0))
+	})
+
+	It("leaves the slots that are not given or not stored", func() {
+		missing := hex.EncodeToString(binary.LeftPadWord256([]byte{4}).Bytes())
+
+		removed, err := statemanager.CompactStorage(mockStub, addr, "", []string{slots[1], missing})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(Equal(0))
+		Expect(ledger).To(HaveLen(3))
+		Expect(mockStub.DelStateCallCount()).To(Equal(0))
+	})
+
+	It("deletes the slots kept in a collection", func() {
+		mockStub.GetPrivateDataStub = func(collection, key string) ([]byte, error) {
+			Expect(collection).To(Equal("collection1"))
+			return ledger[key], nil
+		}
+
+		removed, err := statemanager.CompactStorage(mockStub, addr, "collection1", slots[:1])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(Equal(1))
+
+		Expect(mockStub.DelPrivateDataCallCount()).To(Equal(1))
+		collection, key := mockStub.DelPrivateDataArgsForCall(0)
+		Expect(collection).To(Equal("collection1"))
+		Expect(key).To(Equal(keys[0]))
+
+		// Only the index of the slot is deleted from the public world state
+		indexKey, err := shim.CreateCompositeKey(statemanager.CollectionSlotObjectType, []string{addr.String(), slots[0]})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mockStub.DelStateCallCount()).To(Equal(1))
+		Expect(mockStub.DelStateArgsForCall(0)).To(Equal(indexKey))
+	})
+
+	Context("when slots set to zero are kept at their slot keys", func() {
+		var legacyKeys []string
+
+		BeforeEach(func() {
+			legacyKeys = nil
+			for i, slot := range slots {
+				legacyKeys = append(legacyKeys, addr.String()+slot)
+				ledger[legacyKeys[i]] = ledger[keys[i]]
+				delete(ledger, keys[i])
+			}
+		})
+
+		It("deletes them as well", func() {
+			removed, err := statemanager.CompactStorage(mockStub, addr, "", slots)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(removed).To(Equal(2))
+
+			Expect(ledger).To(Equal(map[string][]byte{legacyKeys[1]: {42}}))
+			Expect(mockStub.GetStateByRangeCallCount()).To(Equal(0))
+		})
+
+		It("deletes them along with the composite key written since", func() {
+			ledger[keys[0]] = binary.Zero256.Bytes()
+
+			removed, err := statemanager.CompactStorage(mockStub, addr, "", slots[:1])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(removed).To(Equal(1))
+
+			Expect(ledger).ToNot(HaveKey(keys[0]))
+			Expect(ledger).ToNot(HaveKey(legacyKeys[0]))
+		})
+	})
+
+	It("rejects slots that are not hex encoded words", func() {
+		_, err := statemanager.CompactStorage(mockStub, addr, "", []string{"01"})
+		Expect(err).To(MatchError(`invalid slot "01"`))
+	})
+})
+
+var _ = Describe("ListStorage", func() {
+
+	var (
+		mockStub *evmcc.MockStub
+		ledger   map[string][]byte
+		addr     crypto.Address
+		slots    []string
+		compKeys []string
+	)
+
+	value := func(b byte) string {
+		return hex.EncodeToString(binary.LeftPadWord256([]byte{b}).Bytes())
+	}
+
+	BeforeEach(func() {
+		var err error
+		addr, err = crypto.AddressFromBytes([]byte("0000000000000address"))
+		Expect(err).ToNot(HaveOccurred())
+
+		slots, compKeys = nil, nil
+		for i := byte(1); i <= 3; i++ {
+			slot := hex.EncodeToString(binary.LeftPadWord256([]byte{i}).Bytes())
+			compKey, err := shim.CreateCompositeKey(statemanager.StorageObjectType, []string{addr.String(), slot})
+			Expect(err).ToNot(HaveOccurred())
+			slots = append(slots, slot)
+			compKeys = append(compKeys, compKey)
+		}
+
+		// The first slot is not migrated yet
+		ledger = map[string][]byte{
+			addr.String():            {1},
+			addr.String() + slots[0]: {42},
+			compKeys[1]:              {2},
+			compKeys[2]:              {3},
+		}
+		mockStub = ledgerStub(ledger)
+	})
+
+	It("lists the slots of the contract page by page", func() {
+		page, next, err := statemanager.ListStorage(mockStub, addr, "", "", 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(page).To(Equal([]statemanager.StorageSlot{
+			{Slot: slots[1], Value: value(2)},
+			{Slot: slots[2], Value: value(3)},
+		}))
+		Expect(next).ToNot(BeEmpty())
+
+		page, next, err = statemanager.ListStorage(mockStub, addr, "", next, 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(page).To(Equal([]statemanager.StorageSlot{
+			{Slot: slots[0], Value: value(42)},
+		}))
+		Expect(next).To(BeEmpty())
+
+		Expect(mockStub.GetStateByRangeCallCount()).To(Equal(0))
+		Expect(mockStub.GetStateByPartialCompositeKeyCallCount()).To(Equal(0))
+	})
+
+	It("starts the paginated query at the bookmark", func() {
+		page, next, err := statemanager.ListStorage(mockStub, addr, "", "", 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(page).To(Equal([]statemanager.StorageSlot{{Slot: slots[1], Value: value(2)}}))
+		Expect(next).To(Equal(slots[2]))
+
+		page, _, err = statemanager.ListStorage(mockStub, addr, "", next, 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(page).To(Equal([]statemanager.StorageSlot{{Slot: slots[2], Value: value(3)}}))
+
+		objectType, attributes, pageSize, bookmark := mockStub.GetStateByPartialCompositeKeyWithPaginationArgsForCall(1)
+		Expect(objectType).To(Equal(statemanager.StorageObjectType))
+		Expect(attributes).To(Equal([]string{addr.String()}))
+		Expect(pageSize).To(Equal(int32(1)))
+		Expect(bookmark).To(Equal(compKeys[2]))
+	})
+
+	It("leaves out the slots written at their composite key since", func() {
+		ledger[compKeys[0]] = []byte{7}
+
+		page, next, err := statemanager.ListStorage(mockStub, addr, "", "", 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(page).To(Equal([]statemanager.StorageSlot{
+			{Slot: slots[0], Value: value(7)},
+			{Slot: slots[1], Value: value(2)},
+			{Slot: slots[2], Value: value(3)},
+		}))
+		Expect(next).To(BeEmpty())
+	})
+
+	It("lists the slots kept in a collection", func() {
+		mockStub.GetPrivateDataByPartialCompositeKeyReturns(iterator(
+			&queryresult.KV{Key: compKeys[1], Value: []byte{2}},
+			&queryresult.KV{Key: compKeys[2], Value: []byte{3}},
+		), nil)
+
+		page, next, err := statemanager.ListStorage(mockStub, addr, "collection1", slots[2], 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(page).To(Equal([]statemanager.StorageSlot{{Slot: slots[2], Value: value(3)}}))
+		Expect(next).To(Equal("legacy:"))
+
+		collection, objectType, _ := mockStub.GetPrivateDataByPartialCompositeKeyArgsForCall(0)
+		Expect(collection).To(Equal("collection1"))
+		Expect(objectType).To(Equal(statemanager.StorageObjectType))
+	})
+
+	It("rejects a page size that is not positive", func() {
+		_, _, err := statemanager.ListStorage(mockStub, addr, "", "", 0)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+// iterator returns a query iterator over kvs.
+func iterator(kvs ...*queryresult.KV) *evmcc.MockStateQueryIterator {
+	iter := &evmcc.MockStateQueryIterator{}
+	iter.HasNextStub = func() bool {
+		return iter.NextCallCount() < len(kvs)
+	}
+	iter.NextStub = func() (*queryresult.KV, error) {
+		return kvs[iter.NextCallCount()-1], nil
+	}
+	return iter
+}